@@ -7,13 +7,11 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
 	"github.com/vitistack/ipam-api/internal/utils"
-	"github.com/vitistack/ipam-api/pkg/clients/mongodb"
 	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
 	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
-	"go.mongodb.org/mongo-driver/v2/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 var (
@@ -77,15 +75,12 @@ func init() {
 	RootCmd.AddCommand(deleteService)
 }
 
-// setServiceExpirationOnAddress sets an expiration date for a specific service associated with an address in the MongoDB database.
+// setServiceExpirationOnAddress sets an expiration date for a specific service associated with an address in the repository.
 // It performs the following steps:
-//  1. Initializes the MongoDB client using configuration from viper.
-//  2. Encrypts the provided secret deterministically.
-//  3. Finds the address document matching the encrypted secret, zone, and address.
-//  4. Checks if the specified service exists for the address.
-//  5. Removes the existing instance of the service from the address's services array.
-//  6. Adds the service back with an updated expiration date based on the retention period.
-//  7. Updates the address document in MongoDB with the modified services array.
+//  1. Opens the storage backend configured in storage.backend.
+//  2. Finds the address document matching the secret, zone, and address.
+//  3. Atomically replaces the service with an updated expiration date based on the retention period,
+//     failing if the specified service does not exist for the address.
 //
 // Parameters:
 //   - request: apicontracts.IpamAPIRequest containing the secret, zone, address, and service details.
@@ -93,97 +88,45 @@ func init() {
 // Returns:
 //   - error: An error if any step fails, or nil on success.
 func setServiceExpirationOnAddress(request apicontracts.IpamAPIRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	mongoConfig := mongodb.MongoConfig{
-		Host:     viper.GetString("mongodb.host"),
-		Username: viper.GetString("mongodb.username"),
-		Password: viper.GetString("mongodb.password"),
-	}
-	mongodb.InitClient(mongoConfig)
-
-	client := mongodb.GetClient()
-	collection := client.Database(viper.GetString("mongodb.database")).Collection(viper.GetString("mongodb.collection"))
-
-	encryptedSecret, err := utils.DeterministicEncrypt(request.Secret)
-
+	repo, err := openRepository(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt secret: %w", err)
+		return err
 	}
+	defer repo.Close(ctx)
 
-	// address := utils.NormalizeCIDR(request.Address)
-
-	filter := bson.M{
-		"secret":  encryptedSecret,
-		"zone":    request.Zone,
-		"address": utils.NormalizeCIDR(request.Address),
+	query := repository.AddressQuery{
+		Zone:    request.Zone,
+		Address: utils.NormalizeCIDR(request.Address),
 	}
 
-	var registeredAddress mongodbtypes.Address
-	err = collection.FindOne(context.Background(), filter).Decode(&registeredAddress)
+	registeredAddress, err := storageservice.FindAddressBySecret(ctx, request.Secret, query)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, repository.ErrNotFound) {
 			return errors.New("no matching address found with the provided secret, zone and address")
 		}
 		return fmt.Errorf("failed to read address document: %w", err)
 	}
 
-	if !serviceExists(registeredAddress.Services, mongodbtypes.Service(request.Service)) {
-		return errors.New("service does not exist for this address")
-	}
-
-	// Loop through the services array and remove the service that matches the request
-	var newServices []mongodbtypes.Service
-	for _, service := range registeredAddress.Services {
-		if !(service.NamespaceID == request.Service.NamespaceID &&
-			service.ServiceName == request.Service.ServiceName &&
-			service.ClusterID == request.Service.ClusterID) {
-			newServices = append(newServices, mongodbtypes.Service{
-				ServiceName:         service.ServiceName,
-				NamespaceID:         service.NamespaceID,
-				ClusterID:           service.ClusterID,
-				RetentionPeriodDays: service.RetentionPeriodDays,
-				ExpiresAt:           service.ExpiresAt,
-			})
-		}
-	}
-
-	// Add the service to be expired with an expiration date
-	var expiresAt *time.Time
+	// Set the expiration date on the service that matches the request
 	exp := time.Now().AddDate(0, 0, request.Service.RetentionPeriodDays)
-	expiresAt = &exp
-	newServices = append(newServices, mongodbtypes.Service{
+	service := mongodbtypes.Service{
 		ServiceName:         request.Service.ServiceName,
 		NamespaceID:         request.Service.NamespaceID,
 		ClusterID:           request.Service.ClusterID,
 		RetentionPeriodDays: request.Service.RetentionPeriodDays,
-		ExpiresAt:           expiresAt})
-
-	// Update mongodb
-	update := bson.M{
-		"$set": bson.M{
-			"services": newServices,
-		},
-	}
+		ExpiresAt:           &exp}
 
-	_, err = collection.UpdateOne(context.Background(), filter, update)
+	updated, err := repo.UpdateService(ctx, registeredAddress.ID, service)
 	if err != nil {
 		return fmt.Errorf("failed to update services array: %w", err)
 	}
+	if !updated {
+		return errors.New("service does not exist for this address")
+	}
 	fmt.Printf("Expiration set for service '%s' cluster id '%s' 'namespace id '%s' on address '%s'\n",
 		request.Service.ServiceName, request.Service.ClusterID, request.Service.NamespaceID, request.Address)
 	return nil
 }
-
-// serviceExists checks if a target Service exists within a slice of Service objects.
-// It returns true if a Service with matching NamespaceId, ServiceName, and ClusterId is found,
-// otherwise it returns false.
-func serviceExists(services []mongodbtypes.Service, target mongodbtypes.Service) bool {
-	for _, s := range services {
-		if s.NamespaceID == target.NamespaceID &&
-			s.ServiceName == target.ServiceName &&
-			s.ClusterID == target.ClusterID {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var migrate = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending storage migrations",
+	Long: `Create the indexes and backfill the fields that the running version of the IPAM-API expects.
+Migrations that were applied before are skipped. The IPAM-API also applies pending migrations when it starts.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runMigrate(); err != nil {
+			fmt.Println("Error:", err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(migrate)
+}
+
+// runMigrate applies the pending migrations of the configured storage backend and prints them.
+//
+// Returns:
+//   - error: if the storage cannot be opened or a migration fails.
+func runMigrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	repo, err := openRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer repo.Close(ctx)
+
+	migrations, err := repo.Migrate(ctx)
+	for _, migration := range migrations {
+		fmt.Printf("Applied migration %d: %s\n", migration.Version, migration.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) == 0 {
+		fmt.Println("Storage is up to date")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
+	"github.com/vitistack/ipam-api/internal/utils"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+)
+
+// migrateSecretsBatchSize is the number of addresses read at a time.
+const migrateSecretsBatchSize = 100
+
+var migrateSecrets = &cobra.Command{
+	Use:   "migrate-secrets",
+	Short: "Replace encrypted secrets with hashed secrets",
+	Long: `Replace the encrypted secrets stored by earlier versions with a lookup key and a hash. The API
+upgrades an address when it is used, this command upgrades the rest. Afterwards enc_key and enc_iv can be
+removed from the secrets file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runMigrateSecrets(); err != nil {
+			fmt.Println("Error:", err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(migrateSecrets)
+}
+
+// runMigrateSecrets decrypts the secret of every address with an encrypted secret and stores its lookup
+// key and hash instead. It can be run again after an interruption.
+//
+// Returns:
+//   - error: if the storage cannot be read or an address cannot be upgraded.
+func runMigrateSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	repo, err := openRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer repo.Close(ctx)
+
+	if !utils.LegacySecretsEnabled() {
+		return errors.New("enc_key and enc_iv are required to decrypt the stored secrets")
+	}
+
+	upgraded := 0
+	query := repository.AddressQuery{Limit: migrateSecretsBatchSize}
+	for {
+		addresses, err := repo.FindAddresses(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to read addresses: %w", err)
+		}
+
+		for _, address := range addresses {
+			if address.SchemaVersion >= mongodbtypes.AddressSchemaVersionHashedSecret {
+				continue
+			}
+
+			if err := upgradeEncryptedSecret(ctx, repo, address); err != nil {
+				return err
+			}
+			upgraded++
+		}
+
+		if len(addresses) < migrateSecretsBatchSize {
+			break
+		}
+		query.After = addresses[len(addresses)-1].ID
+	}
+
+	fmt.Printf("Upgraded the secrets of %d addresses\n", upgraded)
+	return nil
+}
+
+// upgradeEncryptedSecret decrypts the secret of an address stored by earlier versions and stores its lookup
+// key and hash instead.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - repo: The opened storage.
+//   - address: The address with an encrypted secret.
+//
+// Returns:
+//   - error: if the secret cannot be decrypted or the address cannot be updated.
+func upgradeEncryptedSecret(ctx context.Context, repo repository.Repository, address mongodbtypes.Address) error {
+	secret, err := utils.DeterministicDecrypt(address.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt secret of address %s: %w", address.Address, err)
+	}
+
+	update, err := storageservice.HashedSecret(secret)
+	if err != nil {
+		return err
+	}
+
+	if err := repo.UpdateAddress(ctx, address.ID, update); err != nil {
+		return fmt.Errorf("failed to upgrade secret of address %s: %w", address.Address, err)
+	}
+	return nil
+}
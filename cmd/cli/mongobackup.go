@@ -6,13 +6,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"github.com/vitistack/ipam-api/cmd/ipam-api/settings"
 	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/pkg/clients/mongodb"
 )
 
 var outPath string
@@ -58,14 +57,14 @@ func backup() error {
 
 	archivePath := filepath.Join(backupDir, sanitizedFilename)
 
-	// Build and validate MongoDB URI components
-	mongoURI, err := buildValidatedMongoURI()
+	// Connect the same way as the IPAM-API
+	mongoURI, err := mongodb.ConfigFromViper().ConnectionString()
 	if err != nil {
 		return fmt.Errorf("failed to build MongoDB URI: %w", err)
 	}
 
 	// Use literal arguments to prevent command injection
-	// #nosec G204 -- mongoURI and archivePath are validated above to prevent injection
+	// #nosec G204 -- mongoURI is built with escaped components and archivePath is validated above
 	cmd := exec.Command("mongodump",
 		"--uri", mongoURI,
 		"--archive", archivePath,
@@ -90,41 +89,6 @@ func backup() error {
 
 }
 
-func buildValidatedMongoURI() (string, error) {
-	host := viper.GetString("mongodb.host")
-	user := viper.GetString("mongodb.username")
-	pass := viper.GetString("mongodb.password")
-
-	// Validate required fields
-	if host == "" {
-		return "", fmt.Errorf("mongodb host is required")
-	}
-	if user == "" {
-		return "", fmt.Errorf("mongodb username is required")
-	}
-	if pass == "" {
-		return "", fmt.Errorf("mongodb password is required")
-	}
-
-	// Basic validation to prevent injection - ensure no special characters that could break URI
-	if containsInvalidChars(host) || containsInvalidChars(user) {
-		return "", fmt.Errorf("mongodb connection parameters contain invalid characters")
-	}
-
-	return fmt.Sprintf("mongodb://%s:%s@%s:27017/?authSource=admin&readPreference=primary&ssl=false", user, pass, host), nil
-}
-
-func containsInvalidChars(s string) bool {
-	// Check for characters that could cause URI injection or other issues
-	invalidChars := " @/?#[]\n\r\t"
-	for _, char := range invalidChars {
-		if strings.ContainsRune(s, char) {
-			return true
-		}
-	}
-	return false
-}
-
 func cleanupOldBackups(dir string) error {
 	files, err := os.ReadDir(dir)
 	if err != nil {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/internal/services/reconcileservice"
+)
+
+var (
+	reconcileRepair bool
+	reconcileFormat string
+)
+
+var reconcile = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Compare Netbox prefixes with MongoDB addresses",
+	Long: `Compare the Netbox prefixes managed by the IPAM-API with the address documents in MongoDB and
+report orphans in both directions and mismatched k8s_uuid, zones and prefixes.
+With --repair, MongoDB is treated as the source of truth and the findings are repaired where possible.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runReconcile(reconcileRepair); err != nil {
+			fmt.Println("Error:", err)
+		}
+	},
+}
+
+func init() {
+	reconcile.Flags().BoolVar(&reconcileRepair, "repair", false, "Repair the findings (optional, default report only)")
+	reconcile.Flags().StringVar(&reconcileFormat, "format", "", "Output format (optional, default text. Use 'json' for JSON output)")
+	RootCmd.AddCommand(reconcile)
+}
+
+// runReconcile reconciles Netbox and MongoDB and prints the report.
+//
+// Parameters:
+//   - repair: whether to repair the findings.
+//
+// Returns:
+//   - error: if Netbox or MongoDB cannot be read, or the report cannot be printed.
+func runReconcile(repair bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	repo, err := openRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer repo.Close(ctx)
+
+	if repair {
+		// Recreating prefixes needs the prefix containers of the zones
+		if err := netboxservice.Cache.FetchPrefixContainers(ctx); err != nil {
+			return fmt.Errorf("failed to fetch prefix containers: %w", err)
+		}
+	}
+
+	report, err := reconcileservice.Reconcile(ctx, repair)
+	if err != nil {
+		return err
+	}
+
+	if reconcileFormat == "json" {
+		jsonOutput, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		fmt.Println(string(jsonOutput))
+		return nil
+	}
+
+	for _, finding := range report.Findings {
+		status := ""
+		switch {
+		case finding.Repaired:
+			status = " [repaired]"
+		case finding.RepairError != "":
+			status = " [repair failed: " + finding.RepairError + "]"
+		}
+		fmt.Printf("%-18s netbox_id=%-8d address_id=%-24s %-20s %s%s\n",
+			finding.Kind, finding.NetboxID, finding.AddressID, finding.Address, finding.Detail, status)
+	}
+	fmt.Printf("Compared %d Netbox prefixes with %d addresses: %d findings\n",
+		report.NetboxPrefixes, report.Addresses, len(report.Findings))
+	return nil
+}
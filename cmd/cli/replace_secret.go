@@ -6,11 +6,9 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
 	"github.com/vitistack/ipam-api/internal/utils"
-	"github.com/vitistack/ipam-api/pkg/clients/mongodb"
-	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
-	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 var (
@@ -32,7 +30,7 @@ var replaceSecret = &cobra.Command{
 func init() {
 	replaceSecret.Flags().StringVar(&replaceSecretAddress, "address", "", "Address (required)")
 	replaceSecret.Flags().StringVar(&replaceSecretZone, "zone", "", "Zone (required)")
-	replaceSecret.Flags().StringVar(&newSecret, "new", "", "Zone (required)")
+	replaceSecret.Flags().StringVar(&newSecret, "new", "", "New secret (required)")
 	if err := replaceSecret.MarkFlagRequired("address"); err != nil {
 		fmt.Println("Error marking 'address' flag as required:", err)
 	}
@@ -45,56 +43,41 @@ func init() {
 	RootCmd.AddCommand(replaceSecret)
 }
 
-// setNewSecret updates the secret for a specific address and zone in the MongoDB database.
-// It retrieves the address document, encrypts the new secret deterministically, and updates the document.
+// setNewSecret updates the secret for a specific address and zone in the repository.
+// It retrieves the address document, hashes the new secret, and updates the document.
 // Parameters:
 //   - address: the address identifier to locate the document.
 //   - zone: the zone associated with the address.
-//   - newSecret: the new secret value to be encrypted and stored.
+//   - newSecret: the new secret value to be hashed and stored.
 //
-// Returns an error if the address is not found, encryption fails, or the update operation fails.
+// Returns an error if the address is not found, hashing fails, or the update operation fails.
 func setNewSecret(address, zone, newSecret string) error {
-	// Initialize MongoDB client
-	mongoConfig := mongodb.MongoConfig{
-		Host:     viper.GetString("mongodb.host"),
-		Username: viper.GetString("mongodb.username"),
-		Password: viper.GetString("mongodb.password"),
-	}
-
-	mongodb.InitClient(mongoConfig)
-
-	client := mongodb.GetClient()
-	collection := client.Database(viper.GetString("mongodb.database")).Collection(viper.GetString("mongodb.collection"))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	findOneFilter := bson.M{
-		"address": utils.NormalizeCIDR(address),
-		"zone":    zone,
+	repo, err := openRepository(ctx)
+	if err != nil {
+		return err
 	}
+	defer repo.Close(ctx)
 
-	var savedAddress mongodbtypes.Address
-	err := collection.FindOne(ctx, findOneFilter).Decode(&savedAddress)
-
-	if err != nil {
-		return fmt.Errorf("failed to find addresses: %w", err)
+	query := repository.AddressQuery{
+		Address: utils.NormalizeCIDR(address),
+		Zone:    zone,
 	}
 
-	encryptedSecret, err := utils.DeterministicEncrypt(newSecret)
+	savedAddress, err := repo.FindAddress(ctx, query)
 
 	if err != nil {
-		return fmt.Errorf("failed to encrypt secret: %w", err)
+		return fmt.Errorf("failed to find addresses: %w", err)
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"secret": encryptedSecret,
-		},
+	update, err := storageservice.HashedSecret(newSecret)
+	if err != nil {
+		return err
 	}
 
-	updateOneFilter := bson.M{"_id": savedAddress.ID}
-
-	_, err = collection.UpdateOne(context.Background(), updateOneFilter, update)
+	err = repo.UpdateAddress(ctx, savedAddress.ID, update)
 
 	if err != nil {
 		return fmt.Errorf("failed to update secret: %w", err)
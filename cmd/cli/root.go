@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
 	"github.com/vitistack/ipam-api/internal/version"
 
 	"github.com/spf13/cobra"
@@ -29,6 +32,18 @@ func init() {
 	RootCmd.AddCommand(versionCmd)
 }
 
+// openRepository opens the storage backend configured in storage.backend for a command.
+// With the bolt backend the database file is locked by a running IPAM-API, so stop it first.
+func openRepository(ctx context.Context) (repository.Repository, error) {
+	logger.InitConsoleLogger()
+
+	if err := repository.InitRepository(ctx); err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+
+	return repository.GetRepository(), nil
+}
+
 func Execute() {
 	cobra.CheckErr(RootCmd.Execute())
 }
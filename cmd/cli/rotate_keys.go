@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/utils"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	rotateKeysAfter     string
+	rotateKeysBatchSize int
+)
+
+var rotateKeys = &cobra.Command{
+	Use:   "rotate-keys",
+	Short: "Move stored secrets to the current secret lookup key",
+	Long: `Go through every address in batches and move its secret to the current secret lookup key
+(secret_lookup_key_id). Encrypted secrets stored by earlier versions are decrypted and hashed with the
+current key. Hashed secrets cannot be turned back into the secret, so addresses with a retired key are
+not re-keyed by this command. They are listed instead, and the API moves them to the current key the next
+time they are used.
+
+Retired keys must stay configured in secret_lookup_keys (or secret_lookup_key for the key "default") until
+this command no longer lists addresses using them. An address whose key has been removed can no longer be
+found with its secret, and has to be released and registered again.
+
+The ID of the last address of each batch is printed. After an interruption, pass it to --after to continue
+where the command stopped. Running the command again from the start is safe as well.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRotateKeys(rotateKeysAfter, rotateKeysBatchSize); err != nil {
+			fmt.Println("Error:", err)
+		}
+	},
+}
+
+func init() {
+	rotateKeys.Flags().StringVar(&rotateKeysAfter, "after", "", "Continue after the address with this ID (optional)")
+	rotateKeys.Flags().IntVar(&rotateKeysBatchSize, "batch-size", 100, "Number of addresses read at a time (optional)")
+	RootCmd.AddCommand(rotateKeys)
+}
+
+// runRotateKeys moves the secrets of the addresses after the given ID to the current secret lookup key
+// and prints the addresses that still use a retired key.
+//
+// Parameters:
+//   - after: the hex ID of the address to continue after, or empty to start at the first address.
+//   - batchSize: the number of addresses read at a time.
+//
+// Returns:
+//   - error: if the storage cannot be read or an address cannot be updated.
+func runRotateKeys(after string, batchSize int) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("invalid batch size %d", batchSize)
+	}
+
+	query := repository.AddressQuery{Limit: batchSize}
+	if after != "" {
+		afterID, err := bson.ObjectIDFromHex(after)
+		if err != nil {
+			return fmt.Errorf("invalid address ID %q: %w", after, err)
+		}
+		query.After = afterID
+	}
+
+	currentKeyID, err := utils.CurrentSecretKeyID()
+	if err != nil {
+		return err
+	}
+
+	keyIDs, err := utils.SecretKeyIDs()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+
+	repo, err := openRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer repo.Close(ctx)
+
+	var (
+		upgraded int
+		retired  = map[string]int{}
+	)
+	for {
+		addresses, err := repo.FindAddresses(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to read addresses: %w", err)
+		}
+		if len(addresses) == 0 {
+			break
+		}
+
+		for _, address := range addresses {
+			if address.SchemaVersion < mongodbtypes.AddressSchemaVersionHashedSecret {
+				if !utils.LegacySecretsEnabled() {
+					fmt.Printf("Address %s in zone %s has an encrypted secret, configure enc_key and enc_iv to move it\n",
+						address.Address, address.Zone)
+					retired["encrypted"]++
+					continue
+				}
+				if err := upgradeEncryptedSecret(ctx, repo, address); err != nil {
+					return err
+				}
+				upgraded++
+				continue
+			}
+
+			keyID := address.SecretKeyID
+			if keyID == "" {
+				keyID = utils.DefaultSecretKeyID
+			}
+			if keyID != currentKeyID {
+				fmt.Printf("Address %s in zone %s uses retired key %s\n", address.Address, address.Zone, keyID)
+				retired[keyID]++
+			}
+		}
+
+		query.After = addresses[len(addresses)-1].ID
+		fmt.Printf("Processed addresses up to ID %s\n", query.After.Hex())
+
+		if len(addresses) < batchSize {
+			break
+		}
+	}
+
+	fmt.Printf("Moved the secrets of %d addresses to key %s\n", upgraded, currentKeyID)
+	for _, keyID := range slices.Sorted(maps.Keys(retired)) {
+		switch {
+		case keyID == "encrypted":
+			fmt.Printf("%d addresses still use an encrypted secret, keep enc_key and enc_iv configured\n", retired[keyID])
+		case slices.Contains(keyIDs, keyID):
+			fmt.Printf("%d addresses still use key %s, keep it configured until they have been used\n", retired[keyID], keyID)
+		default:
+			fmt.Printf("%d addresses use key %s, which is not configured anymore: they cannot be found with their secret "+
+				"until the key is added back to secret_lookup_keys\n", retired[keyID], keyID)
+		}
+	}
+	return nil
+}
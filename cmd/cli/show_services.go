@@ -8,11 +8,8 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/repository"
 	"github.com/vitistack/ipam-api/internal/utils"
-	"github.com/vitistack/ipam-api/pkg/clients/mongodb"
-	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
-	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 var (
@@ -44,11 +41,11 @@ func init() {
 	RootCmd.AddCommand(showServices)
 }
 
-// displayServices retrieves and displays information about a specific address and zone from MongoDB.
-// It initializes the MongoDB client using configuration from Viper, queries the database for the
-// address and zone, decrypts the stored secret, and prints the result in either JSON or a human-readable
-// format. If the address is not found or any error occurs during the process (database, decryption, or
-// marshaling), an error is returned.
+// displayServices retrieves and displays information about a specific address and zone from the repository.
+// It opens the storage backend configured in storage.backend, queries the repository for the
+// address and zone, and prints the result in either JSON or a human-readable format. The secret cannot be
+// shown, since only a hash of it is stored. If the address is not found or any error occurs during the
+// process (database or marshaling), an error is returned.
 //
 // Parameters:
 //   - address: the address to look up in the database.
@@ -57,41 +54,30 @@ func init() {
 // Returns:
 //   - error: if any operation fails, an error describing the failure is returned; otherwise, nil.
 func displayServices(address, zone string) error {
-	// Initialize MongoDB client
-	mongoConfig := mongodb.MongoConfig{
-		Host:     viper.GetString("mongodb.host"),
-		Username: viper.GetString("mongodb.username"),
-		Password: viper.GetString("mongodb.password"),
-	}
-
-	mongodb.InitClient(mongoConfig)
-
-	client := mongodb.GetClient()
-	collection := client.Database(viper.GetString("mongodb.database")).Collection(viper.GetString("mongodb.collection"))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	filter := bson.M{
-		"address": utils.NormalizeCIDR(address),
-		"zone":    zone,
+	repo, err := openRepository(ctx)
+	if err != nil {
+		return err
 	}
+	defer repo.Close(ctx)
 
-	var savedAddress mongodbtypes.Address
-	err := collection.FindOne(ctx, filter).Decode(&savedAddress)
-
-	if err != nil {
-		return fmt.Errorf("failed to find addresses: %w", err)
+	query := repository.AddressQuery{
+		Address: utils.NormalizeCIDR(address),
+		Zone:    zone,
 	}
 
-	decryptedSecret, err := utils.DeterministicDecrypt(savedAddress.Secret)
+	savedAddress, err := repo.FindAddress(ctx, query)
 
 	if err != nil {
-		return fmt.Errorf("failed to decrypt secret: %w", err)
+		return fmt.Errorf("failed to find addresses: %w", err)
 	}
 
-	savedAddress.Secret = decryptedSecret
+	// The stored secret is a lookup key that is of no use to the reader
+	savedAddress.Secret = ""
 
-	addressJSON, err := json.MarshalIndent(savedAddress, "", "  ") // #nosec G117 -- intentional: this CLI command is designed to display decrypted secrets
+	addressJSON, err := json.MarshalIndent(savedAddress, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal address to JSON: %w", err)
 	}
@@ -100,7 +86,6 @@ func displayServices(address, zone string) error {
 		fmt.Println(string(addressJSON))
 	} else {
 		fmt.Println("Address\t\t " + savedAddress.Address)
-		fmt.Println("Secret\t\t " + savedAddress.Secret)
 		fmt.Println("Zone\t\t " + savedAddress.Zone)
 		if len(savedAddress.Services) > 0 {
 			for index, service := range savedAddress.Services {
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
+	"github.com/vitistack/ipam-api/internal/utils"
+)
+
+var (
+	verifySecretAddress string
+	verifySecretZone    string
+	verifySecretSecret  string
+)
+
+var verifySecret = &cobra.Command{
+	Use:   "verify-secret",
+	Short: "Verify address secret",
+	Long: `Check whether a secret is the secret an address was registered with. Secrets are stored hashed, so
+they cannot be shown. Use replace-secret to set a new secret.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkSecret(verifySecretAddress, verifySecretZone, verifySecretSecret); err != nil {
+			fmt.Println("Error:", err)
+		}
+	},
+}
+
+func init() {
+	verifySecret.Flags().StringVar(&verifySecretAddress, "address", "", "Address (required)")
+	verifySecret.Flags().StringVar(&verifySecretZone, "zone", "", "Zone (required)")
+	verifySecret.Flags().StringVar(&verifySecretSecret, "secret", "", "Secret (required)")
+	if err := verifySecret.MarkFlagRequired("address"); err != nil {
+		fmt.Println("Error marking 'address' flag as required:", err)
+	}
+	if err := verifySecret.MarkFlagRequired("zone"); err != nil {
+		fmt.Println("Error marking 'zone' flag as required:", err)
+	}
+	if err := verifySecret.MarkFlagRequired("secret"); err != nil {
+		fmt.Println("Error marking 'secret' flag as required:", err)
+	}
+	RootCmd.AddCommand(verifySecret)
+}
+
+// checkSecret prints whether secret is the secret the address in zone was registered with.
+//
+// Parameters:
+//   - address: The address to look up in the database.
+//   - zone: The zone associated with the address.
+//   - secret: The secret to verify.
+//
+// Returns:
+//   - error: An error if the address is not found or any database operation fails.
+func checkSecret(address, zone, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	repo, err := openRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer repo.Close(ctx)
+
+	query := repository.AddressQuery{
+		Address: utils.NormalizeCIDR(address),
+		Zone:    zone,
+	}
+
+	if _, err := repo.FindAddress(ctx, query); err != nil {
+		return fmt.Errorf("failed to find address: %w", err)
+	}
+
+	_, err = storageservice.FindAddressBySecret(ctx, secret, query)
+	if errors.Is(err, repository.ErrNotFound) {
+		fmt.Println("Secret does not match address '" + address + "' in zone '" + zone + "'")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify secret: %w", err)
+	}
+
+	fmt.Println("Secret matches address '" + address + "' in zone '" + zone + "'")
+	return nil
+}
@@ -5,19 +5,28 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/spf13/viper"
 	"github.com/vitistack/ipam-api/cmd/ipam-api/settings"
 
+	"github.com/vitistack/ipam-api/internal/auth"
 	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
 	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/internal/services/reconcileservice"
+	"github.com/vitistack/ipam-api/internal/services/sagaservice"
+	"github.com/vitistack/ipam-api/internal/tracing"
 	"github.com/vitistack/ipam-api/internal/utils"
 	"github.com/vitistack/ipam-api/internal/webserver"
-	"github.com/vitistack/ipam-api/pkg/clients/mongodb"
 )
 
+// @securityDefinitions.apikey	BearerAuth
+// @in							header
+// @name						Authorization
+// @description				API token, sent as "Bearer <token>"
 func main() {
 	// read config.json file
 	err := settings.InitConfig()
@@ -32,54 +41,108 @@ func main() {
 
 	defer logger.Sync()
 
-	// Initialize MongoDB client
-	mongoConfig := mongodb.MongoConfig{
-		Host:     viper.GetString("mongodb.host"),
-		Username: viper.GetString("mongodb.username"),
-		Password: viper.GetString("mongodb.password"),
+	// Stop on SIGINT and SIGTERM, Kubernetes sends SIGTERM before stopping a pod
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		logger.Log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
+	if viper.GetString("auth.tokens_path") != "" || viper.GetString("auth.token") != "" {
+		if err := auth.InitTokenStore(viper.GetString("auth.tokens_path"), viper.GetString("auth.token")); err != nil {
+			logger.Log.Fatalf("Failed to load authentication tokens: %v", err)
+		}
+	}
+
+	if err := auth.InitJWTVerifier(auth.JWTConfigFromViper()); err != nil {
+		logger.Log.Fatalf("Failed to load JWKS: %v", err)
 	}
 
-	mongodb.InitClient(mongoConfig) // check if running before starting webserver
+	if err := auth.InitPolicy(); err != nil {
+		logger.Log.Fatalf("Failed to load caller policies: %v", err)
+	}
+
+	// Open the storage backend, check if it is reachable before starting webserver
+	if err := repository.InitRepository(ctx); err != nil {
+		logger.Log.Fatalf("Failed to open %s storage: %v", viper.GetString("storage.backend"), err)
+	}
+
+	// Create indexes and backfill fields before serving requests
+	migrations, err := repository.GetRepository().Migrate(ctx)
+	if err != nil {
+		logger.Log.Fatalf("Failed to migrate %s storage: %v", viper.GetString("storage.backend"), err)
+	}
+	for _, migration := range migrations {
+		logger.Log.Infof("Applied storage migration %d: %s", migration.Version, migration.Description)
+	}
 
 	logger.Log.Info("Waiting for Netbox to become available...")
-	if err := netboxservice.WaitForNetbox(); err != nil {
+	if err := netboxservice.WaitForNetbox(ctx); err != nil {
 		logger.Log.Fatalf("Netbox is not available: %v", err)
 	}
 
-	go func() {
-		logger.Log.Info("Netbox is available. Caching prefix containers...")
-		err = netboxservice.Cache.FetchPrefixContainers()
-
-		if err != nil {
-			logger.Log.Fatalf("Failed to fetch prefix containers: %v", err)
-		}
-		ticker := time.NewTicker(10 * time.Minute)
-		for range ticker.C {
-			err := netboxservice.Cache.FetchPrefixContainers()
-			if err != nil {
-				logger.Log.Errorf("Failed to refresh prefix containers: %v", err)
-			}
-		}
-	}()
+	// Fill the cache before serving, requests are validated against the cached prefix containers
+	logger.Log.Info("Netbox is available. Caching prefix containers...")
+	if err := netboxservice.Cache.FetchPrefixContainers(ctx); err != nil {
+		logger.Log.Fatalf("Failed to fetch prefix containers: %v", err)
+	}
 
-	// Set up signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Run the web server and the workers until a termination signal is received
+	var workers sync.WaitGroup
+	workers.Go(func() { netboxservice.Cache.StartCacheRefresher(ctx) })
+	workers.Go(func() { utils.StartCleanupWorker(ctx) })
+	workers.Go(func() { sagaservice.StartCompensationWorker(ctx) }) // Retries failed rollback steps
+	workers.Go(func() { reconcileservice.StartReconcileWorker(ctx) })
 
-	// Start web server in a goroutine
-	_, serverCancel := context.WithCancel(context.Background())
+	serverErr := make(chan error, 1)
 	go func() {
-		webserver.InitHTTPServer()
+		serverErr <- webserver.InitHTTPServer(ctx)
 	}()
 
-	// Start cleanup worker
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		logger.Log.Info("Received termination signal. IPAM-API shutting down...")
+		if err := <-serverErr; err != nil {
+			logger.Log.Errorf("Failed to stop web server gracefully: %v", err)
+			exitCode = 1
+		}
+	case err := <-serverErr:
+		logger.Log.Errorf("Web server failed: %v. IPAM-API shutting down...", err)
+		stop()
+		exitCode = 1
+	}
+
+	// Let the workers finish the pass in progress
+	workersDone := make(chan struct{})
 	go func() {
-		utils.StartCleanupWorker()
+		workers.Wait()
+		close(workersDone)
 	}()
+	select {
+	case <-workersDone:
+	case <-time.After(viper.GetDuration("server.shutdown_timeout")):
+		logger.Log.Warn("Workers did not stop within the shutdown timeout")
+		exitCode = 1
+	}
 
-	// Wait for termination signal
-	sig := <-sigChan
-	logger.Log.Infof("Received signal: %s. IPAM-API shutting down...", sig)
-	serverCancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+
+	// Send the spans that have not been exported yet
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Log.Errorf("Failed to flush traces: %v", err)
+	}
 
+	if err := repository.GetRepository().Close(shutdownCtx); err != nil {
+		logger.Log.Errorf("Failed to close %s storage: %v", viper.GetString("storage.backend"), err)
+	}
+
+	logger.Log.Info("IPAM-API stopped")
+	if exitCode != 0 {
+		logger.Sync()
+		os.Exit(exitCode)
+	}
 }
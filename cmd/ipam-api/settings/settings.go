@@ -1,13 +1,17 @@
 package settings
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/auth"
 	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/internal/utils"
 )
 
 func InitConfig() error {
@@ -42,7 +46,18 @@ func InitConfig() error {
 		return fmt.Errorf("failed to merge encryption secrets config: %w", err)
 	}
 
-	viper.Set("mongodb.collection", "addresses") // Set default collection name
+	viper.Set("mongodb.collection", "addresses")                  // Set default collection name
+	viper.Set("mongodb.compensation_collection", "compensations") // Undo actions of allocation sagas
+	viper.Set("mongodb.migration_collection", "migrations")       // Applied schema migrations
+	viper.Set("mongodb.lease_collection", "leases")               // Leases held by instances
+	viper.SetDefault("reconcile.interval", "1h")
+	viper.SetDefault("server.address", ":3000")
+	viper.SetDefault("server.shutdown_timeout", "25s") // Within the 30 second grace period of Kubernetes
+	viper.SetDefault("readiness.cache_max_age", "30m") // Three missed refreshes of the prefix containers
+	viper.SetDefault("ratelimit.requests_per_second", 20)
+	viper.SetDefault("ratelimit.burst", 40)
+	viper.SetDefault("storage.backend", "mongodb")
+	viper.SetDefault("storage.bolt.path", "ipam.db")
 	if viper.GetString("mongodb.password_path") != "" {
 		secretPath := viper.GetString("mongodb.password_path")
 		cleanPath := filepath.Clean(secretPath)
@@ -52,6 +67,24 @@ func InitConfig() error {
 		}
 		viper.Set("mongodb.password", string(secret))
 	}
+	if viper.GetString("mongodb.uri_path") != "" {
+		secretPath := viper.GetString("mongodb.uri_path")
+		cleanPath := filepath.Clean(secretPath)
+		secret, err := os.ReadFile(cleanPath)
+		if err != nil {
+			return fmt.Errorf("failed to read MongoDB connection string from file: %w", err)
+		}
+		viper.Set("mongodb.uri", strings.TrimSpace(string(secret)))
+	}
+	if viper.GetString("storage.postgres.dsn_path") != "" {
+		secretPath := viper.GetString("storage.postgres.dsn_path")
+		cleanPath := filepath.Clean(secretPath)
+		secret, err := os.ReadFile(cleanPath)
+		if err != nil {
+			return fmt.Errorf("failed to read PostgreSQL connection string from file: %w", err)
+		}
+		viper.Set("storage.postgres.dsn", strings.TrimSpace(string(secret)))
+	}
 	if viper.GetString("netbox.token_path") != "" {
 		secretPath := viper.GetString("netbox.token_path")
 		cleanPath := filepath.Clean(secretPath)
@@ -71,24 +104,36 @@ func InitConfig() error {
 		viper.Set("splunk.token", string(secret))
 	}
 
+	// The single token in auth.secret is optional when a tokens file or JWT authentication is configured
+	jwtConfig := auth.JWTConfigFromViper()
 	authTokenBytes, err := os.ReadFile("auth.secret")
-	if err != nil {
+	if err == nil {
+		viper.Set("auth.token", strings.TrimSpace(string(authTokenBytes)))
+	} else if !errors.Is(err, os.ErrNotExist) || (viper.GetString("auth.tokens_path") == "" && !jwtConfig.Enabled()) {
 		return fmt.Errorf("failed to read auth token from file: %w", err)
 	}
-	viper.Set("auth.token", strings.TrimSpace(string(authTokenBytes)))
 
 	required := []string{
-		"mongodb.username",
-		"mongodb.password_path",
-		"mongodb.host",
-		"mongodb.port",
-		"mongodb.database",
 		"netbox.url",
 		"netbox.token_path",
 		"encryption_secrets.path",
-		"enc_key",
-		"enc_iv",
-		"auth.token",
+	}
+
+	switch viper.GetString("storage.backend") {
+	case "mongodb":
+		required = append(required, "mongodb.database")
+
+		// A connection string replaces the structured connection settings
+		if viper.GetString("mongodb.uri") == "" {
+			if !viper.IsSet("mongodb.host") && !viper.IsSet("mongodb.hosts") {
+				return errors.New("missing required config key: mongodb.uri_path, mongodb.host or mongodb.hosts")
+			}
+			if viper.IsSet("mongodb.username") {
+				required = append(required, "mongodb.password_path")
+			}
+		}
+	case "postgres":
+		required = append(required, "storage.postgres.dsn_path")
 	}
 
 	for _, key := range required {
@@ -97,8 +142,28 @@ func InitConfig() error {
 		}
 	}
 
+	if !viper.IsSet("secret_lookup_key") && !viper.IsSet("secret_lookup_keys") {
+		return errors.New("missing required config key: secret_lookup_key or secret_lookup_keys")
+	}
+	if err := utils.ValidateSecretLookupKeys(); err != nil {
+		return err
+	}
+
+	if viper.GetString("auth.token") == "" && viper.GetString("auth.tokens_path") == "" && !jwtConfig.Enabled() {
+		return errors.New("missing authentication config: auth.secret, auth.tokens_path or auth.jwt is required")
+	}
+	if jwtConfig.Enabled() {
+		if err := jwtConfig.Validate(); err != nil {
+			return err
+		}
+	}
+
+	netboxservice.InitClient(viper.GetString("netbox.url"), viper.GetString("netbox.token"),
+		viper.GetInt("netbox.page_size"), viper.GetDuration("netbox.timeout"))
+	netboxservice.SetConcurrencyLimit(viper.GetInt("netbox.max_concurrent_requests"), viper.GetDuration("netbox.max_queue_wait"))
+
 	if viper.GetString("netbox.constraint_tag") != "" {
-		constraintTagID, err := netboxservice.GetTagID(viper.GetString("netbox.constraint_tag"))
+		constraintTagID, err := netboxservice.GetTagID(context.Background(), viper.GetString("netbox.constraint_tag"))
 		if err != nil {
 			return fmt.Errorf("failed to get constraint tag ID: %w", err)
 		}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/x509"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// ContextKey is the gin context key the authenticated Identity is stored under.
+const ContextKey = "ipam.identity"
+
+// SetIdentity stores the authenticated caller on the gin context.
+func SetIdentity(c *gin.Context, identity Identity) {
+	c.Set(ContextKey, identity)
+}
+
+// IdentityFromContext returns the authenticated caller stored on the gin context, if any.
+func IdentityFromContext(c *gin.Context) (Identity, bool) {
+	value, ok := c.Get(ContextKey)
+	if !ok {
+		return Identity{}, false
+	}
+	identity, ok := value.(Identity)
+	return identity, ok
+}
+
+// Enabled reports whether an authentication method is configured: API tokens, a JWKS, or client certificates
+// verified against server.tls.client_ca_file. Once one is, every API route requires an authenticated caller.
+func Enabled() bool {
+	return Tokens.Configured() || JWTs != nil || viper.GetString("server.tls.client_ca_file") != ""
+}
+
+// IdentityFromCertificate returns the identity of a caller that presented a verified client certificate.
+// The caller is named by the common name of the certificate subject, or by the whole subject if it has no
+// common name.
+func IdentityFromCertificate(certificate *x509.Certificate) Identity {
+	name := certificate.Subject.CommonName
+	if name == "" {
+		name = certificate.Subject.String()
+	}
+	return Identity{Name: name, Method: MethodClientCertificate}
+}
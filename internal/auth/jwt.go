@@ -0,0 +1,409 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/logger"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultJWKSRefreshInterval is how often a JWKS served from a URL is fetched again when
+// auth.jwt.jwks_refresh_interval is not set.
+const DefaultJWKSRefreshInterval = 10 * time.Minute
+
+// unknownKeyRefreshInterval is the shortest time between reading the key set and reading it again for a
+// token signed with an unknown key, so that callers cannot make every request fetch the key set.
+const unknownKeyRefreshInterval = time.Minute
+
+// jwtAlgorithms are the signature algorithms accepted in tokens. Symmetric algorithms are not accepted,
+// since the keys come from a public key set.
+var jwtAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// JWTConfig configures the validation of JSON Web Tokens. Exactly one of JWKSFile and JWKSURL is set.
+type JWTConfig struct {
+	// Issuer must match the "iss" claim.
+	Issuer string
+	// Audiences must contain one of the values of the "aud" claim.
+	Audiences []string
+	// ClusterIDClaim is an optional claim holding the cluster the caller acts for.
+	ClusterIDClaim string
+	// ClockSkew is the leeway allowed when checking the "exp", "nbf" and "iat" claims.
+	ClockSkew time.Duration
+	// JWKSFile is the path of a JSON Web Key Set. It is reloaded when it changes.
+	JWKSFile string
+	// JWKSURL is the URL a JSON Web Key Set is fetched from, such as the /openid/v1/jwks endpoint of
+	// the Kubernetes API server.
+	JWKSURL string
+	// JWKSRefreshInterval is how often the key set at JWKSURL is fetched again.
+	JWKSRefreshInterval time.Duration
+	// JWKSCAFile is an optional CA bundle to verify the server of JWKSURL with.
+	JWKSCAFile string
+	// JWKSTokenFile is an optional file with a bearer token sent when fetching JWKSURL, such as a
+	// mounted service account token.
+	JWKSTokenFile string
+}
+
+// JWTConfigFromViper reads the auth.jwt settings.
+func JWTConfigFromViper() JWTConfig {
+	return JWTConfig{
+		Issuer:              viper.GetString("auth.jwt.issuer"),
+		Audiences:           viper.GetStringSlice("auth.jwt.audiences"),
+		ClusterIDClaim:      viper.GetString("auth.jwt.cluster_id_claim"),
+		ClockSkew:           viper.GetDuration("auth.jwt.clock_skew"),
+		JWKSFile:            viper.GetString("auth.jwt.jwks_file"),
+		JWKSURL:             viper.GetString("auth.jwt.jwks_url"),
+		JWKSRefreshInterval: viper.GetDuration("auth.jwt.jwks_refresh_interval"),
+		JWKSCAFile:          viper.GetString("auth.jwt.jwks_ca_file"),
+		JWKSTokenFile:       viper.GetString("auth.jwt.jwks_token_file"),
+	}
+}
+
+// Enabled reports whether a key set is configured.
+func (config JWTConfig) Enabled() bool {
+	return config.JWKSFile != "" || config.JWKSURL != ""
+}
+
+// Validate checks that the configuration is complete.
+func (config JWTConfig) Validate() error {
+	switch {
+	case config.JWKSFile != "" && config.JWKSURL != "":
+		return errors.New("auth.jwt.jwks_file and auth.jwt.jwks_url cannot both be set")
+	case config.Issuer == "":
+		return errors.New("missing required config key: auth.jwt.issuer")
+	case len(config.Audiences) == 0:
+		return errors.New("missing required config key: auth.jwt.audiences")
+	case config.ClockSkew < 0:
+		return errors.New("auth.jwt.clock_skew cannot be negative")
+	}
+	return nil
+}
+
+// JWTVerifier validates JSON Web Tokens against a key set read from a file or a URL. The key set is
+// reloaded when the file changes or the refresh interval of the URL has passed, so signing keys can be
+// rotated without a restart.
+type JWTVerifier struct {
+	mu         sync.RWMutex
+	config     JWTConfig
+	httpClient *http.Client
+	keys       jose.JSONWebKeySet
+	modTime    time.Time
+	lastCheck  time.Time
+	// refreshes lets concurrent requests that find the key set due share one refresh
+	refreshes singleflight.Group
+}
+
+// JWTs validates the JSON Web Tokens of callers. It is nil when JWT authentication is not configured.
+var JWTs *JWTVerifier
+
+// InitJWTVerifier sets JWTs to a verifier for config, or to nil if no key set is configured.
+func InitJWTVerifier(config JWTConfig) error {
+	if !config.Enabled() {
+		JWTs = nil
+		return nil
+	}
+
+	verifier, err := NewJWTVerifier(config)
+	if err != nil {
+		return err
+	}
+	JWTs = verifier
+	return nil
+}
+
+// NewJWTVerifier returns a verifier for config and loads its key set.
+//
+// Parameters:
+//   - config: The expected claims and the location of the key set.
+//
+// Returns:
+//   - *JWTVerifier: The verifier.
+//   - error: An error if the configuration is incomplete or the key set cannot be loaded.
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	if !config.Enabled() {
+		return nil, errors.New("missing required config key: auth.jwt.jwks_file or auth.jwt.jwks_url")
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.JWKSRefreshInterval <= 0 {
+		config.JWKSRefreshInterval = DefaultJWKSRefreshInterval
+	}
+
+	verifier := &JWTVerifier{config: config}
+
+	if config.JWKSURL != "" {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if config.JWKSCAFile != "" {
+			pem, err := os.ReadFile(filepath.Clean(config.JWKSCAFile))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read JWKS CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in JWKS CA file %s", config.JWKSCAFile)
+			}
+			transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+		}
+		verifier.httpClient = &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	}
+
+	if err := verifier.reload(); err != nil {
+		return nil, err
+	}
+	return verifier, nil
+}
+
+// reload reads the key set and replaces the keys with it.
+func (v *JWTVerifier) reload() error {
+	keys, modTime, err := v.load()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.lastCheck = time.Now()
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	v.modTime = modTime
+	return nil
+}
+
+// load reads and parses the key set, and returns it with the modification time of the file, if it is
+// read from a file. It does not touch the verifier, so no lock is held while the key set is fetched.
+func (v *JWTVerifier) load() (jose.JSONWebKeySet, time.Time, error) {
+	var (
+		content []byte
+		modTime time.Time
+		err     error
+	)
+	if v.config.JWKSFile != "" {
+		info, err := os.Stat(v.config.JWKSFile)
+		if err != nil {
+			return jose.JSONWebKeySet{}, time.Time{}, fmt.Errorf("failed to read JWKS file: %w", err)
+		}
+		modTime = info.ModTime()
+		content, err = os.ReadFile(filepath.Clean(v.config.JWKSFile))
+		if err != nil {
+			return jose.JSONWebKeySet{}, time.Time{}, fmt.Errorf("failed to read JWKS file: %w", err)
+		}
+	} else {
+		content, err = v.fetch()
+		if err != nil {
+			return jose.JSONWebKeySet{}, time.Time{}, err
+		}
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return jose.JSONWebKeySet{}, time.Time{}, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	if len(keys.Keys) == 0 {
+		return jose.JSONWebKeySet{}, time.Time{}, errors.New("JWKS contains no keys")
+	}
+
+	return keys, modTime, nil
+}
+
+// fetch downloads the key set from the configured URL.
+func (v *JWTVerifier) fetch() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, v.config.JWKSURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS URL: %w", err)
+	}
+	if v.config.JWKSTokenFile != "" {
+		token, err := os.ReadFile(filepath.Clean(v.config.JWKSTokenFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS token file: %w", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	response, err := v.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// reloadIfChanged reloads the key set when the file has changed, checked every reloadInterval, or when
+// the refresh interval of the URL has passed. A failed reload keeps the previous keys. Concurrent callers
+// share one reload, and the key set is read without holding the lock, so other requests are authenticated
+// with the previous keys meanwhile.
+func (v *JWTVerifier) reloadIfChanged() {
+	interval := reloadInterval
+	if v.config.JWKSURL != "" {
+		interval = v.config.JWKSRefreshInterval
+	}
+
+	v.mu.RLock()
+	due := time.Since(v.lastCheck) >= interval
+	v.mu.RUnlock()
+
+	if !due {
+		return
+	}
+
+	_, _, _ = v.refreshes.Do("jwks", func() (any, error) {
+		v.mu.RLock()
+		due := time.Since(v.lastCheck) >= interval
+		modTime := v.modTime
+		v.mu.RUnlock()
+
+		if !due {
+			return nil, nil
+		}
+
+		if v.config.JWKSFile != "" {
+			info, err := os.Stat(v.config.JWKSFile)
+			if err != nil {
+				logger.Log.Errorf("Failed to check JWKS file: %v", err)
+			}
+			if err != nil || info.ModTime().Equal(modTime) {
+				v.mu.Lock()
+				v.lastCheck = time.Now()
+				v.mu.Unlock()
+				return nil, nil
+			}
+		}
+
+		if err := v.reload(); err != nil {
+			logger.Log.Errorf("Failed to reload JWKS, keeping previous keys: %v", err)
+			return nil, nil
+		}
+
+		logger.Log.Info("Reloaded JWKS")
+		return nil, nil
+	})
+}
+
+// reloadForUnknownKey reloads the key set for a token signed with the key kid, which it does not contain,
+// unless the key set was read within unknownKeyRefreshInterval. It shares the reload with concurrent
+// callers and with reloadIfChanged, and a failed reload keeps the previous keys.
+func (v *JWTVerifier) reloadForUnknownKey(kid string) {
+	v.mu.RLock()
+	recent := time.Since(v.lastCheck) < unknownKeyRefreshInterval
+	v.mu.RUnlock()
+
+	if recent {
+		return
+	}
+
+	_, _, _ = v.refreshes.Do("jwks", func() (any, error) {
+		v.mu.RLock()
+		recent := time.Since(v.lastCheck) < unknownKeyRefreshInterval
+		known := len(v.keys.Key(kid)) > 0
+		v.mu.RUnlock()
+
+		if recent || known {
+			return nil, nil
+		}
+
+		if err := v.reload(); err != nil {
+			logger.Log.Errorf("Failed to reload JWKS for unknown key ID %q, keeping previous keys: %v", kid, err)
+			return nil, nil
+		}
+
+		logger.Log.Infof("Reloaded JWKS for unknown key ID %q", kid)
+		return nil, nil
+	})
+}
+
+// signingKeys returns the keys with ID kid, or every key if kid is empty.
+func (v *JWTVerifier) signingKeys(kid string) []jose.JSONWebKey {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if kid == "" {
+		return v.keys.Keys
+	}
+	return v.keys.Key(kid)
+}
+
+// Authenticate returns the identity of the caller owning token, named by its "sub" claim and acting for
+// the cluster in the ClusterIDClaim claim, if configured. The token must
+// be signed by a key of the key set, be issued by the configured issuer for one of the configured
+// audiences, and be within its validity period, allowing for the clock skew.
+func (v *JWTVerifier) Authenticate(token string) (Identity, error) {
+	if token == "" {
+		return Identity{}, ErrMissingToken
+	}
+
+	parsed, err := jwt.ParseSigned(token, jwtAlgorithms)
+	if err != nil || len(parsed.Headers) != 1 {
+		return Identity{}, ErrInvalidToken
+	}
+
+	v.reloadIfChanged()
+
+	kid := parsed.Headers[0].KeyID
+	keys := v.signingKeys(kid)
+	if len(keys) == 0 && kid != "" {
+		// The token may be signed with a key published since the key set was read
+		v.reloadForUnknownKey(kid)
+		keys = v.signingKeys(kid)
+	}
+
+	var (
+		claims jwt.Claims
+		extra  map[string]any
+	)
+	verified := false
+	for _, key := range keys {
+		if err := parsed.Claims(key.Public(), &claims, &extra); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Identity{}, ErrInvalidToken
+	}
+
+	if claims.Expiry == nil || claims.Subject == "" {
+		return Identity{}, ErrInvalidToken
+	}
+	expected := jwt.Expected{Issuer: v.config.Issuer, AnyAudience: v.config.Audiences, Time: time.Now()}
+	if err := claims.ValidateWithLeeway(expected, v.config.ClockSkew); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	identity := Identity{Name: claims.Subject, Method: MethodJWT}
+	if v.config.ClusterIDClaim != "" {
+		identity.ClusterID, _ = extra[v.config.ClusterIDClaim].(string)
+	}
+	return identity, nil
+}
+
+// LooksLikeJWT reports whether token has the three dot-separated parts of a signed JSON Web Token, as
+// opposed to an API token.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/vitistack/ipam-api/internal/logger"
+)
+
+const (
+	testIssuer   = "https://kubernetes.default.svc.cluster.local"
+	testAudience = "ipam-api"
+	testSubject  = "system:serviceaccount:kube-system:ipam-controller"
+)
+
+// testKey is a signing key published in a JWKS file.
+type testKey struct {
+	id      string
+	private *ecdsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, id string) testKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return testKey{id: id, private: private}
+}
+
+// writeJWKS writes the public keys of keys as a JWKS file in dir and returns its path.
+func writeJWKS(t *testing.T, dir string, keys ...testKey) string {
+	t.Helper()
+	var set jose.JSONWebKeySet
+	for _, key := range keys {
+		set.Keys = append(set.Keys, jose.JSONWebKey{Key: &key.private.PublicKey, KeyID: key.id, Algorithm: string(jose.ES256), Use: "sig"})
+	}
+	content, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("failed to encode JWKS: %v", err)
+	}
+	path := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	return path
+}
+
+// sign returns a token with claims signed by key.
+func sign(t *testing.T, key testKey, claims jwt.Claims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key.private},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader(jose.HeaderKey("kid"), key.id))
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func validClaims() jwt.Claims {
+	now := time.Now()
+	return jwt.Claims{
+		Issuer:    testIssuer,
+		Subject:   testSubject,
+		Audience:  jwt.Audience{testAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+}
+
+func TestJWTVerifierAuthenticate(t *testing.T) {
+	key := newTestKey(t, "key-1")
+	otherKey := newTestKey(t, "key-2")
+
+	verifier, err := NewJWTVerifier(JWTConfig{
+		Issuer:    testIssuer,
+		Audiences: []string{testAudience},
+		ClockSkew: time.Minute,
+		JWKSFile:  writeJWKS(t, t.TempDir(), key),
+	})
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	identity, err := verifier.Authenticate(sign(t, key, validClaims()))
+	if err != nil {
+		t.Fatalf("expected a valid token to be accepted, got %v", err)
+	}
+	if identity.Name != testSubject || identity.Method != MethodJWT {
+		t.Errorf("expected identity %q with method %q, got %+v", testSubject, MethodJWT, identity)
+	}
+
+	tests := []struct {
+		name   string
+		key    testKey
+		modify func(*jwt.Claims)
+	}{
+		{name: "unknown key", key: otherKey},
+		{name: "wrong issuer", key: key, modify: func(c *jwt.Claims) { c.Issuer = "https://issuer.example.com" }},
+		{name: "wrong audience", key: key, modify: func(c *jwt.Claims) { c.Audience = jwt.Audience{"other"} }},
+		{name: "expired beyond clock skew", key: key, modify: func(c *jwt.Claims) {
+			c.Expiry = jwt.NewNumericDate(time.Now().Add(-2 * time.Minute))
+		}},
+		{name: "not yet valid beyond clock skew", key: key, modify: func(c *jwt.Claims) {
+			c.NotBefore = jwt.NewNumericDate(time.Now().Add(2 * time.Minute))
+		}},
+		{name: "without expiry", key: key, modify: func(c *jwt.Claims) { c.Expiry = nil }},
+		{name: "without subject", key: key, modify: func(c *jwt.Claims) { c.Subject = "" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := validClaims()
+			if test.modify != nil {
+				test.modify(&claims)
+			}
+			if _, err := verifier.Authenticate(sign(t, test.key, claims)); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("expected ErrInvalidToken, got %v", err)
+			}
+		})
+	}
+
+	t.Run("expired within clock skew", func(t *testing.T) {
+		claims := validClaims()
+		claims.Expiry = jwt.NewNumericDate(time.Now().Add(-30 * time.Second))
+		if _, err := verifier.Authenticate(sign(t, key, claims)); err != nil {
+			t.Errorf("expected a token expired within the clock skew to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("not a token", func(t *testing.T) {
+		if _, err := verifier.Authenticate("not.a.token"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+	})
+}
+
+func TestJWTVerifierReloadsJWKSFile(t *testing.T) {
+	logger.InitConsoleLogger()
+	oldKey := newTestKey(t, "old")
+	newKey := newTestKey(t, "new")
+	dir := t.TempDir()
+
+	verifier, err := NewJWTVerifier(JWTConfig{
+		Issuer:    testIssuer,
+		Audiences: []string{testAudience},
+		JWKSFile:  writeJWKS(t, dir, oldKey),
+	})
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	if _, err := verifier.Authenticate(sign(t, newKey, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected a token signed with an unpublished key to be rejected, got %v", err)
+	}
+
+	// Publish the new key and make the file look changed and due for a check
+	path := writeJWKS(t, dir, newKey)
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to touch JWKS: %v", err)
+	}
+	verifier.mu.Lock()
+	verifier.lastCheck = time.Time{}
+	verifier.mu.Unlock()
+
+	if _, err := verifier.Authenticate(sign(t, newKey, validClaims())); err != nil {
+		t.Errorf("expected a token signed with the rotated key to be accepted, got %v", err)
+	}
+}
+
+// TestJWTVerifierRefreshesJWKSURLOnce checks that concurrent requests finding the key set due share one
+// fetch, and that the keys can be read while it is fetched.
+func TestJWTVerifierRefreshesJWKSURLOnce(t *testing.T) {
+	logger.InitConsoleLogger()
+	key := newTestKey(t, "key")
+	content, err := os.ReadFile(writeJWKS(t, t.TempDir(), key))
+	if err != nil {
+		t.Fatalf("failed to read JWKS: %v", err)
+	}
+
+	var fetches atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first fetch is made by the constructor, the refresh waits until it is released
+		if fetches.Add(1) == 2 {
+			close(started)
+			<-release
+		}
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	verifier, err := NewJWTVerifier(JWTConfig{
+		Issuer:    testIssuer,
+		Audiences: []string{testAudience},
+		JWKSURL:   server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	verifier.mu.Lock()
+	verifier.lastCheck = time.Time{}
+	verifier.mu.Unlock()
+
+	token := sign(t, key, validClaims())
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := verifier.Authenticate(token)
+			errs <- err
+		}()
+	}
+
+	<-started
+	if !verifier.mu.TryRLock() {
+		t.Error("expected the keys to be readable while the JWKS is fetched")
+	} else {
+		verifier.mu.RUnlock()
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected the token to be accepted, got %v", err)
+		}
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("expected the JWKS to be fetched twice, once by the constructor and once to refresh, got %d", got)
+	}
+}
+
+// TestJWTVerifierRefreshesJWKSURLForUnknownKey checks that a token signed with a key published after the key
+// set was fetched is accepted without waiting for the refresh interval, and that tokens with unknown keys
+// do not make the verifier fetch the key set again within unknownKeyRefreshInterval.
+func TestJWTVerifierRefreshesJWKSURLForUnknownKey(t *testing.T) {
+	logger.InitConsoleLogger()
+	oldKey := newTestKey(t, "old")
+	newKey := newTestKey(t, "new")
+	dir := t.TempDir()
+
+	var (
+		mu      sync.Mutex
+		content []byte
+		fetches atomic.Int32
+	)
+	publish := func(keys ...testKey) {
+		published, err := os.ReadFile(writeJWKS(t, dir, keys...))
+		if err != nil {
+			t.Fatalf("failed to read JWKS: %v", err)
+		}
+		mu.Lock()
+		content = published
+		mu.Unlock()
+	}
+	publish(oldKey)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+
+	verifier, err := NewJWTVerifier(JWTConfig{
+		Issuer:    testIssuer,
+		Audiences: []string{testAudience},
+		JWKSURL:   server.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	publish(oldKey, newKey)
+	if _, err := verifier.Authenticate(sign(t, newKey, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected the key set not to be fetched again right after it was read, got %v", err)
+	}
+
+	// The key set was read longer ago than unknownKeyRefreshInterval, but within the refresh interval
+	verifier.mu.Lock()
+	verifier.lastCheck = time.Now().Add(-2 * unknownKeyRefreshInterval)
+	verifier.mu.Unlock()
+
+	if _, err := verifier.Authenticate(sign(t, newKey, validClaims())); err != nil {
+		t.Errorf("expected a token signed with the published key to be accepted, got %v", err)
+	}
+	for range 5 {
+		if _, err := verifier.Authenticate(sign(t, newTestKey(t, "unknown"), validClaims())); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected a token signed with an unknown key to be rejected, got %v", err)
+		}
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("expected the JWKS to be fetched twice, once by the constructor and once for the new key, got %d", got)
+	}
+}
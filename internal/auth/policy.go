@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Roles a caller can be given in the caller policies.
+const (
+	// RoleAdmin may act on every zone and cluster, expire clusters and manage secrets.
+	RoleAdmin = "admin"
+	// RoleCluster may register and expire the services of its own clusters in the zones it is allowed to use.
+	RoleCluster = "cluster"
+)
+
+// AnyZone in the zones of a caller policy allows every zone.
+const AnyZone = "*"
+
+// ErrForbidden is returned when an authenticated caller is not allowed to perform an operation.
+var ErrForbidden = errors.New("caller is not allowed to perform this operation")
+
+// CallerPolicy lists what a caller, matched by the authentication method and name of its identity, is
+// allowed to do.
+type CallerPolicy struct {
+	Method     string   `mapstructure:"method" json:"method"`
+	Name       string   `mapstructure:"name" json:"name"`
+	Roles      []string `mapstructure:"roles" json:"roles"`
+	ClusterIDs []string `mapstructure:"cluster_ids" json:"cluster_ids,omitempty"`
+	Zones      []string `mapstructure:"zones" json:"zones,omitempty"`
+}
+
+// Scope is what an operation acts on. Empty fields are not checked.
+type Scope struct {
+	Zone      string
+	ClusterID string
+}
+
+// Policy authorizes callers by their caller policy. Without caller policies every caller may perform
+// every operation, and only the administrative routes require authentication.
+type Policy struct {
+	mu      sync.RWMutex
+	callers map[callerKey]CallerPolicy
+}
+
+// callerKey identifies a caller policy. The same name can belong to different callers under different
+// authentication methods, such as a token name and a JWT subject, so the method is part of the key.
+type callerKey struct {
+	method string
+	name   string
+}
+
+// CallerPolicies holds the caller policies read from auth.policy.callers.
+var CallerPolicies = &Policy{}
+
+// InitPolicy loads the caller policies in auth.policy.callers into CallerPolicies.
+func InitPolicy() error {
+	var callers []CallerPolicy
+	if err := viper.UnmarshalKey("auth.policy.callers", &callers); err != nil {
+		return fmt.Errorf("failed to read auth.policy.callers: %w", err)
+	}
+	return CallerPolicies.Load(callers)
+}
+
+// Load replaces the caller policies.
+//
+// Parameters:
+//   - callers: The caller policies. Each method and name may appear once.
+//
+// Returns:
+//   - error: An error if a caller has no name, a missing or unknown method, an unknown role, or appears twice.
+func (p *Policy) Load(callers []CallerPolicy) error {
+	byKey := make(map[callerKey]CallerPolicy, len(callers))
+	for i, caller := range callers {
+		if caller.Name == "" {
+			return fmt.Errorf("caller policy %d has no name", i)
+		}
+		switch caller.Method {
+		case MethodToken, MethodJWT, MethodClientCertificate:
+		case "":
+			return fmt.Errorf("caller policy for %q has no method, use %q, %q or %q",
+				caller.Name, MethodToken, MethodJWT, MethodClientCertificate)
+		default:
+			return fmt.Errorf("caller policy for %q has unknown method %q", caller.Name, caller.Method)
+		}
+		key := callerKey{method: caller.Method, name: caller.Name}
+		if _, ok := byKey[key]; ok {
+			return fmt.Errorf("caller policy for %s %q is defined twice", caller.Method, caller.Name)
+		}
+		for _, role := range caller.Roles {
+			if role != RoleAdmin && role != RoleCluster {
+				return fmt.Errorf("caller policy for %q has unknown role %q", caller.Name, role)
+			}
+		}
+		byKey[key] = caller
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.callers = byKey
+	return nil
+}
+
+// Enabled reports whether caller policies are configured.
+func (p *Policy) Enabled() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.callers) > 0
+}
+
+// caller returns the policy of identity, matched by both its authentication method and its name.
+func (p *Policy) caller(identity Identity) (CallerPolicy, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	caller, ok := p.callers[callerKey{method: identity.Method, name: identity.Name}]
+	return caller, ok
+}
+
+// AuthorizeAdmin checks that the caller may perform administrative operations, such as expiring a
+// cluster or replacing a secret.
+//
+// Parameters:
+//   - identity: The identity of the caller.
+//   - authenticated: Whether the caller was authenticated. Unauthenticated callers are never admins.
+//
+// Returns:
+//   - error: ErrMissingToken if the caller is not authenticated, ErrForbidden if it is not an admin.
+func (p *Policy) AuthorizeAdmin(identity Identity, authenticated bool) error {
+	if !authenticated {
+		return ErrMissingToken
+	}
+	if !p.Enabled() {
+		return nil
+	}
+
+	caller, ok := p.caller(identity)
+	if !ok || !slices.Contains(caller.Roles, RoleAdmin) {
+		return fmt.Errorf("%w: %s is not an admin", ErrForbidden, identity.Name)
+	}
+	return nil
+}
+
+// Authorize checks that the caller may act on scope. Admins may act on every scope. Cluster callers may
+// act on the clusters in their policy, or the cluster of their identity, in the zones of their policy.
+// Without caller policies every caller, authenticated or not, is allowed.
+//
+// Parameters:
+//   - identity: The identity of the caller.
+//   - authenticated: Whether the caller was authenticated.
+//   - scope: The zone and cluster the operation acts on.
+//
+// Returns:
+//   - error: ErrMissingToken if policies are configured and the caller is not authenticated,
+//     ErrForbidden if the caller may not act on scope.
+func (p *Policy) Authorize(identity Identity, authenticated bool, scope Scope) error {
+	if !p.Enabled() {
+		return nil
+	}
+	if !authenticated {
+		return ErrMissingToken
+	}
+
+	caller, ok := p.caller(identity)
+	if !ok {
+		return fmt.Errorf("%w: no policy for %s %s", ErrForbidden, identity.Method, identity.Name)
+	}
+	if slices.Contains(caller.Roles, RoleAdmin) {
+		return nil
+	}
+	if !slices.Contains(caller.Roles, RoleCluster) {
+		return fmt.Errorf("%w: %s has no cluster role", ErrForbidden, identity.Name)
+	}
+
+	if scope.ClusterID != "" && scope.ClusterID != identity.ClusterID && !slices.Contains(caller.ClusterIDs, scope.ClusterID) {
+		return fmt.Errorf("%w: %s may not act on cluster %s", ErrForbidden, identity.Name, scope.ClusterID)
+	}
+	if scope.Zone != "" && !slices.Contains(caller.Zones, AnyZone) && !slices.Contains(caller.Zones, scope.Zone) {
+		return fmt.Errorf("%w: %s may not use zone %s", ErrForbidden, identity.Name, scope.Zone)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyLoadRejectsInvalidCallers(t *testing.T) {
+	for name, callers := range map[string][]CallerPolicy{
+		"no name":        {{Method: MethodToken, Roles: []string{RoleAdmin}}},
+		"no method":      {{Name: "pipeline", Roles: []string{RoleAdmin}}},
+		"unknown method": {{Method: "basic", Name: "pipeline", Roles: []string{RoleAdmin}}},
+		"unknown role":   {{Method: MethodToken, Name: "pipeline", Roles: []string{"owner"}}},
+		"defined twice": {
+			{Method: MethodToken, Name: "pipeline", Roles: []string{RoleAdmin}},
+			{Method: MethodToken, Name: "pipeline", Roles: []string{RoleCluster}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := (&Policy{}).Load(callers); err == nil {
+				t.Error("expected the caller policies to be rejected")
+			}
+		})
+	}
+}
+
+// TestPolicyMatchesMethodAndName checks that a token named like a JWT subject does not get the policy of
+// that subject.
+func TestPolicyMatchesMethodAndName(t *testing.T) {
+	policy := &Policy{}
+	err := policy.Load([]CallerPolicy{
+		{Method: MethodJWT, Name: testSubject, Roles: []string{RoleAdmin}},
+		{Method: MethodToken, Name: testSubject, Roles: []string{RoleCluster}, ClusterIDs: []string{"c1"}, Zones: []string{"inet"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to load caller policies: %v", err)
+	}
+
+	jwtCaller := Identity{Name: testSubject, Method: MethodJWT}
+	tokenCaller := Identity{Name: testSubject, Method: MethodToken}
+	certificateCaller := Identity{Name: testSubject, Method: MethodClientCertificate}
+
+	if err := policy.AuthorizeAdmin(jwtCaller, true); err != nil {
+		t.Errorf("expected the JWT caller to be an admin, got %v", err)
+	}
+	if err := policy.AuthorizeAdmin(tokenCaller, true); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected the token caller not to be an admin, got %v", err)
+	}
+	if err := policy.Authorize(tokenCaller, true, Scope{Zone: "inet", ClusterID: "c1"}); err != nil {
+		t.Errorf("expected the token caller to act on its cluster, got %v", err)
+	}
+	if err := policy.Authorize(tokenCaller, true, Scope{Zone: "inet", ClusterID: "c2"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected the token caller not to act on another cluster, got %v", err)
+	}
+	if err := policy.Authorize(certificateCaller, true, Scope{Zone: "inet"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected a caller without a policy for its method to be denied, got %v", err)
+	}
+}
+
+func TestPolicyAuthorizeWithoutPolicies(t *testing.T) {
+	policy := &Policy{}
+
+	if err := policy.Authorize(Identity{}, false, Scope{Zone: "inet", ClusterID: "c1"}); err != nil {
+		t.Errorf("expected every caller to be allowed without policies, got %v", err)
+	}
+	if err := policy.AuthorizeAdmin(Identity{}, false); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("expected administrative routes to require authentication, got %v", err)
+	}
+	if err := policy.AuthorizeAdmin(Identity{Name: "pipeline", Method: MethodToken}, true); err != nil {
+		t.Errorf("expected any authenticated caller to be an admin without policies, got %v", err)
+	}
+}
+
+func TestPolicyAuthorizeScopes(t *testing.T) {
+	policy := &Policy{}
+	err := policy.Load([]CallerPolicy{
+		{Method: MethodToken, Name: "pipeline", Roles: []string{RoleAdmin}},
+		{Method: MethodJWT, Name: testSubject, Roles: []string{RoleCluster}, ClusterIDs: []string{"c1"}, Zones: []string{"inet"}},
+		{Method: MethodClientCertificate, Name: "everywhere", Roles: []string{RoleCluster}, ClusterIDs: []string{"c1"}, Zones: []string{AnyZone}},
+		{Method: MethodToken, Name: "nobody"},
+	})
+	if err != nil {
+		t.Fatalf("failed to load caller policies: %v", err)
+	}
+
+	admin := Identity{Name: "pipeline", Method: MethodToken}
+	controller := Identity{Name: testSubject, Method: MethodJWT, ClusterID: "c2"}
+	everywhere := Identity{Name: "everywhere", Method: MethodClientCertificate}
+	nobody := Identity{Name: "nobody", Method: MethodToken}
+
+	for _, test := range []struct {
+		name     string
+		identity Identity
+		scope    Scope
+		expected error
+	}{
+		{"admin in any scope", admin, Scope{Zone: "dmz", ClusterID: "c9"}, nil},
+		{"cluster in policy", controller, Scope{Zone: "inet", ClusterID: "c1"}, nil},
+		{"cluster of the identity", controller, Scope{Zone: "inet", ClusterID: "c2"}, nil},
+		{"other cluster", controller, Scope{Zone: "inet", ClusterID: "c3"}, ErrForbidden},
+		{"other zone", controller, Scope{Zone: "dmz", ClusterID: "c1"}, ErrForbidden},
+		{"any zone", everywhere, Scope{Zone: "dmz", ClusterID: "c1"}, nil},
+		{"no role", nobody, Scope{Zone: "inet"}, ErrForbidden},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Authorize(test.identity, true, test.scope)
+			if test.expected == nil && err != nil || test.expected != nil && !errors.Is(err, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+
+	if err := policy.Authorize(Identity{}, false, Scope{Zone: "inet"}); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("expected unauthenticated callers to be rejected once policies are configured, got %v", err)
+	}
+	if err := policy.AuthorizeAdmin(controller, true); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected a cluster caller not to be an admin, got %v", err)
+	}
+}
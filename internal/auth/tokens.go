@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/logger"
+)
+
+// reloadInterval is how often the tokens file is checked for changes.
+const reloadInterval = 10 * time.Second
+
+// DefaultCallerName is the identity given to callers using the legacy token from auth.secret.
+const DefaultCallerName = "default"
+
+var (
+	ErrMissingToken = errors.New("authorization required")
+	ErrInvalidToken = errors.New("invalid authentication token")
+)
+
+// Methods a caller can be authenticated with.
+const (
+	MethodToken             = "token"
+	MethodJWT               = "jwt"
+	MethodClientCertificate = "client_certificate"
+)
+
+// Identity describes an authenticated caller.
+type Identity struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	// ClusterID is the cluster the caller acts for, when its credentials name one.
+	ClusterID string `json:"cluster_id,omitempty"`
+}
+
+// TokenEntry is a single API token in the tokens file. Either Token or TokenSHA256 must be set.
+// Several entries may share the same name, which is how tokens are rotated: add the new token,
+// roll it out to the caller, then remove the old entry or let it expire.
+type TokenEntry struct {
+	Name        string     `json:"name"`
+	Token       string     `json:"token,omitempty"`
+	TokenSHA256 string     `json:"token_sha256,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+type tokensFile struct {
+	Tokens []TokenEntry `json:"tokens"`
+}
+
+type storedToken struct {
+	name      string
+	hash      []byte
+	expiresAt *time.Time
+}
+
+// TokenStore holds the accepted API tokens. Tokens are kept as SHA-256 hashes and the tokens file
+// is reloaded when it changes on disk, so tokens can be added and revoked without a restart.
+type TokenStore struct {
+	mu          sync.RWMutex
+	path        string
+	legacyToken string
+	modTime     time.Time
+	lastCheck   time.Time
+	tokens      []storedToken
+}
+
+var Tokens = &TokenStore{}
+
+// InitTokenStore loads the tokens file at path into the global token store. The legacy token
+// read from auth.secret, if set, is accepted as well under the name DefaultCallerName.
+func InitTokenStore(path string, legacyToken string) error {
+	return Tokens.Load(path, legacyToken)
+}
+
+// Load replaces the tokens in the store with the tokens from the file at path and the legacy token.
+func (s *TokenStore) Load(path string, legacyToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	s.legacyToken = legacyToken
+	return s.reload()
+}
+
+// reload reads the tokens file. The caller must hold the write lock.
+func (s *TokenStore) reload() error {
+	var tokens []storedToken
+
+	if s.legacyToken != "" {
+		hash := sha256.Sum256([]byte(s.legacyToken))
+		tokens = append(tokens, storedToken{name: DefaultCallerName, hash: hash[:]})
+	}
+
+	s.lastCheck = time.Now()
+
+	if s.path != "" {
+		info, err := os.Stat(s.path)
+		if err != nil {
+			return fmt.Errorf("failed to read tokens file: %w", err)
+		}
+
+		content, err := os.ReadFile(filepath.Clean(s.path))
+		if err != nil {
+			return fmt.Errorf("failed to read tokens file: %w", err)
+		}
+
+		var file tokensFile
+		if err := json.Unmarshal(content, &file); err != nil {
+			return fmt.Errorf("failed to parse tokens file: %w", err)
+		}
+
+		for i, entry := range file.Tokens {
+			token, err := entry.toStoredToken()
+			if err != nil {
+				return fmt.Errorf("invalid token entry %d in tokens file: %w", i, err)
+			}
+			tokens = append(tokens, token)
+		}
+
+		s.modTime = info.ModTime()
+	}
+
+	if len(tokens) == 0 {
+		return errors.New("no authentication tokens configured")
+	}
+
+	s.tokens = tokens
+	return nil
+}
+
+func (e TokenEntry) toStoredToken() (storedToken, error) {
+	if e.Name == "" {
+		return storedToken{}, errors.New("name is required")
+	}
+
+	switch {
+	case e.TokenSHA256 != "":
+		hash, err := hex.DecodeString(strings.TrimSpace(e.TokenSHA256))
+		if err != nil || len(hash) != sha256.Size {
+			return storedToken{}, errors.New("token_sha256 must be a hex encoded SHA-256 hash")
+		}
+		return storedToken{name: e.Name, hash: hash, expiresAt: e.ExpiresAt}, nil
+	case e.Token != "":
+		hash := sha256.Sum256([]byte(e.Token))
+		return storedToken{name: e.Name, hash: hash[:], expiresAt: e.ExpiresAt}, nil
+	default:
+		return storedToken{}, errors.New("either token or token_sha256 is required")
+	}
+}
+
+// reloadIfChanged reloads the tokens file when its modification time has changed. A failed reload
+// keeps the previously loaded tokens so a half-written file does not lock every caller out.
+func (s *TokenStore) reloadIfChanged() {
+	s.mu.RLock()
+	due := s.path != "" && time.Since(s.lastCheck) >= reloadInterval
+	s.mu.RUnlock()
+
+	if !due {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastCheck) < reloadInterval {
+		return
+	}
+	s.lastCheck = time.Now()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		logger.Log.Errorf("Failed to check tokens file: %v", err)
+		return
+	}
+
+	if info.ModTime().Equal(s.modTime) {
+		return
+	}
+
+	if err := s.reload(); err != nil {
+		logger.Log.Errorf("Failed to reload tokens file, keeping previous tokens: %v", err)
+		return
+	}
+
+	logger.Log.Infof("Reloaded authentication tokens from %s", s.path)
+}
+
+// Configured reports whether the store holds any tokens.
+func (s *TokenStore) Configured() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.tokens) > 0
+}
+
+// Authenticate returns the identity of the caller owning the provided token.
+// Expired tokens are rejected.
+func (s *TokenStore) Authenticate(token string) (Identity, error) {
+	if token == "" {
+		return Identity{}, ErrMissingToken
+	}
+
+	s.reloadIfChanged()
+
+	hash := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, stored := range s.tokens {
+		if subtle.ConstantTimeCompare(stored.hash, hash[:]) != 1 {
+			continue
+		}
+		if stored.expiresAt != nil && now.After(*stored.expiresAt) {
+			return Identity{}, ErrInvalidToken
+		}
+		return Identity{Name: stored.name, Method: MethodToken}, nil
+	}
+
+	return Identity{}, ErrInvalidToken
+}
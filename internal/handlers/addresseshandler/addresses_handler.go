@@ -1,6 +1,7 @@
 package addresseshandler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,9 +10,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/vitistack/ipam-api/internal/auth"
 	"github.com/vitistack/ipam-api/internal/logger"
 	"github.com/vitistack/ipam-api/internal/services/addressesservice"
 	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
 	"github.com/vitistack/ipam-api/internal/utils"
 	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
 )
@@ -20,15 +23,20 @@ import (
 //
 //	@Summary	Register an address
 //	@Schemes
-//	@Description	Register an address in Vitistack IPAM API
+//	@Description	Register an address in Vitistack IPAM API. Use ip_family 'dual' to register an IPv4 and an IPv6 address for the service in one request.
 //	@Tags			addresses
 //	@Accept			json
 //	@Produce		json
+//	@Security		BearerAuth
 //	@Success		200		{object}	apicontracts.IpamAPIResponse
 //	@Param			body	body		apicontracts.IpamAPIRequest	true	"Request body"
 //	@Failure		400		{object}	apicontracts.HTTPError
+//	@Failure		401		{object}	apicontracts.HTTPError
+//	@Failure		403		{object}	apicontracts.HTTPError
 //	@Failure		404		{object}	apicontracts.HTTPError
+//	@Failure		429		{object}	apicontracts.HTTPError
 //	@Failure		500		{object}	apicontracts.HTTPError
+//	@Failure		503		{object}	apicontracts.HTTPError
 //	@Router			/address [POST]
 func RegisterAddress(ginContext *gin.Context) {
 	var request apicontracts.IpamAPIRequest
@@ -43,7 +51,14 @@ func RegisterAddress(ginContext *gin.Context) {
 		return
 	}
 
-	err = ValidateRequest(&request)
+	if !authorize(ginContext, auth.Scope{Zone: request.Zone, ClusterID: request.Service.ClusterID}) {
+		return
+	}
+	if request.NewSecret != "" && !authorizeAdmin(ginContext) {
+		return
+	}
+
+	err = ValidateRequest(ginContext.Request.Context(), &request)
 
 	if err != nil {
 		logger.Log.Errorf("Request validation failed: %v", err)
@@ -51,21 +66,21 @@ func RegisterAddress(ginContext *gin.Context) {
 		if err != nil {
 			logger.Log.Errorf("Failed to attach error to context: %v", err)
 		}
-		ginContext.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		ginContext.JSON(validationStatus(err), gin.H{"message": err.Error()})
 		return
 	}
 
 	var response apicontracts.IpamAPIResponse
 	httpStatus := http.StatusOK
 
-	response, err = addressesservice.RegisterAddress(request)
+	response, err = addressesservice.RegisterAddress(ginContext.Request.Context(), request)
 	if err != nil {
 		logger.Log.Errorf("Failed to register address: %v", err)
 		err := ginContext.Error(err)
 		if err != nil {
 			logger.Log.Errorf("Failed to attach error to context: %v", err)
 		}
-		ginContext.JSON(http.StatusInternalServerError, gin.H{"message": "Could not register address: " + err.Error()})
+		ginContext.JSON(registrationStatus(err), gin.H{"message": "Could not register address: " + err.Error()})
 		return
 	}
 
@@ -73,6 +88,323 @@ func RegisterAddress(ginContext *gin.Context) {
 
 }
 
+// RegisterBatch godoc
+//
+//	@Summary	Register several addresses
+//	@Schemes
+//	@Description	Register several addresses in one call, either from a list of items or by generating 'count' items from 'template'. Generated items get their index appended to the service name. The batch is registered as one unit: if an item fails, the items registered before it are rolled back. The result of every item is reported in the response.
+//	@Tags			addresses
+//	@Accept			json
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Param			body	body		apicontracts.IpamAPIBatchRequest	true	"Request body"
+//	@Success		200		{object}	apicontracts.IpamAPIBatchResponse
+//	@Failure		400		{object}	apicontracts.IpamAPIBatchResponse
+//	@Failure		401		{object}	apicontracts.HTTPError
+//	@Failure		403		{object}	apicontracts.IpamAPIBatchResponse
+//	@Failure		429		{object}	apicontracts.IpamAPIBatchResponse
+//	@Failure		500		{object}	apicontracts.IpamAPIBatchResponse
+//	@Failure		503		{object}	apicontracts.IpamAPIBatchResponse
+//	@Router			/addresses:batch [POST]
+func RegisterBatch(ginContext *gin.Context) {
+	var request apicontracts.IpamAPIBatchRequest
+	err := ginContext.ShouldBindJSON(&request)
+
+	if err != nil {
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse incomming request"})
+		return
+	}
+
+	items, err := expandBatchRequest(request)
+
+	if err != nil {
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	identity, authenticated := auth.IdentityFromContext(ginContext)
+	for index, item := range items {
+		err := auth.CallerPolicies.Authorize(identity, authenticated, auth.Scope{Zone: item.Zone, ClusterID: item.Service.ClusterID})
+		if err == nil && item.NewSecret != "" {
+			err = auth.CallerPolicies.AuthorizeAdmin(identity, authenticated)
+		}
+		if err != nil {
+			allowed(ginContext, fmt.Errorf("item %d: %w", index, err))
+			return
+		}
+	}
+
+	netboxZones, err := netboxservice.GetK8sZones(ginContext.Request.Context())
+
+	if err != nil {
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(registrationStatus(err), gin.H{"message": "failed to fetch zones: " + err.Error()})
+		return
+	}
+
+	results := make([]apicontracts.IpamAPIBatchItemResult, len(items))
+	invalid := false
+	for index := range items {
+		results[index] = apicontracts.IpamAPIBatchItemResult{Index: index, Status: apicontracts.BatchStatusSkipped}
+		if err := ValidateRequestForZones(&items[index], netboxZones); err != nil {
+			results[index].Status = apicontracts.BatchStatusInvalid
+			results[index].Error = err.Error()
+			invalid = true
+		}
+	}
+
+	if invalid {
+		err := ginContext.Error(errors.New("batch contains invalid items"))
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(http.StatusBadRequest, apicontracts.IpamAPIBatchResponse{
+			Message: "Batch contains invalid items, no addresses were registered",
+			Results: results,
+		})
+		return
+	}
+
+	response, err := addressesservice.RegisterBatch(ginContext.Request.Context(), items)
+
+	if err != nil {
+		logger.Log.Errorf("Failed to register batch: %v", err)
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(registrationStatus(err), response)
+		return
+	}
+
+	ginContext.JSON(http.StatusOK, response)
+
+}
+
+// registrationStatus returns the HTTP status for a failed registration: 429 Too Many Requests when a
+// quota on the number of addresses is exceeded, 403 Forbidden when the requested prefix is larger than
+// the quota allows, 503 Service Unavailable when Netbox calls are queued beyond their limit or the quota
+// of the service stays locked by other registrations, and 500 Internal Server Error otherwise.
+func registrationStatus(err error) int {
+	switch {
+	case errors.Is(err, addressesservice.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, addressesservice.ErrPrefixTooLarge):
+		return http.StatusForbidden
+	case errors.Is(err, netboxservice.ErrNetboxBusy), errors.Is(err, storageservice.ErrLeaseBusy):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// validationStatus returns the HTTP status for a request that failed validation: 503 Service Unavailable
+// when the zones could not be fetched because Netbox calls are queued beyond their limit and
+// 400 Bad Request otherwise.
+func validationStatus(err error) int {
+	if errors.Is(err, netboxservice.ErrNetboxBusy) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// expandBatchRequest returns the items of a batch request, generating them from the template when
+// count is set.
+func expandBatchRequest(request apicontracts.IpamAPIBatchRequest) ([]apicontracts.IpamAPIRequest, error) {
+	err := validator.New().Struct(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(request.Items) > 0 && request.Count > 0 {
+		return nil, errors.New("either 'items' or 'count' can be set, not both")
+	}
+
+	if request.Count == 0 {
+		if len(request.Items) == 0 {
+			return nil, errors.New("either 'items' or 'count' with 'template' is required")
+		}
+		return request.Items, nil
+	}
+
+	if request.Template == nil {
+		return nil, errors.New("'template' is required when 'count' is set")
+	}
+
+	if request.Template.Address != "" {
+		return nil, errors.New("'template' cannot contain an address")
+	}
+
+	items := make([]apicontracts.IpamAPIRequest, 0, request.Count)
+	for index := range request.Count {
+		item := *request.Template
+		item.Service.ServiceName = fmt.Sprintf("%s-%d", request.Template.Service.ServiceName, index)
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// GetAddress godoc
+//
+//	@Summary	Get an address
+//	@Schemes
+//	@Description	Get an address registered with the provided secret, zone, IP family and address. Under caller policies, only the services of clusters the caller may act on are returned
+//	@Tags			addresses
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Param			X-Ipam-Secret	header		string	true	"Secret the address was registered with"
+//	@Param			zone			query		string	true	"Zone"
+//	@Param			ip_family		query		string	true	"IP family"	Enums(ipv4, ipv6)
+//	@Param			address			query		string	true	"Address"
+//	@Success		200				{object}	apicontracts.IpamAPIAddressResponse
+//	@Failure		400				{object}	apicontracts.HTTPError
+//	@Failure		401				{object}	apicontracts.HTTPError
+//	@Failure		403				{object}	apicontracts.HTTPError
+//	@Failure		404				{object}	apicontracts.HTTPError
+//	@Failure		500				{object}	apicontracts.HTTPError
+//	@Router			/address [GET]
+func GetAddress(ginContext *gin.Context) {
+	var request apicontracts.IpamAPIGetAddressRequest
+
+	err := ginContext.ShouldBindQuery(&request)
+	if err == nil {
+		err = ginContext.ShouldBindHeader(&request)
+	}
+
+	if err != nil {
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse incomming request"})
+		return
+	}
+
+	if !authorize(ginContext, auth.Scope{Zone: request.Zone}) {
+		return
+	}
+
+	err = ValidateGetAddressRequest(&request)
+
+	if err != nil {
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	response, err := addressesservice.GetAddress(ginContext.Request.Context(), request)
+
+	if err != nil {
+		httpStatus := http.StatusInternalServerError
+		if errors.Is(err, storageservice.ErrAddressNotFound) {
+			httpStatus = http.StatusNotFound
+		}
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(httpStatus, gin.H{"message": "Could not get address: " + err.Error()})
+		return
+	}
+
+	services, ok := authorizedServices(ginContext, response)
+	if !ok {
+		return
+	}
+	response.Services = services
+
+	ginContext.JSON(http.StatusOK, response)
+
+}
+
+// ListAddresses godoc
+//
+//	@Summary	List addresses
+//	@Schemes
+//	@Description	List addresses filtered by zone, IP family, cluster, namespace or service name. Results are paginated with the cursor returned in the previous page. Cluster callers see only the services of their clusters, in the zones they may use.
+//	@Tags			addresses
+//	@Produce		json
+//	@Security		BearerAuth
+//	@Param			zone			query		string	false	"Zone"
+//	@Param			ip_family		query		string	false	"IP family"	Enums(ipv4, ipv6)
+//	@Param			cluster_id		query		string	false	"Cluster ID"
+//	@Param			namespace_id	query		string	false	"Namespace ID"
+//	@Param			service_name	query		string	false	"Service name"
+//	@Param			limit			query		int		false	"Page size (default 100, max 500)"
+//	@Param			cursor			query		string	false	"Cursor returned as next_cursor in the previous page"
+//	@Success		200				{object}	apicontracts.IpamAPIListAddressesResponse
+//	@Failure		400				{object}	apicontracts.HTTPError
+//	@Failure		401				{object}	apicontracts.HTTPError
+//	@Failure		403				{object}	apicontracts.HTTPError
+//	@Failure		500				{object}	apicontracts.HTTPError
+//	@Router			/addresses [GET]
+func ListAddresses(ginContext *gin.Context) {
+	var request apicontracts.IpamAPIListAddressesRequest
+
+	err := ginContext.ShouldBindQuery(&request)
+
+	if err != nil {
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse incomming request"})
+		return
+	}
+
+	err = validator.New().Struct(request)
+
+	if err != nil {
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// Only admins may list the addresses of every cluster
+	if request.ClusterID == "" && !authorizeAdmin(ginContext) {
+		return
+	}
+	if request.ClusterID != "" && !authorize(ginContext, auth.Scope{Zone: request.Zone, ClusterID: request.ClusterID}) {
+		return
+	}
+
+	response, err := addressesservice.ListAddresses(ginContext.Request.Context(), request)
+
+	if err != nil {
+		err := ginContext.Error(err)
+		if err != nil {
+			logger.Log.Errorf("Failed to attach error to context: %v", err)
+		}
+		ginContext.JSON(http.StatusInternalServerError, gin.H{"message": "Could not list addresses: " + err.Error()})
+		return
+	}
+
+	// The addresses of the cluster may be shared with other clusters, or be in zones the caller may not use
+	response.Addresses = authorizedAddresses(ginContext, response.Addresses)
+
+	ginContext.JSON(http.StatusOK, response)
+
+}
+
 // ExpireAddress godoc
 //
 //	@Summary	Set expiration for a service
@@ -81,11 +413,15 @@ func RegisterAddress(ginContext *gin.Context) {
 //	@Tags			addresses
 //	@Accept			json
 //	@Produce		json
+//	@Security		BearerAuth
 //	@Param			body	body		apicontracts.IpamAPIRequest	true	"Request body"
 //	@Success		200		{object}	apicontracts.IpamAPIResponse
 //	@Failure		400		{object}	apicontracts.HTTPError
+//	@Failure		401		{object}	apicontracts.HTTPError
+//	@Failure		403		{object}	apicontracts.HTTPError
 //	@Failure		404		{object}	apicontracts.HTTPError
 //	@Failure		500		{object}	apicontracts.HTTPError
+//	@Failure		503		{object}	apicontracts.HTTPError
 //	@Router			/service [DELETE]
 func ExpireAddress(ginContext *gin.Context) {
 	var prefixRequest apicontracts.IpamAPIRequest
@@ -101,18 +437,26 @@ func ExpireAddress(ginContext *gin.Context) {
 		return
 	}
 
-	err = ValidateRequest(&prefixRequest)
+	if !authorize(ginContext, auth.Scope{Zone: prefixRequest.Zone, ClusterID: prefixRequest.Service.ClusterID}) {
+		return
+	}
+
+	err = ValidateRequest(ginContext.Request.Context(), &prefixRequest)
+
+	if err == nil && prefixRequest.IPFamily == "dual" {
+		err = errors.New("ip family 'dual' can only be used when registering addresses")
+	}
 
 	if err != nil {
 		err := ginContext.Error(err)
 		if err != nil {
 			logger.Log.Errorf("Failed to attach error to context: %v", err)
 		}
-		ginContext.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		ginContext.JSON(validationStatus(err), gin.H{"message": err.Error()})
 		return
 	}
 
-	response, err := addressesservice.SetServiceExpiration(prefixRequest)
+	response, err := addressesservice.SetServiceExpiration(ginContext.Request.Context(), prefixRequest)
 
 	if err != nil {
 		err := ginContext.Error(err)
@@ -135,9 +479,12 @@ func ExpireAddress(ginContext *gin.Context) {
 //	@Tags			addresses
 //	@Accept			json
 //	@Produce		json
+//	@Security		BearerAuth
 //	@Param			body	body		apicontracts.IpamAPIDeleteClusterRequest	true	"Request body"
 //	@Success		200		{object}	apicontracts.IpamAPIResponse
 //	@Failure		400		{object}	apicontracts.HTTPError
+//	@Failure		401		{object}	apicontracts.HTTPError
+//	@Failure		403		{object}	apicontracts.HTTPError
 //	@Failure		404		{object}	apicontracts.HTTPError
 //	@Failure		500		{object}	apicontracts.HTTPError
 //	@Router			/cluster [DELETE]
@@ -155,6 +502,10 @@ func ExpireCluster(ginContext *gin.Context) {
 		return
 	}
 
+	if !authorizeAdmin(ginContext) {
+		return
+	}
+
 	err = ValidateDeleteClusterRequest(&request)
 
 	if err != nil {
@@ -166,7 +517,10 @@ func ExpireCluster(ginContext *gin.Context) {
 		return
 	}
 
-	response, err := addressesservice.SetClusterExpiration(request)
+	identity, _ := auth.IdentityFromContext(ginContext)
+	logger.Log.Infof("Cluster expiration for cluster_id '%s' requested by '%s'", request.ClusterID, identity.Name)
+
+	response, err := addressesservice.SetClusterExpiration(ginContext.Request.Context(), request)
 
 	if err != nil {
 		err := ginContext.Error(err)
@@ -181,20 +535,27 @@ func ExpireCluster(ginContext *gin.Context) {
 
 }
 
-func ValidateRequest(request *apicontracts.IpamAPIRequest) error {
-	validate := validator.New()
-
-	netboxZones, err := netboxservice.GetK8sZones()
+func ValidateRequest(ctx context.Context, request *apicontracts.IpamAPIRequest) error {
+	netboxZones, err := netboxservice.GetK8sZones(ctx)
 
 	if err != nil {
-		return errors.New("failed to fetch zones: " + err.Error())
+		return fmt.Errorf("failed to fetch zones: %w", err)
 	}
 
-	err = validate.Struct(*request)
+	return ValidateRequestForZones(request, netboxZones)
+}
+
+// ValidateRequestForZones validates the request like ValidateRequest, using an already fetched list of
+// Netbox zones. This avoids fetching the zones from Netbox for every request in a batch.
+func ValidateRequestForZones(request *apicontracts.IpamAPIRequest, netboxZones []string) error {
+	validate := validator.New()
+
+	err := validate.Struct(*request)
 
 	if err != nil {
-		if err.Error() == "Key: 'IpamApiRequest.IpFamily' Error:Field validation for 'IpFamily' failed on the 'oneof' tag" {
-			return errors.New("invalid ip family, must be either 'ipv4' or 'ipv6'")
+		var validationErrors validator.ValidationErrors
+		if errors.As(err, &validationErrors) && validationErrors[0].Field() == "IPFamily" {
+			return errors.New("invalid ip family, must be either 'ipv4', 'ipv6' or 'dual'")
 		}
 		return err
 	}
@@ -210,6 +571,29 @@ func ValidateRequest(request *apicontracts.IpamAPIRequest) error {
 		return fmt.Errorf("invalid zone '%s', must be one of: '%s'", request.Zone, strings.Join(netboxZones, "', '"))
 	}
 
+	if request.IPFamily == "dual" {
+		if request.Address != "" || request.PrefixLength != 0 || request.NewSecret != "" {
+			return errors.New("address, prefix_length and new_secret cannot be used with ip family 'dual'")
+		}
+		for _, ipFamily := range []string{"ipv4", "ipv6"} {
+			if err := validateIPFamily(request, ipFamily); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return validateIPFamily(request, request.IPFamily)
+}
+
+// validateIPFamily validates the address and prefix length of the request for a single IP family and
+// checks that the zone has prefix containers for that family.
+func validateIPFamily(request *apicontracts.IpamAPIRequest, ipFamily string) error {
+	prefixLength := request.PrefixLength
+	if prefixLength == 0 {
+		prefixLength = utils.HostPrefixLength(ipFamily)
+	}
+
 	if request.Address != "" {
 		prefixIPFamily, err := utils.IPFamilyFromPrefix(request.Address)
 
@@ -217,16 +601,55 @@ func ValidateRequest(request *apicontracts.IpamAPIRequest) error {
 			return err
 		}
 
-		if prefixIPFamily != request.IPFamily {
+		if prefixIPFamily != ipFamily {
 			return errors.New("invalid ip familiy for the provided address")
 		}
+
+		addressPrefixLength, err := utils.PrefixLength(request.Address)
+
+		if err != nil {
+			return err
+		}
+
+		if request.PrefixLength != 0 && request.PrefixLength != addressPrefixLength {
+			return errors.New("prefix_length does not match the prefix length of the provided address")
+		}
+		prefixLength = addressPrefixLength
 	}
 
-	zone := request.Zone + "_v" + string(request.IPFamily[len(request.IPFamily)-1])
+	if err := utils.ValidatePrefixLength(request.Zone, ipFamily, prefixLength); err != nil {
+		return err
+	}
+
+	zone := request.Zone + "_v" + string(ipFamily[len(ipFamily)-1])
 	zonePrefixes := netboxservice.Cache.Get(zone)
 
 	if len(zonePrefixes) == 0 {
-		return fmt.Errorf("no prefixes found for zone %s with IP family %s", request.Zone, request.IPFamily)
+		return fmt.Errorf("no prefixes found for zone %s with IP family %s", request.Zone, ipFamily)
+	}
+
+	return nil
+}
+
+func ValidateGetAddressRequest(request *apicontracts.IpamAPIGetAddressRequest) error {
+	validate := validator.New()
+
+	err := validate.Struct(*request)
+
+	if err != nil {
+		return err
+	}
+
+	request.Address = utils.NormalizeCIDR(request.Address)
+
+	prefixIPFamily, err := utils.IPFamilyFromPrefix(request.Address)
+
+	if err != nil {
+		return err
+	}
+
+	if prefixIPFamily != request.IPFamily {
+		return errors.New("invalid ip familiy for the provided address")
 	}
 
 	return nil
@@ -0,0 +1,97 @@
+package addresseshandler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitistack/ipam-api/internal/auth"
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+)
+
+// authorize checks that the caller of the request may act on scope under the caller policies. It responds
+// with 401 Unauthorized or 403 Forbidden and returns false if not.
+func authorize(ginContext *gin.Context, scope auth.Scope) bool {
+	identity, authenticated := auth.IdentityFromContext(ginContext)
+	return allowed(ginContext, auth.CallerPolicies.Authorize(identity, authenticated, scope))
+}
+
+// authorizeAdmin checks that the caller of the request is an admin under the caller policies. It responds
+// with 401 Unauthorized or 403 Forbidden and returns false if not.
+func authorizeAdmin(ginContext *gin.Context) bool {
+	identity, authenticated := auth.IdentityFromContext(ginContext)
+	return allowed(ginContext, auth.CallerPolicies.AuthorizeAdmin(identity, authenticated))
+}
+
+// authorizedServices returns the services of address whose clusters the caller of the request may act on
+// under the caller policies, so that a caller allowed in the zone does not see the services of other
+// clusters. It responds with 401 Unauthorized or 403 Forbidden and returns false if the address has
+// services and the caller may act on none of them.
+func authorizedServices(ginContext *gin.Context, address apicontracts.IpamAPIAddressResponse) ([]apicontracts.Service, bool) {
+	identity, authenticated := auth.IdentityFromContext(ginContext)
+
+	services, err := visibleServices(identity, authenticated, address)
+	if err != nil {
+		return nil, allowed(ginContext, err)
+	}
+	return services, true
+}
+
+// authorizedAddresses returns addresses with only the services the caller of the request may act on under
+// the caller policies. Addresses the caller may act on none of the services of, for example because they
+// are in a zone the caller may not use, are left out.
+func authorizedAddresses(ginContext *gin.Context, addresses []apicontracts.IpamAPIAddressResponse) []apicontracts.IpamAPIAddressResponse {
+	identity, authenticated := auth.IdentityFromContext(ginContext)
+
+	visible := make([]apicontracts.IpamAPIAddressResponse, 0, len(addresses))
+	for _, address := range addresses {
+		services, err := visibleServices(identity, authenticated, address)
+		if err != nil {
+			continue
+		}
+		address.Services = services
+		visible = append(visible, address)
+	}
+	return visible
+}
+
+// visibleServices returns the services of address whose cluster the caller may act on in the zone of the
+// address. It returns an authorization error if the address has services and the caller may act on none.
+func visibleServices(identity auth.Identity, authenticated bool, address apicontracts.IpamAPIAddressResponse) ([]apicontracts.Service, error) {
+	services := make([]apicontracts.Service, 0, len(address.Services))
+	for _, service := range address.Services {
+		err := auth.CallerPolicies.Authorize(identity, authenticated, auth.Scope{Zone: address.Zone, ClusterID: service.ClusterID})
+		if err == nil {
+			services = append(services, service)
+		} else if errors.Is(err, auth.ErrMissingToken) {
+			return nil, err
+		}
+	}
+
+	if len(services) == 0 && len(address.Services) > 0 {
+		return nil, fmt.Errorf("%w: %s may not act on the clusters of address %s", auth.ErrForbidden, identity.Name, address.Address)
+	}
+	return services, nil
+}
+
+// allowed responds with the status for the authorization error err and returns false, or returns true if
+// err is nil.
+func allowed(ginContext *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	httpStatus := http.StatusForbidden
+	if errors.Is(err, auth.ErrMissingToken) {
+		httpStatus = http.StatusUnauthorized
+	}
+
+	logger.Log.Warnf("Denied %s %s: %v", ginContext.Request.Method, ginContext.Request.URL.Path, err)
+	if err := ginContext.Error(err); err != nil {
+		logger.Log.Errorf("Failed to attach error to context: %v", err)
+	}
+	ginContext.JSON(httpStatus, gin.H{"message": err.Error()})
+	return false
+}
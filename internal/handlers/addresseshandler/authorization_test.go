@@ -0,0 +1,161 @@
+package addresseshandler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/auth"
+	"github.com/vitistack/ipam-api/internal/handlers/addresseshandler"
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice/netboxfake"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+)
+
+// newEngine installs a memory repository and a Netbox fake with containers in zone inet, and returns
+// an engine with the address routes that authenticates the token caller named in the X-Test-Identity header.
+func newEngine(t *testing.T) *gin.Engine {
+	t.Helper()
+	logger.InitConsoleLogger()
+	gin.SetMode(gin.TestMode)
+
+	previousRepository := repository.GetRepository()
+	previousKey := viper.Get("secret_lookup_key")
+	t.Cleanup(func() {
+		repository.SetRepository(previousRepository)
+		viper.Set("secret_lookup_key", previousKey)
+		if err := auth.CallerPolicies.Load(nil); err != nil {
+			t.Errorf("failed to reset caller policies: %v", err)
+		}
+	})
+	viper.Set("secret_lookup_key", strings.Repeat("k", 32))
+
+	netboxfake.Install(t)
+	repository.SetRepository(repository.NewMemoryRepository())
+
+	engine := gin.New()
+	engine.Use(func(ginContext *gin.Context) {
+		if name := ginContext.GetHeader("X-Test-Identity"); name != "" {
+			auth.SetIdentity(ginContext, auth.Identity{Name: name, Method: auth.MethodToken})
+		}
+		ginContext.Next()
+	})
+	engine.POST("/v2/address", addresseshandler.RegisterAddress)
+	engine.GET("/v2/address", addresseshandler.GetAddress)
+	engine.GET("/v2/addresses", addresseshandler.ListAddresses)
+	return engine
+}
+
+// registerForClusters registers one address in zone inet for a service of each cluster and returns it.
+func registerForClusters(t *testing.T, engine *gin.Engine, clusters ...string) string {
+	t.Helper()
+	address := ""
+	for _, cluster := range clusters {
+		body := `{"secret":"a_secret_value","zone":"inet","ip_family":"ipv4","address":"` + address + `",` +
+			`"service":{"service_name":"service1","namespace_id":"namespace1","cluster_id":"` + cluster + `"}}`
+		request := httptest.NewRequest(http.MethodPost, "/v2/address", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("failed to register the address for %s: %d %s", cluster, recorder.Code, recorder.Body.String())
+		}
+
+		var response apicontracts.IpamAPIResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		address = response.Address
+	}
+	return address
+}
+
+// loadClusterPolicies lets the token callers controller1 and controller3 act on cluster1 and cluster3 in zone inet.
+func loadClusterPolicies(t *testing.T) {
+	t.Helper()
+	err := auth.CallerPolicies.Load([]auth.CallerPolicy{
+		{Method: auth.MethodToken, Name: "controller1", Roles: []string{auth.RoleCluster}, ClusterIDs: []string{"cluster1"}, Zones: []string{"inet"}},
+		{Method: auth.MethodToken, Name: "controller3", Roles: []string{auth.RoleCluster}, ClusterIDs: []string{"cluster3"}, Zones: []string{"inet"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to load caller policies: %v", err)
+	}
+}
+
+// TestGetAddressReturnsOnlyServicesOfAllowedClusters registers an address for two clusters and checks that a
+// cluster caller sees only the service of its own cluster, and that a caller of a third cluster is denied.
+func TestGetAddressReturnsOnlyServicesOfAllowedClusters(t *testing.T) {
+	engine := newEngine(t)
+	address := registerForClusters(t, engine, "cluster1", "cluster2")
+	loadClusterPolicies(t)
+
+	get := func(caller string) *httptest.ResponseRecorder {
+		query := url.Values{"zone": {"inet"}, "ip_family": {"ipv4"}, "address": {address}}
+		request := httptest.NewRequest(http.MethodGet, "/v2/address?"+query.Encode(), nil)
+		request.Header.Set("X-Ipam-Secret", "a_secret_value")
+		request.Header.Set("X-Test-Identity", caller)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := get("controller1")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var response apicontracts.IpamAPIAddressResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.Services) != 1 || response.Services[0].ClusterID != "cluster1" {
+		t.Errorf("expected only the service of cluster1, got %+v", response.Services)
+	}
+
+	if recorder := get("controller3"); recorder.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for a caller of another cluster, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+// TestListAddressesReturnsOnlyServicesOfAllowedClusters checks that a cluster caller listing the addresses of
+// its cluster does not see the services of other clusters sharing an address, nor addresses in other zones.
+func TestListAddressesReturnsOnlyServicesOfAllowedClusters(t *testing.T) {
+	engine := newEngine(t)
+	address := registerForClusters(t, engine, "cluster1", "cluster2")
+	_, err := repository.GetRepository().InsertAddress(context.Background(), mongodbtypes.Address{
+		Zone:     "dmz",
+		IPFamily: "ipv4",
+		Address:  "192.168.0.1/32",
+		Services: []mongodbtypes.Service{{ServiceName: "service1", NamespaceID: "namespace1", ClusterID: "cluster1"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert address: %v", err)
+	}
+	loadClusterPolicies(t)
+
+	request := httptest.NewRequest(http.MethodGet, "/v2/addresses?cluster_id=cluster1", nil)
+	request.Header.Set("X-Test-Identity", "controller1")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response apicontracts.IpamAPIListAddressesResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.Addresses) != 1 || response.Addresses[0].Address != address {
+		t.Fatalf("expected only the address in zone inet, got %+v", response.Addresses)
+	}
+	if services := response.Addresses[0].Services; len(services) != 1 || services[0].ClusterID != "cluster1" {
+		t.Errorf("expected only the service of cluster1, got %+v", services)
+	}
+}
@@ -0,0 +1,126 @@
+package addresseshandler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/handlers/addresseshandler"
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/middleware"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice/netboxfake"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	incomingTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	incomingSpanID  = "00f067aa0ba902b7"
+)
+
+// TestRegisterAddressTracing registers an address with a W3C traceparent header and checks that the
+// request, the service operation, the Netbox calls and the storage operations are spans of the incoming trace.
+func TestRegisterAddressTracing(t *testing.T) {
+	logger.InitConsoleLogger()
+	gin.SetMode(gin.TestMode)
+	previousKey := viper.Get("secret_lookup_key")
+	t.Cleanup(func() { viper.Set("secret_lookup_key", previousKey) })
+	viper.Set("secret_lookup_key", strings.Repeat("k", 32))
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previous)
+	})
+
+	netboxfake.Install(t)
+	previousRepository := repository.GetRepository()
+	t.Cleanup(func() { repository.SetRepository(previousRepository) })
+	repository.SetRepository(repository.NewMemoryRepository())
+	exporter.Reset()
+
+	engine := gin.New()
+	engine.Use(middleware.Tracing())
+	engine.POST("/v2/address", addresseshandler.RegisterAddress)
+
+	body := `{"secret":"a_secret_value","zone":"inet","ip_family":"ipv4","service":{"service_name":"service1",` +
+		`"namespace_id":"namespace1","cluster_id":"cluster1"}}`
+	request := httptest.NewRequest(http.MethodPost, "/v2/address", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("traceparent", "00-"+incomingTraceID+"-"+incomingSpanID+"-01")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		if got := span.SpanContext.TraceID().String(); got != incomingTraceID {
+			t.Errorf("span %q has trace ID %s, expected the incoming trace %s", span.Name, got, incomingTraceID)
+		}
+		byName[span.Name] = span
+	}
+
+	for _, name := range []string{
+		"POST /v2/address",
+		"addressesservice.RegisterAddress",
+		"netbox CreateAvailablePrefix",
+		"storage InsertAddress",
+	} {
+		if _, ok := byName[name]; !ok {
+			t.Errorf("expected a span named %q, got %v", name, spanNames(spans))
+		}
+	}
+
+	server := byName["POST /v2/address"]
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("expected the request span to be a server span, got %s", server.SpanKind)
+	}
+	if got := server.Parent.SpanID().String(); got != incomingSpanID {
+		t.Errorf("expected the request span to continue the incoming span %s, got parent %s", incomingSpanID, got)
+	}
+
+	// The Netbox call is made within the service operation, which runs within the request.
+	if !descendsFrom(spans, byName["netbox CreateAvailablePrefix"], "addressesservice.RegisterAddress") {
+		t.Errorf("expected the Netbox span to descend from the service span")
+	}
+	if !descendsFrom(spans, byName["addressesservice.RegisterAddress"], "POST /v2/address") {
+		t.Errorf("expected the service span to descend from the request span")
+	}
+}
+
+// descendsFrom reports whether ancestor is one of the parents of span.
+func descendsFrom(spans tracetest.SpanStubs, span tracetest.SpanStub, ancestor string) bool {
+	bySpanID := make(map[trace.SpanID]tracetest.SpanStub, len(spans))
+	for _, stub := range spans {
+		bySpanID[stub.SpanContext.SpanID()] = stub
+	}
+
+	for parent, ok := bySpanID[span.Parent.SpanID()]; ok; parent, ok = bySpanID[parent.Parent.SpanID()] {
+		if parent.Name == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
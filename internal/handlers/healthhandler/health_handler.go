@@ -0,0 +1,79 @@
+package healthhandler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+)
+
+// checkTimeout limits how long each readiness check may take, so the probe answers before its own timeout.
+const checkTimeout = 2 * time.Second
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// Healthz reports that the process is running and serving requests. It does not check the dependencies,
+// so an outage of Netbox or the storage does not get the pod restarted.
+func Healthz(ginContext *gin.Context) {
+	ginContext.JSON(http.StatusOK, apicontracts.HealthResponse{Status: statusOK})
+}
+
+// Readyz reports whether the IPAM-API can handle requests: the storage answers a ping, Netbox can be
+// reached and the prefix containers were fetched within readiness.cache_max_age. It responds with
+// 503 Service Unavailable and the failed checks otherwise.
+func Readyz(ginContext *gin.Context) {
+	ctx := ginContext.Request.Context()
+
+	checks := map[string]error{
+		"storage":      check(ctx, repository.GetRepository().Ping),
+		"netbox":       check(ctx, netboxservice.Ping),
+		"prefix_cache": checkPrefixCache(),
+	}
+
+	response := apicontracts.HealthResponse{Status: statusOK, Checks: make(map[string]string, len(checks))}
+	httpStatus := http.StatusOK
+	for name, err := range checks {
+		if err != nil {
+			logger.Log.Warnf("Readiness check %s failed: %v", name, err)
+			response.Checks[name] = err.Error()
+			response.Status = statusUnavailable
+			httpStatus = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[name] = statusOK
+	}
+
+	ginContext.JSON(httpStatus, response)
+}
+
+// check runs ping with a deadline of checkTimeout.
+func check(ctx context.Context, ping func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	return ping(ctx)
+}
+
+// checkPrefixCache returns an error if the prefix containers have not been fetched within
+// readiness.cache_max_age. Requests are validated against the cached containers.
+func checkPrefixCache() error {
+	fetchedAt := netboxservice.Cache.FetchedAt()
+	if fetchedAt.IsZero() {
+		return fmt.Errorf("prefix containers have not been fetched yet")
+	}
+
+	age := time.Since(fetchedAt)
+	if maxAge := viper.GetDuration("readiness.cache_max_age"); maxAge > 0 && age > maxAge {
+		return fmt.Errorf("prefix containers were last fetched %s ago", age.Round(time.Second))
+	}
+	return nil
+}
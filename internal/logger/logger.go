@@ -142,6 +142,21 @@ func InitLogger(logDir string) error {
 	return nil
 }
 
+// InitConsoleLogger sets up the app logger to write to stderr only. It is used by the CLI, which shares
+// services with the API but should not write to the API log files.
+func InitConsoleLogger() {
+	terminalEncoder := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
+		TimeKey:     "timestamp",
+		LevelKey:    "level",
+		MessageKey:  "message",
+		EncodeTime:  zapcore.ISO8601TimeEncoder,
+		EncodeLevel: zapcore.CapitalColorLevelEncoder,
+	})
+
+	baseLogger = zap.New(zapcore.NewCore(terminalEncoder, zapcore.AddSync(os.Stderr), zapcore.WarnLevel))
+	Log = baseLogger.Sugar()
+}
+
 func Sync() {
 	if baseLogger != nil {
 		_ = baseLogger.Sync()
@@ -0,0 +1,146 @@
+// Package metrics defines the Prometheus metrics of the IPAM-API. They are registered with the default
+// registry and served on /metrics.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ipam"
+
+// Results of Netbox and storage calls.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// States of the addresses in a prefix container.
+const (
+	StateFree = "free"
+	StateUsed = "used"
+)
+
+var (
+	// HTTPRequests counts the handled HTTP requests by method, route and status code.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes the duration of HTTP requests by method and route.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by method and route.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 13),
+	}, []string{"method", "route"})
+
+	// NetboxRequestDuration observes the duration of Netbox API calls by operation and result.
+	NetboxRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "netbox_request_duration_seconds",
+		Help:      "Duration of Netbox API calls by operation and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"operation", "result"})
+
+	// NetboxRequestsInFlight is the number of Netbox API calls running, bounded by
+	// netbox.max_concurrent_requests.
+	NetboxRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "netbox_requests_in_flight",
+		Help:      "Netbox API calls running.",
+	})
+
+	// NetboxQueueWait observes how long Netbox API calls waited for a free slot of the concurrency limit.
+	NetboxQueueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "netbox_queue_wait_seconds",
+		Help:      "Time Netbox API calls waited for a free slot of the concurrency limit.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	})
+
+	// StorageOperationDuration observes the duration of storage operations by backend, operation and result.
+	StorageOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Duration of storage operations by backend, operation and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"backend", "operation", "result"})
+
+	// Allocations counts the prefixes allocated in Netbox by zone and IP family.
+	Allocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "allocations_total",
+		Help:      "Prefixes allocated in Netbox by zone and IP family.",
+	}, []string{"zone", "ip_family"})
+
+	// Deallocations counts the services set to expire by zone and IP family. The prefix of an address is
+	// released by the cleanup worker once every service on it has expired.
+	Deallocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deallocations_total",
+		Help:      "Services set to expire by zone and IP family.",
+	}, []string{"zone", "ip_family"})
+
+	// CleanupDeletions counts the addresses without services deleted by the cleanup worker, by result.
+	CleanupDeletions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cleanup_deletions_total",
+		Help:      "Addresses without services deleted by the cleanup worker, by result.",
+	}, []string{"result"})
+
+	// PoolAddresses is the number of free and used addresses in each cached prefix container.
+	PoolAddresses = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_addresses",
+		Help:      "Free and used addresses per prefix container, updated when the prefix containers are cached.",
+	}, []string{"zone", "ip_family", "container", "state"})
+
+	// QuotaRejections counts the registrations rejected by the address quotas, by zone and quota.
+	QuotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_rejections_total",
+		Help:      "Registrations rejected by the address quotas, by zone and quota.",
+	}, []string{"zone", "quota"})
+
+	// RateLimitedRequests counts the HTTP requests rejected by the rate limit, by whether the caller was
+	// identified by its identity or its IP address.
+	RateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "HTTP requests rejected by the rate limit, by caller type.",
+	}, []string{"caller_type"})
+)
+
+// Result returns the result label for err.
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// ObserveNetbox records a Netbox API call that started at start.
+//
+// Parameters:
+//   - operation: The name of the call, for example "ListPrefixes".
+//   - start: The time the call started.
+//   - err: The error returned by the call.
+func ObserveNetbox(operation string, start time.Time, err error) {
+	NetboxRequestDuration.WithLabelValues(operation, Result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveStorage records a storage operation that started at start.
+//
+// Parameters:
+//   - backend: The storage backend, for example "mongodb".
+//   - operation: The name of the operation, for example "FindAddress".
+//   - start: The time the operation started.
+//   - err: The error returned by the operation.
+func ObserveStorage(backend, operation string, start time.Time, err error) {
+	StorageOperationDuration.WithLabelValues(backend, operation, Result(err)).Observe(time.Since(start).Seconds())
+}
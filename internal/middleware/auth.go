@@ -1,23 +1,31 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/auth"
 )
 
-// TokenAuth validates the request against a predefined token from auth_token.secret
-func TokenAuth() gin.HandlerFunc {
+// Authenticate identifies the caller of every request and stores its identity on the gin context:
+// by the client certificate verified in the TLS handshake, or by the token in the Authorization header.
+// Tokens shaped like a JSON Web Token are validated against the JWKS when JWT authentication is
+// configured, other tokens against the API tokens. Requests with an invalid token are rejected; requests
+// without credentials are passed on without an identity, see RequireAuth.
+func Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		expectedToken := viper.GetString("auth.token") // strings.TrimSpace(string(tokenBytes))
+		if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+			auth.SetIdentity(c, auth.IdentityFromCertificate(c.Request.TLS.VerifiedChains[0][0]))
+			c.Next()
+			return
+		}
 
 		// Get token from Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
-			c.Abort()
+			c.Next()
 			return
 		}
 
@@ -28,12 +36,38 @@ func TokenAuth() gin.HandlerFunc {
 		}
 
 		// Validate token
-		if token != expectedToken {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication token"})
+		var (
+			identity auth.Identity
+			err      error
+		)
+		if auth.JWTs != nil && auth.LooksLikeJWT(token) {
+			identity, err = auth.JWTs.Authenticate(token)
+		} else {
+			identity, err = auth.Tokens.Authenticate(token)
+		}
+		if err != nil {
+			message := "Invalid authentication token"
+			if errors.Is(err, auth.ErrMissingToken) {
+				message = "Authorization required"
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": message})
 			c.Abort()
 			return
 		}
 
+		auth.SetIdentity(c, identity)
+		c.Next()
+	}
+}
+
+// RequireAuth rejects requests whose caller was not identified by Authenticate.
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := auth.IdentityFromContext(c); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/vitistack/ipam-api/internal/auth"
 	"github.com/vitistack/ipam-api/internal/logger"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func ZapLogger() gin.HandlerFunc {
@@ -31,12 +33,19 @@ func ZapLogger() gin.HandlerFunc {
 		c.Next()
 		responseTime := time.Since(start)
 		responseTimeMs := float64(responseTime.Microseconds()) / 1000.0
+		caller, authMethod := "", ""
+		if identity, ok := auth.IdentityFromContext(c); ok {
+			caller, authMethod = identity.Name, identity.Method
+		}
 		logger.HTTP.Infow("http request",
 			"status", c.Writer.Status(),
 			"method", c.Request.Method,
 			"path", path,
 			// "query", query,
 			"ip", c.ClientIP(),
+			"caller", caller,
+			"auth_method", authMethod,
+			"trace_id", trace.SpanContextFromContext(c.Request.Context()).TraceID().String(),
 			"response_time", responseTime.String(),
 			"response_time_ms", responseTimeMs,
 			"user_agent", c.Request.UserAgent(),
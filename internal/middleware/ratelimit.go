@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/auth"
+	"github.com/vitistack/ipam-api/internal/metrics"
+	"golang.org/x/time/rate"
+)
+
+// Caller types of the rate limit metrics.
+const (
+	callerTypeIdentity = "identity"
+	callerTypeIP       = "ip"
+)
+
+// limiterSweepInterval is how often the buckets of callers that have gone quiet are dropped.
+const limiterSweepInterval = time.Minute
+
+// callerLimiters holds a token bucket per caller. Buckets that were not used for longer than it takes them
+// to refill are dropped, since a new bucket starts full.
+type callerLimiters struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	idle      time.Duration
+	buckets   map[string]*callerBucket
+	lastSweep time.Time
+}
+
+type callerBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newCallerLimiters(requestsPerSecond float64, burst int) *callerLimiters {
+	refill := time.Duration(float64(burst) / requestsPerSecond * float64(time.Second))
+	return &callerLimiters{
+		limit:     rate.Limit(requestsPerSecond),
+		burst:     burst,
+		idle:      max(refill, limiterSweepInterval),
+		buckets:   make(map[string]*callerBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of key. If the bucket is empty it returns false and how long the
+// caller should wait for the next token.
+func (l *callerLimiters) allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= limiterSweepInterval {
+		for bucketKey, bucket := range l.buckets {
+			if now.Sub(bucket.lastSeen) > l.idle {
+				delete(l.buckets, bucketKey)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &callerBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = bucket
+	}
+	bucket.lastSeen = now
+
+	if bucket.limiter.AllowN(now, 1) {
+		return true, 0
+	}
+	reservation := bucket.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	reservation.CancelAt(now)
+	return false, delay
+}
+
+// RateLimitIP limits the requests of every client IP address with a token bucket holding ratelimit.ip.burst
+// tokens and refilled with ratelimit.ip.requests_per_second tokens per second, which default to
+// ratelimit.burst and ratelimit.requests_per_second. It runs before Authenticate, so requests with invalid
+// credentials are limited too. The client IP is only taken from X-Forwarded-For when the request comes
+// from one of the trusted proxies of the engine.
+func RateLimitIP() gin.HandlerFunc {
+	requestsPerSecond := viper.GetFloat64("ratelimit.requests_per_second")
+	if viper.IsSet("ratelimit.ip.requests_per_second") {
+		requestsPerSecond = viper.GetFloat64("ratelimit.ip.requests_per_second")
+	}
+	burst := viper.GetInt("ratelimit.burst")
+	if viper.IsSet("ratelimit.ip.burst") {
+		burst = viper.GetInt("ratelimit.ip.burst")
+	}
+
+	return rateLimit(requestsPerSecond, burst, func(c *gin.Context) (string, string, bool) {
+		return c.ClientIP(), callerTypeIP, true
+	})
+}
+
+// RateLimitIdentity limits the requests of every caller identified by Authenticate with a token bucket holding
+// ratelimit.burst tokens and refilled with ratelimit.requests_per_second tokens per second, so a caller is
+// limited by its identity wherever it connects from. Requests without an identity were limited by
+// RateLimitIP already and are passed on.
+func RateLimitIdentity() gin.HandlerFunc {
+	return rateLimit(viper.GetFloat64("ratelimit.requests_per_second"), viper.GetInt("ratelimit.burst"),
+		func(c *gin.Context) (string, string, bool) {
+			identity, ok := auth.IdentityFromContext(c)
+			return identity.Name, callerTypeIdentity, ok
+		})
+}
+
+// rateLimit returns a middleware that limits the requests of the callers named by caller with a bucket each.
+// Requests caller does not name are passed on. Requests over the limit get 429 Too Many Requests with a
+// Retry-After header. Metrics scrapes and probes are not limited. A requestsPerSecond of zero disables the limit.
+func rateLimit(requestsPerSecond float64, burst int, caller func(c *gin.Context) (key string, callerType string, ok bool)) gin.HandlerFunc {
+	if requestsPerSecond <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(requestsPerSecond))
+	}
+	limiters := newCallerLimiters(requestsPerSecond, burst)
+
+	return func(c *gin.Context) {
+		switch c.Request.URL.Path {
+		case "/metrics", "/healthz", "/readyz":
+			c.Next()
+			return
+		}
+
+		key, callerType, ok := caller(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		allowed, retryAfter := limiters.allow(key)
+		if !allowed {
+			metrics.RateLimitedRequests.WithLabelValues(callerType).Inc()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
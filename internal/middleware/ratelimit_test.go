@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/auth"
+)
+
+// setRateLimit configures the rate limit for the test and restores the previous settings when it ends.
+func setRateLimit(t *testing.T, settings map[string]any) {
+	t.Helper()
+	for key, value := range settings {
+		previous, wasSet := viper.Get(key), viper.IsSet(key)
+		t.Cleanup(func() {
+			if wasSet {
+				viper.Set(key, previous)
+			} else {
+				viper.Set(key, nil)
+			}
+		})
+		viper.Set(key, value)
+	}
+}
+
+// serve sends a GET request for path from remoteAddr, with the identity name if it is not empty.
+func serve(engine *gin.Engine, path, remoteAddr, name string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.RemoteAddr = remoteAddr
+	if name != "" {
+		request.Header.Set("X-Test-Identity", name)
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// newRateLimitedEngine returns an engine that limits by IP, identifies callers by the X-Test-Identity
+// header and then limits by identity.
+func newRateLimitedEngine(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	if err := engine.SetTrustedProxies(nil); err != nil {
+		t.Fatalf("failed to set trusted proxies: %v", err)
+	}
+	engine.Use(RateLimitIP())
+	engine.Use(func(c *gin.Context) {
+		if name := c.GetHeader("X-Test-Identity"); name != "" {
+			auth.SetIdentity(c, auth.Identity{Name: name, Method: auth.MethodToken})
+		}
+		c.Next()
+	})
+	engine.Use(RateLimitIdentity())
+	for _, path := range []string{"/v2/address", "/healthz"} {
+		engine.GET(path, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+	return engine
+}
+
+func TestRateLimitIPRejectsWithRetryAfter(t *testing.T) {
+	setRateLimit(t, map[string]any{"ratelimit.requests_per_second": 1, "ratelimit.burst": 2})
+	engine := newRateLimitedEngine(t)
+
+	for i := range 2 {
+		if recorder := serve(engine, "/v2/address", "192.0.2.1:1234", ""); recorder.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200 within the burst, got %d", i, recorder.Code)
+		}
+	}
+
+	recorder := serve(engine, "/v2/address", "192.0.2.1:1234", "")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 over the burst, got %d", recorder.Code)
+	}
+	retryAfter, err := strconv.Atoi(recorder.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 {
+		t.Errorf("expected a Retry-After of at least one second, got %q", recorder.Header().Get("Retry-After"))
+	}
+
+	if recorder := serve(engine, "/v2/address", "192.0.2.2:1234", ""); recorder.Code != http.StatusOK {
+		t.Errorf("expected another IP address to have its own bucket, got %d", recorder.Code)
+	}
+	if recorder := serve(engine, "/healthz", "192.0.2.1:1234", ""); recorder.Code != http.StatusOK {
+		t.Errorf("expected probes not to be limited, got %d", recorder.Code)
+	}
+}
+
+func TestRateLimitIdentityAcrossIPAddresses(t *testing.T) {
+	setRateLimit(t, map[string]any{
+		"ratelimit.requests_per_second":    1,
+		"ratelimit.burst":                  2,
+		"ratelimit.ip.requests_per_second": 100,
+		"ratelimit.ip.burst":               100,
+	})
+	engine := newRateLimitedEngine(t)
+
+	// The identity is limited wherever it connects from
+	for i, remoteAddr := range []string{"192.0.2.1:1234", "192.0.2.2:1234"} {
+		if recorder := serve(engine, "/v2/address", remoteAddr, "controller"); recorder.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200 within the burst, got %d", i, recorder.Code)
+		}
+	}
+	recorder := serve(engine, "/v2/address", "192.0.2.3:1234", "controller")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429 over the burst of the identity, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	if recorder := serve(engine, "/v2/address", "192.0.2.3:1234", "other"); recorder.Code != http.StatusOK {
+		t.Errorf("expected another identity to have its own bucket, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,572 @@
+package repository
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	addressesBucket     = []byte("addresses")
+	addressIndexBucket  = []byte("address_index")
+	compensationsBucket = []byte("compensations")
+	migrationsBucket    = []byte("migrations")
+	leasesBucket        = []byte("leases")
+)
+
+// BoltRepository stores addresses and compensations in an embedded bbolt database file, for sites that
+// run the IPAM-API without a database server. Documents are stored BSON encoded under their object ID,
+// so keys are ordered like the IDs in MongoDB. Queries scan the bucket, which is fast enough for the
+// number of addresses of a single site. The address_index bucket maps the zone and address of every
+// document to its ID, so that storing an address once per zone does not scan the bucket.
+type BoltRepository struct {
+	db *bolt.DB
+}
+
+// NewBoltRepository opens the bbolt database at path, creating it if it does not exist.
+//
+// Parameters:
+//   - path: The path of the database file.
+//
+// Returns:
+//   - *BoltRepository: The opened repository.
+//   - error: An error if the file cannot be opened, for example because another process holds its lock.
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bbolt database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{addressesBucket, addressIndexBucket, compensationsBucket, migrationsBucket, leasesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create bbolt buckets: %w", err)
+	}
+
+	return &BoltRepository{db: db}, nil
+}
+
+func (r *BoltRepository) InsertAddress(_ context.Context, address mongodbtypes.Address) (bson.ObjectID, error) {
+	if address.ID.IsZero() {
+		address.ID = bson.NewObjectID()
+	}
+	if address.Services == nil {
+		address.Services = []mongodbtypes.Service{}
+	}
+
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(addressIndexBucket)
+		key := addressIndexKey(address)
+		if index.Get(key) != nil {
+			return fmt.Errorf("%w: %s in zone %s", ErrDuplicateAddress, address.Address, address.Zone)
+		}
+
+		if err := index.Put(key, slices.Clone(address.ID[:])); err != nil {
+			return err
+		}
+		return put(tx.Bucket(addressesBucket), address.ID, address)
+	})
+	if err != nil {
+		return bson.ObjectID{}, err
+	}
+	return address.ID, nil
+}
+
+func (r *BoltRepository) FindAddress(ctx context.Context, query AddressQuery) (mongodbtypes.Address, error) {
+	query.Limit = 1
+	addresses, err := r.FindAddresses(ctx, query)
+	if err != nil {
+		return mongodbtypes.Address{}, err
+	}
+	if len(addresses) == 0 {
+		return mongodbtypes.Address{}, ErrNotFound
+	}
+	return addresses[0], nil
+}
+
+func (r *BoltRepository) FindAddresses(_ context.Context, query AddressQuery) ([]mongodbtypes.Address, error) {
+	addresses := []mongodbtypes.Address{}
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(addressesBucket).Cursor()
+
+		key, value := cursor.First()
+		if !query.After.IsZero() {
+			key, value = cursor.Seek(query.After[:])
+			if key != nil && bson.ObjectID(key) == query.After {
+				key, value = cursor.Next()
+			}
+		}
+
+		for ; key != nil; key, value = cursor.Next() {
+			var address mongodbtypes.Address
+			if err := bson.Unmarshal(value, &address); err != nil {
+				return fmt.Errorf("failed to decode address: %w", err)
+			}
+
+			if !query.matches(address) {
+				continue
+			}
+
+			addresses = append(addresses, address)
+			if query.Limit > 0 && len(addresses) == query.Limit {
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return addresses, err
+}
+
+func (r *BoltRepository) CountAddresses(_ context.Context, query AddressQuery) (int, error) {
+	count := 0
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(addressesBucket).Cursor()
+		for key, value := cursor.Seek(query.After[:]); key != nil; key, value = cursor.Next() {
+			if bson.ObjectID(key) == query.After {
+				continue
+			}
+
+			var address mongodbtypes.Address
+			if err := bson.Unmarshal(value, &address); err != nil {
+				return fmt.Errorf("failed to decode address: %w", err)
+			}
+			if query.matches(address) {
+				count++
+				if count == query.Limit {
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+func (r *BoltRepository) UpdateAddress(_ context.Context, id bson.ObjectID, update AddressUpdate) error {
+	_, err := r.modifyAddress(id, func(address *mongodbtypes.Address) bool {
+		*address = update.apply(*address)
+		return true
+	})
+	return err
+}
+
+func (r *BoltRepository) PutService(_ context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	_, err := r.modifyAddress(id, func(address *mongodbtypes.Address) bool {
+		address.Services = putService(address.Services, service)
+		return true
+	})
+	return err
+}
+
+func (r *BoltRepository) UpdateService(_ context.Context, id bson.ObjectID, service mongodbtypes.Service) (bool, error) {
+	return r.modifyAddress(id, func(address *mongodbtypes.Address) bool {
+		var replaced bool
+		address.Services, replaced = replaceService(address.Services, service)
+		return replaced
+	})
+}
+
+func (r *BoltRepository) RemoveService(_ context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	_, err := r.modifyAddress(id, func(address *mongodbtypes.Address) bool {
+		services := removeService(address.Services, service)
+		removed := len(services) != len(address.Services)
+		address.Services = services
+		return removed
+	})
+	return err
+}
+
+func (r *BoltRepository) ChangeSecret(_ context.Context, id bson.ObjectID, from string, to AddressUpdate, service mongodbtypes.Service) (bool, error) {
+	return r.modifyAddress(id, func(address *mongodbtypes.Address) bool {
+		if !canChangeSecret(*address, from, service) {
+			return false
+		}
+		*address = to.apply(*address)
+		address.Services = []mongodbtypes.Service{service}
+		return true
+	})
+}
+
+// modifyAddress reads, modifies and writes the address with the given ID in one transaction, so the change
+// is atomic. The address is only written if modify returns true. It reports whether the address was written.
+func (r *BoltRepository) modifyAddress(id bson.ObjectID, modify func(address *mongodbtypes.Address) bool) (bool, error) {
+	modified := false
+
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(addressesBucket)
+
+		var address mongodbtypes.Address
+		found, err := get(bucket, id, &address)
+		if err != nil || !found || !modify(&address) {
+			return err
+		}
+
+		modified = true
+		return put(bucket, id, address)
+	})
+
+	return modified, err
+}
+
+func (r *BoltRepository) DeleteAddress(_ context.Context, id bson.ObjectID) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(addressesBucket)
+
+		var address mongodbtypes.Address
+		found, err := get(bucket, id, &address)
+		if err != nil || !found {
+			return err
+		}
+
+		if err := tx.Bucket(addressIndexBucket).Delete(addressIndexKey(address)); err != nil {
+			return err
+		}
+		return bucket.Delete(id[:])
+	})
+}
+
+func (r *BoltRepository) RemoveExpiredServices(_ context.Context, now time.Time) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(addressesBucket)
+
+		var expired []mongodbtypes.Address
+		err := bucket.ForEach(func(_, value []byte) error {
+			var address mongodbtypes.Address
+			if err := bson.Unmarshal(value, &address); err != nil {
+				return fmt.Errorf("failed to decode address: %w", err)
+			}
+			if services, removed := withoutExpiredServices(address.Services, now); removed {
+				address.Services = services
+				expired = append(expired, address)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Buckets must not be modified while iterating over them with ForEach
+		for _, address := range expired {
+			if err := put(bucket, address.ID, address); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *BoltRepository) InsertCompensation(ctx context.Context, compensation mongodbtypes.Compensation) error {
+	return r.SaveCompensation(ctx, compensation)
+}
+
+func (r *BoltRepository) SaveCompensation(_ context.Context, compensation mongodbtypes.Compensation) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(compensationsBucket), compensation.ID, compensation)
+	})
+}
+
+func (r *BoltRepository) DeleteCompensation(_ context.Context, id bson.ObjectID) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(compensationsBucket).Delete(id[:])
+	})
+}
+
+func (r *BoltRepository) DeleteSagaCompensations(_ context.Context, sagaID bson.ObjectID) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(compensationsBucket)
+
+		var keys [][]byte
+		err := bucket.ForEach(func(key, value []byte) error {
+			var compensation mongodbtypes.Compensation
+			if err := bson.Unmarshal(value, &compensation); err != nil {
+				return fmt.Errorf("failed to decode compensation: %w", err)
+			}
+			if compensation.SagaID == sagaID {
+				keys = append(keys, slices.Clone(key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *BoltRepository) FindCompensations(_ context.Context, status string) ([]mongodbtypes.Compensation, error) {
+	var compensations []mongodbtypes.Compensation
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(compensationsBucket).ForEach(func(_, value []byte) error {
+			var compensation mongodbtypes.Compensation
+			if err := bson.Unmarshal(value, &compensation); err != nil {
+				return fmt.Errorf("failed to decode compensation: %w", err)
+			}
+			if status == "" || compensation.Status == status {
+				compensations = append(compensations, compensation)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortCompensations(compensations)
+	return compensations, nil
+}
+
+func (r *BoltRepository) UpdateCompensationStatus(_ context.Context, from, to string, createdBefore time.Time) (int, error) {
+	updated := 0
+
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(compensationsBucket)
+
+		var changed []mongodbtypes.Compensation
+		err := bucket.ForEach(func(_, value []byte) error {
+			var compensation mongodbtypes.Compensation
+			if err := bson.Unmarshal(value, &compensation); err != nil {
+				return fmt.Errorf("failed to decode compensation: %w", err)
+			}
+			if compensation.Status == from && !compensation.CreatedAt.After(createdBefore) {
+				compensation.Status = to
+				changed = append(changed, compensation)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, compensation := range changed {
+			if err := put(bucket, compensation.ID, compensation); err != nil {
+				return err
+			}
+		}
+		updated = len(changed)
+		return nil
+	})
+
+	return updated, err
+}
+
+func (r *BoltRepository) AcquireLease(_ context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	acquired := false
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(leasesBucket)
+
+		now := time.Now()
+		if value := bucket.Get([]byte(name)); value != nil {
+			var existing lease
+			if err := bson.Unmarshal(value, &existing); err != nil {
+				return fmt.Errorf("failed to decode lease: %w", err)
+			}
+			if !existing.available(holder, now) {
+				return nil
+			}
+		}
+
+		encoded, err := bson.Marshal(lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)})
+		if err != nil {
+			return fmt.Errorf("failed to encode lease: %w", err)
+		}
+		acquired = true
+		return bucket.Put([]byte(name), encoded)
+	})
+	if err != nil {
+		return false, err
+	}
+	return acquired, nil
+}
+
+func (r *BoltRepository) ReleaseLease(_ context.Context, name, holder string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(leasesBucket)
+
+		value := bucket.Get([]byte(name))
+		if value == nil {
+			return nil
+		}
+		var existing lease
+		if err := bson.Unmarshal(value, &existing); err != nil {
+			return fmt.Errorf("failed to decode lease: %w", err)
+		}
+		if existing.Holder != holder {
+			return nil
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+func (r *BoltRepository) Migrate(ctx context.Context) ([]Migration, error) {
+	applied := map[int]bool{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(migrationsBucket).ForEach(func(_, value []byte) error {
+			var record migrationRecord
+			if err := bson.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			applied[record.Version] = true
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	return runMigrations(ctx, r.migrationList(), applied, func(_ context.Context, record migrationRecord) error {
+		return r.db.Update(func(tx *bolt.Tx) error {
+			value, err := bson.Marshal(record)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket(migrationsBucket).Put(binary.BigEndian.AppendUint32(nil, uint32(record.Version)), value)
+		})
+	})
+}
+
+// migrationList returns the migrations of the bbolt backend. Never change a released migration, add a
+// new one instead.
+func (r *BoltRepository) migrationList() []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "set schema_version on addresses",
+			Up: func(_ context.Context) error {
+				return r.db.Update(func(tx *bolt.Tx) error {
+					bucket := tx.Bucket(addressesBucket)
+
+					var updated []mongodbtypes.Address
+					err := bucket.ForEach(func(_, value []byte) error {
+						var address mongodbtypes.Address
+						if err := bson.Unmarshal(value, &address); err != nil {
+							return err
+						}
+						if address.SchemaVersion == 0 {
+							address.SchemaVersion = 1
+							updated = append(updated, address)
+						}
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+
+					// Buckets must not be changed while iterating over them
+					for _, address := range updated {
+						if err := put(bucket, address.ID, address); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+		},
+		{
+			Version:     2,
+			Description: "index addresses by zone and address",
+			Up: func(_ context.Context) error {
+				return r.db.Update(func(tx *bolt.Tx) error {
+					ids := make(map[string][]bson.ObjectID)
+					var keys []string
+					err := tx.Bucket(addressesBucket).ForEach(func(_, value []byte) error {
+						var address mongodbtypes.Address
+						if err := bson.Unmarshal(value, &address); err != nil {
+							return err
+						}
+						key := string(addressIndexKey(address))
+						if _, ok := ids[key]; !ok {
+							keys = append(keys, key)
+						}
+						ids[key] = append(ids[key], address.ID)
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+
+					var descriptions []string
+					for _, key := range keys {
+						if len(ids[key]) > 1 {
+							hexes := make([]string, 0, len(ids[key]))
+							for _, id := range ids[key] {
+								hexes = append(hexes, id.Hex())
+							}
+							zone, address, _ := strings.Cut(key, "|")
+							descriptions = append(descriptions, describeDuplicateAddress(zone, address, hexes))
+						}
+					}
+					if err := duplicateAddressesError(descriptions); err != nil {
+						return err
+					}
+
+					index := tx.Bucket(addressIndexBucket)
+					for _, key := range keys {
+						if err := index.Put([]byte(key), slices.Clone(ids[key][0][:])); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+		},
+	}
+}
+
+func (r *BoltRepository) Ping(_ context.Context) error {
+	return r.db.View(func(*bolt.Tx) error { return nil })
+}
+
+func (r *BoltRepository) Close(_ context.Context) error {
+	return r.db.Close()
+}
+
+// addressIndexKey returns the key of address in the address_index bucket.
+func addressIndexKey(address mongodbtypes.Address) []byte {
+	return []byte(address.Zone + "|" + address.Address)
+}
+
+// put stores the BSON encoding of document under id.
+func put(bucket *bolt.Bucket, id bson.ObjectID, document any) error {
+	encoded, err := bson.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	return bucket.Put(slices.Clone(id[:]), encoded)
+}
+
+// get decodes the document stored under id into document and reports whether it was found.
+func get(bucket *bolt.Bucket, id bson.ObjectID, document any) (bool, error) {
+	value := bucket.Get(id[:])
+	if value == nil {
+		return false, nil
+	}
+	if err := bson.Unmarshal(value, document); err != nil {
+		return false, fmt.Errorf("failed to decode document: %w", err)
+	}
+	return true, nil
+}
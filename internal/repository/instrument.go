@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/metrics"
+	"github.com/vitistack/ipam-api/internal/tracing"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// instrumentedRepository records every operation of a Repository in the storage metrics and as a span.
+type instrumentedRepository struct {
+	next    Repository
+	backend string
+}
+
+// instrument returns r wrapped so that its operations are recorded in the storage metrics and traced.
+// A repository that is already instrumented is returned as is.
+func instrument(r Repository) Repository {
+	if r == nil {
+		return nil
+	}
+	if _, ok := r.(instrumentedRepository); ok {
+		return r
+	}
+	return instrumentedRepository{next: r, backend: backendOf(r)}
+}
+
+// backendOf returns the storage backend label of r. Repositories that are not one of the backends of the
+// package, such as test doubles, are labelled "other".
+func backendOf(r Repository) string {
+	switch r.(type) {
+	case *MongoRepository:
+		return BackendMongoDB
+	case *PostgresRepository:
+		return BackendPostgres
+	case *BoltRepository:
+		return BackendBolt
+	case *MemoryRepository:
+		return BackendMemory
+	default:
+		return "other"
+	}
+}
+
+// start starts recording an operation. The returned function ends the recording with the error of the
+// operation. An address that is not found is an answer, not a failure of the operation.
+func (r instrumentedRepository) start(ctx context.Context, operation string) (context.Context, func(error)) {
+	started := time.Now()
+	ctx, span := tracing.StartClient(ctx, "storage "+operation,
+		attribute.String("db.system.name", r.backend), attribute.String("db.operation.name", operation))
+
+	return ctx, func(err error) {
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		metrics.ObserveStorage(r.backend, operation, started, err)
+		tracing.End(span, err)
+	}
+}
+
+func (r instrumentedRepository) InsertAddress(ctx context.Context, address mongodbtypes.Address) (bson.ObjectID, error) {
+	ctx, end := r.start(ctx, "InsertAddress")
+	id, err := r.next.InsertAddress(ctx, address)
+	end(err)
+	return id, err
+}
+
+func (r instrumentedRepository) FindAddress(ctx context.Context, query AddressQuery) (mongodbtypes.Address, error) {
+	ctx, end := r.start(ctx, "FindAddress")
+	address, err := r.next.FindAddress(ctx, query)
+	end(err)
+	return address, err
+}
+
+func (r instrumentedRepository) FindAddresses(ctx context.Context, query AddressQuery) ([]mongodbtypes.Address, error) {
+	ctx, end := r.start(ctx, "FindAddresses")
+	addresses, err := r.next.FindAddresses(ctx, query)
+	end(err)
+	return addresses, err
+}
+
+func (r instrumentedRepository) CountAddresses(ctx context.Context, query AddressQuery) (int, error) {
+	ctx, end := r.start(ctx, "CountAddresses")
+	count, err := r.next.CountAddresses(ctx, query)
+	end(err)
+	return count, err
+}
+
+func (r instrumentedRepository) UpdateAddress(ctx context.Context, id bson.ObjectID, update AddressUpdate) error {
+	ctx, end := r.start(ctx, "UpdateAddress")
+	err := r.next.UpdateAddress(ctx, id, update)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) PutService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	ctx, end := r.start(ctx, "PutService")
+	err := r.next.PutService(ctx, id, service)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) RemoveService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	ctx, end := r.start(ctx, "RemoveService")
+	err := r.next.RemoveService(ctx, id, service)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) UpdateService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) (bool, error) {
+	ctx, end := r.start(ctx, "UpdateService")
+	updated, err := r.next.UpdateService(ctx, id, service)
+	end(err)
+	return updated, err
+}
+
+func (r instrumentedRepository) ChangeSecret(ctx context.Context, id bson.ObjectID, from string, to AddressUpdate, service mongodbtypes.Service) (bool, error) {
+	ctx, end := r.start(ctx, "ChangeSecret")
+	changed, err := r.next.ChangeSecret(ctx, id, from, to, service)
+	end(err)
+	return changed, err
+}
+
+func (r instrumentedRepository) DeleteAddress(ctx context.Context, id bson.ObjectID) error {
+	ctx, end := r.start(ctx, "DeleteAddress")
+	err := r.next.DeleteAddress(ctx, id)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) RemoveExpiredServices(ctx context.Context, now time.Time) error {
+	ctx, end := r.start(ctx, "RemoveExpiredServices")
+	err := r.next.RemoveExpiredServices(ctx, now)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) InsertCompensation(ctx context.Context, compensation mongodbtypes.Compensation) error {
+	ctx, end := r.start(ctx, "InsertCompensation")
+	err := r.next.InsertCompensation(ctx, compensation)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) SaveCompensation(ctx context.Context, compensation mongodbtypes.Compensation) error {
+	ctx, end := r.start(ctx, "SaveCompensation")
+	err := r.next.SaveCompensation(ctx, compensation)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) DeleteCompensation(ctx context.Context, id bson.ObjectID) error {
+	ctx, end := r.start(ctx, "DeleteCompensation")
+	err := r.next.DeleteCompensation(ctx, id)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) DeleteSagaCompensations(ctx context.Context, sagaID bson.ObjectID) error {
+	ctx, end := r.start(ctx, "DeleteSagaCompensations")
+	err := r.next.DeleteSagaCompensations(ctx, sagaID)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) FindCompensations(ctx context.Context, status string) ([]mongodbtypes.Compensation, error) {
+	ctx, end := r.start(ctx, "FindCompensations")
+	compensations, err := r.next.FindCompensations(ctx, status)
+	end(err)
+	return compensations, err
+}
+
+func (r instrumentedRepository) UpdateCompensationStatus(ctx context.Context, from, to string, createdBefore time.Time) (int, error) {
+	ctx, end := r.start(ctx, "UpdateCompensationStatus")
+	changed, err := r.next.UpdateCompensationStatus(ctx, from, to, createdBefore)
+	end(err)
+	return changed, err
+}
+
+func (r instrumentedRepository) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	ctx, end := r.start(ctx, "AcquireLease")
+	acquired, err := r.next.AcquireLease(ctx, name, holder, ttl)
+	end(err)
+	return acquired, err
+}
+
+func (r instrumentedRepository) ReleaseLease(ctx context.Context, name, holder string) error {
+	ctx, end := r.start(ctx, "ReleaseLease")
+	err := r.next.ReleaseLease(ctx, name, holder)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) Migrate(ctx context.Context) ([]Migration, error) {
+	ctx, end := r.start(ctx, "Migrate")
+	migrations, err := r.next.Migrate(ctx)
+	end(err)
+	return migrations, err
+}
+
+func (r instrumentedRepository) Ping(ctx context.Context) error {
+	ctx, end := r.start(ctx, "Ping")
+	err := r.next.Ping(ctx)
+	end(err)
+	return err
+}
+
+func (r instrumentedRepository) Close(ctx context.Context) error {
+	return r.next.Close(ctx)
+}
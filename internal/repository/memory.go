@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// MemoryRepository keeps addresses and compensations in memory. It is meant for tests and for trying out
+// the API, since nothing is persisted.
+type MemoryRepository struct {
+	mu            sync.Mutex
+	addresses     map[bson.ObjectID]mongodbtypes.Address
+	compensations map[bson.ObjectID]mongodbtypes.Compensation
+	leases        map[string]lease
+}
+
+// NewMemoryRepository returns an empty MemoryRepository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		addresses:     make(map[bson.ObjectID]mongodbtypes.Address),
+		compensations: make(map[bson.ObjectID]mongodbtypes.Compensation),
+		leases:        make(map[string]lease),
+	}
+}
+
+func (r *MemoryRepository) InsertAddress(_ context.Context, address mongodbtypes.Address) (bson.ObjectID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.addresses {
+		if existing.Zone == address.Zone && existing.Address == address.Address {
+			return bson.ObjectID{}, fmt.Errorf("%w: %s in zone %s", ErrDuplicateAddress, address.Address, address.Zone)
+		}
+	}
+
+	if address.ID.IsZero() {
+		address.ID = bson.NewObjectID()
+	}
+	address.Services = slices.Clone(address.Services)
+	r.addresses[address.ID] = address
+	return address.ID, nil
+}
+
+func (r *MemoryRepository) FindAddress(ctx context.Context, query AddressQuery) (mongodbtypes.Address, error) {
+	query.Limit = 1
+	addresses, err := r.FindAddresses(ctx, query)
+	if err != nil {
+		return mongodbtypes.Address{}, err
+	}
+	if len(addresses) == 0 {
+		return mongodbtypes.Address{}, ErrNotFound
+	}
+	return addresses[0], nil
+}
+
+func (r *MemoryRepository) FindAddresses(_ context.Context, query AddressQuery) ([]mongodbtypes.Address, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	addresses := []mongodbtypes.Address{}
+	for _, address := range r.addresses {
+		if bytes.Compare(address.ID[:], query.After[:]) > 0 && query.matches(address) {
+			address.Services = slices.Clone(address.Services)
+			addresses = append(addresses, address)
+		}
+	}
+
+	slices.SortFunc(addresses, func(a, b mongodbtypes.Address) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+	if query.Limit > 0 && len(addresses) > query.Limit {
+		addresses = addresses[:query.Limit]
+	}
+	return addresses, nil
+}
+
+func (r *MemoryRepository) CountAddresses(_ context.Context, query AddressQuery) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, address := range r.addresses {
+		if bytes.Compare(address.ID[:], query.After[:]) > 0 && query.matches(address) {
+			count++
+		}
+	}
+	if query.Limit > 0 {
+		count = min(count, query.Limit)
+	}
+	return count, nil
+}
+
+func (r *MemoryRepository) UpdateAddress(_ context.Context, id bson.ObjectID, update AddressUpdate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	address, ok := r.addresses[id]
+	if !ok {
+		return nil
+	}
+	r.addresses[id] = update.apply(address)
+	return nil
+}
+
+func (r *MemoryRepository) PutService(_ context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	address, ok := r.addresses[id]
+	if !ok {
+		return nil
+	}
+	address.Services = putService(address.Services, service)
+	r.addresses[id] = address
+	return nil
+}
+
+func (r *MemoryRepository) UpdateService(_ context.Context, id bson.ObjectID, service mongodbtypes.Service) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	address, ok := r.addresses[id]
+	if !ok {
+		return false, nil
+	}
+	address.Services, ok = replaceService(address.Services, service)
+	r.addresses[id] = address
+	return ok, nil
+}
+
+func (r *MemoryRepository) RemoveService(_ context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	address, ok := r.addresses[id]
+	if !ok {
+		return nil
+	}
+	address.Services = removeService(address.Services, service)
+	r.addresses[id] = address
+	return nil
+}
+
+func (r *MemoryRepository) ChangeSecret(_ context.Context, id bson.ObjectID, from string, to AddressUpdate, service mongodbtypes.Service) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	address, ok := r.addresses[id]
+	if !ok || !canChangeSecret(address, from, service) {
+		return false, nil
+	}
+	address = to.apply(address)
+	address.Services = []mongodbtypes.Service{service}
+	r.addresses[id] = address
+	return true, nil
+}
+
+func (r *MemoryRepository) DeleteAddress(_ context.Context, id bson.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.addresses, id)
+	return nil
+}
+
+func (r *MemoryRepository) RemoveExpiredServices(_ context.Context, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, address := range r.addresses {
+		if services, removed := withoutExpiredServices(address.Services, now); removed {
+			address.Services = services
+			r.addresses[id] = address
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) InsertCompensation(ctx context.Context, compensation mongodbtypes.Compensation) error {
+	return r.SaveCompensation(ctx, compensation)
+}
+
+func (r *MemoryRepository) SaveCompensation(_ context.Context, compensation mongodbtypes.Compensation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.compensations[compensation.ID] = cloneCompensation(compensation)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteCompensation(_ context.Context, id bson.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.compensations, id)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteSagaCompensations(_ context.Context, sagaID bson.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, compensation := range r.compensations {
+		if compensation.SagaID == sagaID {
+			delete(r.compensations, id)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) FindCompensations(_ context.Context, status string) ([]mongodbtypes.Compensation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var compensations []mongodbtypes.Compensation
+	for _, compensation := range r.compensations {
+		if status == "" || compensation.Status == status {
+			compensations = append(compensations, cloneCompensation(compensation))
+		}
+	}
+
+	sortCompensations(compensations)
+	return compensations, nil
+}
+
+func (r *MemoryRepository) UpdateCompensationStatus(_ context.Context, from, to string, createdBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	updated := 0
+	for id, compensation := range r.compensations {
+		if compensation.Status == from && !compensation.CreatedAt.After(createdBefore) {
+			compensation.Status = to
+			r.compensations[id] = compensation
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// AcquireLease takes the lease for holder unless another holder has it and it has not expired. Leases are
+// only shared by the requests of this instance, since the memory backend is not shared between instances.
+func (r *MemoryRepository) AcquireLease(_ context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if existing, ok := r.leases[name]; ok && !existing.available(holder, now) {
+		return false, nil
+	}
+	r.leases[name] = lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (r *MemoryRepository) ReleaseLease(_ context.Context, name, holder string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.leases[name]; ok && existing.Holder == holder {
+		delete(r.leases, name)
+	}
+	return nil
+}
+
+// Migrate does nothing, since the memory backend starts empty and has no indexes.
+func (r *MemoryRepository) Migrate(_ context.Context) ([]Migration, error) {
+	return nil, nil
+}
+
+func (r *MemoryRepository) Ping(_ context.Context) error {
+	return nil
+}
+
+func (r *MemoryRepository) Close(_ context.Context) error {
+	return nil
+}
+
+// cloneCompensation returns a copy of compensation that shares no memory with it, so stored compensations
+// cannot be changed through the copies handed out.
+func cloneCompensation(compensation mongodbtypes.Compensation) mongodbtypes.Compensation {
+	if compensation.Service != nil {
+		service := *compensation.Service
+		compensation.Service = &service
+	}
+	return compensation
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Migration is a versioned change of the stored data, such as creating indexes or backfilling a field.
+// Every backend has its own list of migrations. Applied migrations are recorded by the backend, so each
+// migration runs once. Migrations must be safe to run again, since two instances starting at the same time
+// may both run a migration before either has recorded it.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+}
+
+// migrationRecord is how a backend records an applied migration.
+type migrationRecord struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+// runMigrations applies the migrations whose version is not in applied, in order of version, and records
+// each one after it succeeded. It stops at the first migration that fails.
+//
+// Parameters:
+//   - ctx: Context of the migrations.
+//   - migrations: The migrations of the backend.
+//   - applied: The versions of the migrations that were applied before.
+//   - record: Records an applied migration.
+//
+// Returns:
+//   - []Migration: The migrations applied by this call.
+//   - error: An error if a migration or recording it fails.
+func runMigrations(ctx context.Context, migrations []Migration, applied map[int]bool,
+	record func(ctx context.Context, record migrationRecord) error) ([]Migration, error) {
+
+	migrations = slices.Clone(migrations)
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+
+	var ran []Migration
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		if err := migration.Up(ctx); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+		}
+
+		err := record(ctx, migrationRecord{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			return ran, fmt.Errorf("failed to record migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+
+		ran = append(ran, migration)
+	}
+
+	return ran, nil
+}
@@ -0,0 +1,500 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+// MongoRepository stores addresses and compensations in two MongoDB collections. Applied migrations and
+// leases are recorded in collections of their own.
+type MongoRepository struct {
+	addresses     *mongo.Collection
+	compensations *mongo.Collection
+	migrations    *mongo.Collection
+	leases        *mongo.Collection
+}
+
+// NewMongoRepository returns a MongoRepository for the given collections of database.
+func NewMongoRepository(database *mongo.Database, addressCollection, compensationCollection, migrationCollection, leaseCollection string) *MongoRepository {
+	return &MongoRepository{
+		addresses:     database.Collection(addressCollection),
+		compensations: database.Collection(compensationCollection),
+		migrations:    database.Collection(migrationCollection),
+		leases:        database.Collection(leaseCollection),
+	}
+}
+
+func (r *MongoRepository) InsertAddress(ctx context.Context, address mongodbtypes.Address) (bson.ObjectID, error) {
+	if address.ID.IsZero() {
+		address.ID = bson.NewObjectID()
+	}
+	if address.Services == nil {
+		address.Services = []mongodbtypes.Service{}
+	}
+
+	if _, err := r.addresses.InsertOne(ctx, address); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return bson.ObjectID{}, fmt.Errorf("%w: %s in zone %s", ErrDuplicateAddress, address.Address, address.Zone)
+		}
+		return bson.ObjectID{}, err
+	}
+	return address.ID, nil
+}
+
+func (r *MongoRepository) FindAddress(ctx context.Context, query AddressQuery) (mongodbtypes.Address, error) {
+	var address mongodbtypes.Address
+	err := r.addresses.FindOne(ctx, addressFilter(query), options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}})).Decode(&address)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return mongodbtypes.Address{}, ErrNotFound
+	}
+	if err != nil {
+		return mongodbtypes.Address{}, err
+	}
+	return address, nil
+}
+
+func (r *MongoRepository) FindAddresses(ctx context.Context, query AddressQuery) ([]mongodbtypes.Address, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if query.Limit > 0 {
+		findOptions.SetLimit(int64(query.Limit))
+	}
+
+	cursor, err := r.addresses.Find(ctx, addressFilter(query), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	addresses := []mongodbtypes.Address{}
+	if err := cursor.All(ctx, &addresses); err != nil {
+		return nil, fmt.Errorf("failed to decode addresses: %w", err)
+	}
+	return addresses, nil
+}
+
+func (r *MongoRepository) CountAddresses(ctx context.Context, query AddressQuery) (int, error) {
+	countOptions := options.Count()
+	if query.Limit > 0 {
+		countOptions.SetLimit(int64(query.Limit))
+	}
+
+	count, err := r.addresses.CountDocuments(ctx, addressFilter(query), countOptions)
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (r *MongoRepository) UpdateAddress(ctx context.Context, id bson.ObjectID, update AddressUpdate) error {
+	set := addressSet(update)
+	if len(set) == 0 {
+		return nil
+	}
+
+	_, err := r.addresses.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+// addressSet translates an AddressUpdate to the fields of a $set.
+func addressSet(update AddressUpdate) bson.M {
+	set := bson.M{}
+	if update.Secret != "" {
+		set["secret"] = update.Secret
+	}
+	if update.SecretHash != "" {
+		set["secret_hash"] = update.SecretHash
+	}
+	if update.SecretKeyID != "" {
+		set["secret_key_id"] = update.SecretKeyID
+	}
+	if update.SchemaVersion != 0 {
+		set["schema_version"] = update.SchemaVersion
+	}
+	if update.NetboxID != 0 {
+		set["netbox_id"] = update.NetboxID
+	}
+	if update.Services != nil {
+		set["services"] = update.Services
+	}
+	return set
+}
+
+func (r *MongoRepository) PutService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	// The pipeline removes the same service and appends the new one in a single atomic update
+	sameService := bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{"$$service.service_name", service.ServiceName}},
+		bson.M{"$eq": bson.A{"$$service.namespace_id", service.NamespaceID}},
+		bson.M{"$eq": bson.A{"$$service.cluster_id", service.ClusterID}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"services": bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$services", bson.A{}}},
+				"as":    "service",
+				"cond":  bson.M{"$not": bson.A{sameService}},
+			}},
+			bson.A{bson.M{"$literal": service}},
+		}},
+	}}}}
+
+	_, err := r.addresses.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (r *MongoRepository) UpdateService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) (bool, error) {
+	filter := bson.M{
+		"_id":      id,
+		"services": bson.M{"$elemMatch": serviceKey(service, "")},
+	}
+	updateOptions := options.UpdateOne().SetArrayFilters([]any{serviceKey(service, "service.")})
+
+	result, err := r.addresses.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"services.$[service]": service}}, updateOptions)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *MongoRepository) RemoveService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	_, err := r.addresses.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$pull": bson.M{"services": serviceKey(service, "")}})
+	return err
+}
+
+func (r *MongoRepository) ChangeSecret(ctx context.Context, id bson.ObjectID, from string, to AddressUpdate, service mongodbtypes.Service) (bool, error) {
+	filter := bson.M{
+		"_id":      id,
+		"secret":   from,
+		"services": bson.M{"$size": 1},
+	}
+	for key, value := range serviceKey(service, "services.0.") {
+		filter[key] = value
+	}
+
+	to.Services = []mongodbtypes.Service{service}
+
+	result, err := r.addresses.UpdateOne(ctx, filter, bson.M{"$set": addressSet(to)})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *MongoRepository) DeleteAddress(ctx context.Context, id bson.ObjectID) error {
+	_, err := r.addresses.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (r *MongoRepository) RemoveExpiredServices(ctx context.Context, now time.Time) error {
+	update := bson.M{
+		"$pull": bson.M{
+			"services": bson.M{
+				"expires_at": bson.M{"$lte": now},
+			},
+		},
+	}
+
+	_, err := r.addresses.UpdateMany(ctx, bson.M{"services.expires_at": bson.M{"$lte": now}}, update)
+	return err
+}
+
+func (r *MongoRepository) InsertCompensation(ctx context.Context, compensation mongodbtypes.Compensation) error {
+	_, err := r.compensations.InsertOne(ctx, compensation)
+	return err
+}
+
+func (r *MongoRepository) SaveCompensation(ctx context.Context, compensation mongodbtypes.Compensation) error {
+	_, err := r.compensations.ReplaceOne(ctx, bson.M{"_id": compensation.ID}, compensation, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *MongoRepository) DeleteCompensation(ctx context.Context, id bson.ObjectID) error {
+	_, err := r.compensations.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (r *MongoRepository) DeleteSagaCompensations(ctx context.Context, sagaID bson.ObjectID) error {
+	_, err := r.compensations.DeleteMany(ctx, bson.M{"saga_id": sagaID})
+	return err
+}
+
+func (r *MongoRepository) FindCompensations(ctx context.Context, status string) ([]mongodbtypes.Compensation, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	cursor, err := r.compensations.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "saga_id", Value: 1}, {Key: "sequence", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var compensations []mongodbtypes.Compensation
+	if err := cursor.All(ctx, &compensations); err != nil {
+		return nil, fmt.Errorf("failed to decode compensations: %w", err)
+	}
+	return compensations, nil
+}
+
+func (r *MongoRepository) UpdateCompensationStatus(ctx context.Context, from, to string, createdBefore time.Time) (int, error) {
+	filter := bson.M{
+		"status":     from,
+		"created_at": bson.M{"$lte": createdBefore},
+	}
+
+	result, err := r.compensations.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"status": to}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
+
+func (r *MongoRepository) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	// A lease held by another holder does not match the filter, so the upsert tries to insert a second
+	// document with the same _id and fails with a duplicate key error.
+	_, err := r.leases.UpdateOne(ctx,
+		bson.M{"_id": name, "$or": bson.A{bson.M{"holder": holder}, bson.M{"expires_at": bson.M{"$lte": now}}}},
+		bson.M{"$set": bson.M{"holder": holder, "expires_at": now.Add(ttl)}},
+		options.UpdateOne().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *MongoRepository) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := r.leases.DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+	return err
+}
+
+func (r *MongoRepository) Migrate(ctx context.Context) ([]Migration, error) {
+	cursor, err := r.migrations.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode applied migrations: %w", err)
+	}
+
+	applied := map[int]bool{}
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+
+	return runMigrations(ctx, r.migrationList(), applied, func(ctx context.Context, record migrationRecord) error {
+		_, err := r.migrations.InsertOne(ctx, record)
+		if mongo.IsDuplicateKeyError(err) {
+			// Another instance applied the migration at the same time
+			return nil
+		}
+		return err
+	})
+}
+
+// migrationList returns the migrations of the MongoDB backend. Never change a released migration, add a
+// new one instead.
+func (r *MongoRepository) migrationList() []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "create address indexes",
+			Up: func(ctx context.Context) error {
+				if err := r.checkDuplicateAddresses(ctx); err != nil {
+					return err
+				}
+				_, err := r.addresses.Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						// An address can only be stored once per zone
+						Keys:    bson.D{{Key: "zone", Value: 1}, {Key: "address", Value: 1}},
+						Options: options.Index().SetName("zone_address").SetUnique(true),
+					},
+					{
+						// Lookups by secret, such as ServiceAlreadyRegistered
+						Keys:    bson.D{{Key: "secret", Value: 1}, {Key: "zone", Value: 1}, {Key: "ip_family", Value: 1}},
+						Options: options.Index().SetName("secret_zone_ip_family"),
+					},
+					{
+						Keys:    bson.D{{Key: "services.cluster_id", Value: 1}},
+						Options: options.Index().SetName("services_cluster_id"),
+					},
+					{
+						Keys:    bson.D{{Key: "services.expires_at", Value: 1}},
+						Options: options.Index().SetName("services_expires_at"),
+					},
+					{
+						Keys:    bson.D{{Key: "netbox_id", Value: 1}},
+						Options: options.Index().SetName("netbox_id"),
+					},
+				})
+				return err
+			},
+		},
+		{
+			Version:     2,
+			Description: "create compensation indexes",
+			Up: func(ctx context.Context) error {
+				_, err := r.compensations.Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						Keys:    bson.D{{Key: "saga_id", Value: 1}, {Key: "sequence", Value: -1}},
+						Options: options.Index().SetName("saga_id_sequence"),
+					},
+					{
+						Keys:    bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+						Options: options.Index().SetName("status_created_at"),
+					},
+				})
+				return err
+			},
+		},
+		{
+			Version:     3,
+			Description: "set schema_version on addresses",
+			Up: func(ctx context.Context) error {
+				_, err := r.addresses.UpdateMany(ctx,
+					bson.M{"schema_version": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"schema_version": 1}})
+				return err
+			},
+		},
+	}
+}
+
+// checkDuplicateAddresses returns an error listing the addresses stored more than once in a zone, which
+// keep the unique (zone, address) index from being created, and how to resolve them.
+func (r *MongoRepository) checkDuplicateAddresses(ctx context.Context) error {
+	cursor, err := r.addresses.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "zone", Value: "$zone"}, {Key: "address", Value: "$address"}}},
+			{Key: "ids", Value: bson.D{{Key: "$push", Value: "$_id"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.zone", Value: 1}, {Key: "_id.address", Value: 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("failed to look for duplicate addresses: %w", err)
+	}
+
+	var duplicates []struct {
+		Key struct {
+			Zone    string `bson:"zone"`
+			Address string `bson:"address"`
+		} `bson:"_id"`
+		IDs []bson.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("failed to decode duplicate addresses: %w", err)
+	}
+
+	descriptions := make([]string, 0, len(duplicates))
+	for _, duplicate := range duplicates {
+		ids := make([]string, 0, len(duplicate.IDs))
+		for _, id := range duplicate.IDs {
+			ids = append(ids, id.Hex())
+		}
+		descriptions = append(descriptions, describeDuplicateAddress(duplicate.Key.Zone, duplicate.Key.Address, ids))
+	}
+	return duplicateAddressesError(descriptions)
+}
+
+// describeDuplicateAddress describes an address stored more than once in a zone for duplicateAddressesError.
+func describeDuplicateAddress(zone, address string, ids []string) string {
+	return fmt.Sprintf("%s in zone %s (documents %s)", address, zone, strings.Join(ids, ", "))
+}
+
+// duplicateAddressesError returns an error listing the described duplicate addresses and how to resolve
+// them, or nil if there are none.
+func duplicateAddressesError(descriptions []string) error {
+	if len(descriptions) == 0 {
+		return nil
+	}
+	return fmt.Errorf("cannot make (zone, address) unique, %d addresses are stored more than once: %s. "+
+		"For each address, keep the document whose netbox_id is the prefix in Netbox, move the services of the "+
+		"other documents to it, delete the other documents and run ipam-cli migrate again",
+		len(descriptions), strings.Join(descriptions, "; "))
+}
+
+func (r *MongoRepository) Ping(ctx context.Context) error {
+	return r.addresses.Database().Client().Ping(ctx, readpref.Primary())
+}
+
+func (r *MongoRepository) Close(ctx context.Context) error {
+	return r.addresses.Database().Client().Disconnect(ctx)
+}
+
+// serviceKey returns a filter matching the name, namespace and cluster of service, with prefix prepended to
+// the field names.
+func serviceKey(service mongodbtypes.Service, prefix string) bson.M {
+	return bson.M{
+		prefix + "service_name": service.ServiceName,
+		prefix + "namespace_id": service.NamespaceID,
+		prefix + "cluster_id":   service.ClusterID,
+	}
+}
+
+// addressFilter translates an AddressQuery to a MongoDB filter.
+func addressFilter(query AddressQuery) bson.M {
+	filter := bson.M{}
+
+	if !query.ID.IsZero() {
+		filter["_id"] = query.ID
+	} else if !query.After.IsZero() {
+		filter["_id"] = bson.M{"$gt": query.After}
+	}
+	if query.Secret != "" {
+		filter["secret"] = query.Secret
+	}
+	if query.Zone != "" {
+		filter["zone"] = query.Zone
+	}
+	if query.IPFamily != "" {
+		filter["ip_family"] = query.IPFamily
+	}
+	address := bson.M{}
+	if query.Address != "" {
+		address["$eq"] = query.Address
+	}
+	if query.ExceptAddress != "" {
+		address["$ne"] = query.ExceptAddress
+	}
+	if len(address) > 0 {
+		filter["address"] = address
+	}
+
+	serviceFilter := bson.M{}
+	if query.ClusterID != "" {
+		serviceFilter["cluster_id"] = query.ClusterID
+	}
+	if query.NamespaceID != "" {
+		serviceFilter["namespace_id"] = query.NamespaceID
+	}
+	if query.ServiceName != "" {
+		serviceFilter["service_name"] = query.ServiceName
+	}
+	if len(serviceFilter) > 0 {
+		filter["services"] = bson.M{"$elemMatch": serviceFilter}
+	}
+
+	if query.WithoutServices {
+		filter["$or"] = []bson.M{
+			{"services": bson.M{"$exists": false}},
+			{"services": bson.A{}},
+		}
+	}
+
+	return filter
+}
@@ -0,0 +1,304 @@
+// Package repository stores address documents and saga compensations. The Repository interface has a
+// MongoDB, a PostgreSQL, an embedded bbolt and an in-memory implementation, selected with storage.backend.
+package repository
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/pkg/clients/mongodb"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Storage backends.
+const (
+	BackendMongoDB  = "mongodb"
+	BackendPostgres = "postgres"
+	BackendBolt     = "bolt"
+	BackendMemory   = "memory"
+)
+
+// ErrNotFound is returned when no address document matches a query.
+var ErrNotFound = errors.New("address not found")
+
+// ErrDuplicateAddress is returned by InsertAddress when the zone already has a document for the address.
+var ErrDuplicateAddress = errors.New("address is already stored in the zone")
+
+// AddressQuery selects address documents. Empty fields match every document. The cluster, namespace and
+// service name are matched against the same entry in the services array.
+type AddressQuery struct {
+	ID          bson.ObjectID
+	Secret      string
+	Zone        string
+	IPFamily    string
+	Address     string
+	ClusterID   string
+	NamespaceID string
+	ServiceName string
+	// ExceptAddress selects only addresses other than ExceptAddress.
+	ExceptAddress string
+	// WithoutServices selects only addresses without services.
+	WithoutServices bool
+	// After selects only addresses with an ID greater than After, for cursor based pagination.
+	After bson.ObjectID
+	// Limit is the maximum number of addresses returned by FindAddresses, and the largest count returned
+	// by CountAddresses. Zero returns every match.
+	Limit int
+}
+
+// AddressUpdate describes the changes made by UpdateAddress. Zero fields are left unchanged, so a nil
+// Services keeps the services while an empty slice removes them.
+type AddressUpdate struct {
+	Secret        string
+	SecretHash    string
+	SecretKeyID   string
+	SchemaVersion int
+	NetboxID      int
+	Services      []mongodbtypes.Service
+}
+
+// Repository persists address documents and the compensations of allocation sagas.
+// Addresses are returned in ID order.
+type Repository interface {
+	// InsertAddress stores a new address document and returns its ID. A zero ID is generated. An address is
+	// stored once per zone: ErrDuplicateAddress is returned if the zone already has a document for it.
+	InsertAddress(ctx context.Context, address mongodbtypes.Address) (bson.ObjectID, error)
+	// FindAddress returns the first address matching query, or ErrNotFound.
+	FindAddress(ctx context.Context, query AddressQuery) (mongodbtypes.Address, error)
+	// FindAddresses returns the addresses matching query.
+	FindAddresses(ctx context.Context, query AddressQuery) ([]mongodbtypes.Address, error)
+	// CountAddresses returns the number of addresses matching query, without reading them.
+	CountAddresses(ctx context.Context, query AddressQuery) (int, error)
+	// UpdateAddress applies update to the address with the given ID.
+	UpdateAddress(ctx context.Context, id bson.ObjectID, update AddressUpdate) error
+	// PutService atomically adds service to the address with the given ID, replacing the service with the
+	// same name, namespace and cluster. Services registered concurrently on the same address are kept.
+	PutService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) error
+	// UpdateService atomically replaces the service with the same name, namespace and cluster on the address
+	// with the given ID, and reports whether the address had such a service.
+	UpdateService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) (bool, error)
+	// RemoveService atomically removes the service with the same name, namespace and cluster from the
+	// address with the given ID. Services registered concurrently on the same address are kept. Removing a
+	// missing service succeeds.
+	RemoveService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) error
+	// ChangeSecret atomically applies the secret fields of to and sets the services of the address with the
+	// given ID to service, if the address still has the secret from and service is its only service. It
+	// reports whether the address was changed.
+	ChangeSecret(ctx context.Context, id bson.ObjectID, from string, to AddressUpdate, service mongodbtypes.Service) (bool, error)
+	// DeleteAddress deletes the address with the given ID. Deleting a missing address succeeds.
+	DeleteAddress(ctx context.Context, id bson.ObjectID) error
+	// RemoveExpiredServices removes the services that expired at or before now from every address.
+	RemoveExpiredServices(ctx context.Context, now time.Time) error
+
+	// InsertCompensation stores a new compensation.
+	InsertCompensation(ctx context.Context, compensation mongodbtypes.Compensation) error
+	// SaveCompensation stores a compensation, replacing a stored compensation with the same ID.
+	SaveCompensation(ctx context.Context, compensation mongodbtypes.Compensation) error
+	// DeleteCompensation deletes the compensation with the given ID.
+	DeleteCompensation(ctx context.Context, id bson.ObjectID) error
+	// DeleteSagaCompensations deletes every compensation of a saga.
+	DeleteSagaCompensations(ctx context.Context, sagaID bson.ObjectID) error
+	// FindCompensations returns the compensations with the given status, or every compensation if status
+	// is empty, ordered by saga and in reverse order of recording within a saga.
+	FindCompensations(ctx context.Context, status string) ([]mongodbtypes.Compensation, error)
+	// UpdateCompensationStatus sets the status of the compensations with status from that were created at
+	// or before createdBefore, and returns how many were changed.
+	UpdateCompensationStatus(ctx context.Context, from, to string, createdBefore time.Time) (int, error)
+
+	// AcquireLease takes the lease with the given name for holder until ttl from now, if the lease is free,
+	// expired or already held by holder, and reports whether holder has the lease. Leases keep instances
+	// from doing the same work at the same time, such as checking and using a quota or repairing Netbox.
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease releases the lease with the given name if holder has it. Releasing a lease that is held
+	// by another holder, or not held at all, succeeds.
+	ReleaseLease(ctx context.Context, name, holder string) error
+
+	// Migrate applies the migrations of the backend that were not applied before, such as creating
+	// indexes, and returns the migrations it applied.
+	Migrate(ctx context.Context) ([]Migration, error)
+
+	// Ping checks that the backend can be reached.
+	Ping(ctx context.Context) error
+
+	// Close releases the connections of the repository.
+	Close(ctx context.Context) error
+}
+
+var repository Repository
+
+// InitRepository opens the backend configured in storage.backend and makes it the repository of the package.
+// The operations of the repository are recorded in the storage metrics.
+//
+// Parameters:
+//   - ctx: Context for connecting to the backend.
+//
+// Returns:
+//   - error: An error if the backend is unknown or cannot be opened.
+func InitRepository(ctx context.Context) error {
+	var (
+		opened Repository
+		err    error
+	)
+
+	backend := viper.GetString("storage.backend")
+	switch backend {
+	case BackendMongoDB, "":
+		client, err := mongodb.InitClient(ctx, mongodb.ConfigFromViper())
+		if err != nil {
+			return err
+		}
+		opened = NewMongoRepository(client.Database(viper.GetString("mongodb.database")),
+			viper.GetString("mongodb.collection"), viper.GetString("mongodb.compensation_collection"),
+			viper.GetString("mongodb.migration_collection"), viper.GetString("mongodb.lease_collection"))
+	case BackendPostgres:
+		opened, err = NewPostgresRepository(ctx, viper.GetString("storage.postgres.dsn"))
+	case BackendBolt:
+		opened, err = NewBoltRepository(viper.GetString("storage.bolt.path"))
+	case BackendMemory:
+		opened = NewMemoryRepository()
+	default:
+		return fmt.Errorf("unknown storage backend %q", backend)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	repository = instrument(opened)
+	return nil
+}
+
+// SetRepository replaces the repository of the package, for example with a memory repository in tests.
+// Like the repository opened by InitRepository, its operations are recorded in the storage metrics and traced.
+func SetRepository(r Repository) {
+	repository = instrument(r)
+}
+
+// GetRepository returns the repository of the package.
+func GetRepository() Repository {
+	return repository
+}
+
+// matches reports whether the address matches every field of query except After and Limit.
+// It is used by the backends that filter in Go.
+func (query AddressQuery) matches(address mongodbtypes.Address) bool {
+	switch {
+	case !query.ID.IsZero() && address.ID != query.ID,
+		query.Secret != "" && address.Secret != query.Secret,
+		query.Zone != "" && address.Zone != query.Zone,
+		query.IPFamily != "" && address.IPFamily != query.IPFamily,
+		query.Address != "" && address.Address != query.Address,
+		query.ExceptAddress != "" && address.Address == query.ExceptAddress,
+		query.WithoutServices && len(address.Services) > 0:
+		return false
+	}
+
+	if query.ClusterID == "" && query.NamespaceID == "" && query.ServiceName == "" {
+		return true
+	}
+
+	return slices.ContainsFunc(address.Services, func(service mongodbtypes.Service) bool {
+		return (query.ClusterID == "" || service.ClusterID == query.ClusterID) &&
+			(query.NamespaceID == "" || service.NamespaceID == query.NamespaceID) &&
+			(query.ServiceName == "" || service.ServiceName == query.ServiceName)
+	})
+}
+
+// lease is how a backend stores a lease. Expiry is decided by the clock of the instance taking the lease.
+type lease struct {
+	Name      string    `bson:"_id" json:"name"`
+	Holder    string    `bson:"holder" json:"holder"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// available reports whether holder may take the lease at now.
+func (l lease) available(holder string, now time.Time) bool {
+	return l.Holder == holder || !l.ExpiresAt.After(now)
+}
+
+// apply returns the address with update applied.
+func (update AddressUpdate) apply(address mongodbtypes.Address) mongodbtypes.Address {
+	if update.Secret != "" {
+		address.Secret = update.Secret
+	}
+	if update.SecretHash != "" {
+		address.SecretHash = update.SecretHash
+	}
+	if update.SecretKeyID != "" {
+		address.SecretKeyID = update.SecretKeyID
+	}
+	if update.SchemaVersion != 0 {
+		address.SchemaVersion = update.SchemaVersion
+	}
+	if update.NetboxID != 0 {
+		address.NetboxID = update.NetboxID
+	}
+	if update.Services != nil {
+		address.Services = slices.Clone(update.Services)
+	}
+	return address
+}
+
+// sameService reports whether a and b are the same service, which is identified by its name, namespace and cluster.
+func sameService(a, b mongodbtypes.Service) bool {
+	return a.ServiceName == b.ServiceName && a.NamespaceID == b.NamespaceID && a.ClusterID == b.ClusterID
+}
+
+// putService returns services with service added, replacing the same service if present.
+func putService(services []mongodbtypes.Service, service mongodbtypes.Service) []mongodbtypes.Service {
+	services = slices.DeleteFunc(slices.Clone(services), func(existing mongodbtypes.Service) bool {
+		return sameService(existing, service)
+	})
+	return append(services, service)
+}
+
+// removeService returns services without the same service as service.
+func removeService(services []mongodbtypes.Service, service mongodbtypes.Service) []mongodbtypes.Service {
+	return slices.DeleteFunc(slices.Clone(services), func(existing mongodbtypes.Service) bool {
+		return sameService(existing, service)
+	})
+}
+
+// replaceService returns services with the same service replaced by service, and whether it was present.
+func replaceService(services []mongodbtypes.Service, service mongodbtypes.Service) ([]mongodbtypes.Service, bool) {
+	index := slices.IndexFunc(services, func(existing mongodbtypes.Service) bool {
+		return sameService(existing, service)
+	})
+	if index < 0 {
+		return services, false
+	}
+
+	services = slices.Clone(services)
+	services[index] = service
+	return services, true
+}
+
+// canChangeSecret reports whether ChangeSecret may change the address.
+func canChangeSecret(address mongodbtypes.Address, from string, service mongodbtypes.Service) bool {
+	return address.Secret == from && len(address.Services) == 1 && sameService(address.Services[0], service)
+}
+
+// withoutExpiredServices returns the services that have not expired at now, and whether any were removed.
+func withoutExpiredServices(services []mongodbtypes.Service, now time.Time) ([]mongodbtypes.Service, bool) {
+	kept := slices.DeleteFunc(slices.Clone(services), func(service mongodbtypes.Service) bool {
+		return service.ExpiresAt != nil && !service.ExpiresAt.After(now)
+	})
+	return kept, len(kept) != len(services)
+}
+
+// sortCompensations orders compensations by saga and in reverse order of recording within a saga.
+func sortCompensations(compensations []mongodbtypes.Compensation) {
+	slices.SortFunc(compensations, func(a, b mongodbtypes.Compensation) int {
+		if c := bytes.Compare(a.SagaID[:], b.SagaID[:]); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Sequence, a.Sequence)
+	})
+}
@@ -1,9 +1,12 @@
 package responses
 
+import "time"
+
 type NetboxPrefix struct {
-	ID     int    `json:"id"`
-	Prefix string `json:"prefix"`
-	Family struct {
+	ID      int       `json:"id"`
+	Prefix  string    `json:"prefix"`
+	Created time.Time `json:"created"`
+	Family  struct {
 		Value int `json:"value"`
 	}
 	Vrf struct {
@@ -33,6 +36,11 @@ func (n NetboxPrefix) GetIPFamily() int {
 	return 0
 }
 
+type NetboxAvailablePrefix struct {
+	Family int    `json:"family"`
+	Prefix string `json:"prefix"`
+}
+
 type NetboxChoiceSet struct {
 	ChoicesCount int        `json:"choices_count"`
 	ExtraChoices [][]string `json:"extra_choices"`
@@ -1,12 +1,18 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
 	swaggerFiles "github.com/swaggo/files"     // swagger embed files
 	ginSwagger "github.com/swaggo/gin-swagger" // gin-swagger middleware
 	docs "github.com/vitistack/ipam-api/docs"
+	"github.com/vitistack/ipam-api/internal/auth"
 	"github.com/vitistack/ipam-api/internal/handlers/addresseshandler"
-	// "github.com/vitistack/ipam-api/internal/middleware"
+	"github.com/vitistack/ipam-api/internal/handlers/healthhandler"
+	"github.com/vitistack/ipam-api/internal/middleware"
 )
 
 func SetupRoutes(server *gin.Engine) {
@@ -14,27 +20,59 @@ func SetupRoutes(server *gin.Engine) {
 	docs.SwaggerInfo.Title = "IPAM-API"
 	docs.SwaggerInfo.Description = "This the Vitistack IPAM-API server."
 	docs.SwaggerInfo.Version = "1.0"
-	docs.SwaggerInfo.Host = "localhost:3000"
+	// The host the API is reached on, empty lets Swagger UI use the host it was loaded from
+	docs.SwaggerInfo.Host = viper.GetString("server.public_host")
 	docs.SwaggerInfo.BasePath = "/v2"
 	docs.SwaggerInfo.Schemes = []string{"http", "https"}
 
+	// Once an authentication method is configured, every API route needs an authenticated caller.
+	// Swagger, the metrics and the probes stay open.
+	api := server.Group("")
+	if auth.Enabled() {
+		api.Use(middleware.RequireAuth())
+	}
+
 	// API routes before versioning
-	server.POST("/", addresseshandler.RegisterAddress)
-	server.DELETE("/", addresseshandler.ExpireAddress)
+	api.POST("/", addresseshandler.RegisterAddress)
+	api.DELETE("/", addresseshandler.ExpireAddress)
 
 	// v2 API routes
-	v2 := server.Group("/v2")
+	v2 := api.Group("/v2")
 	{
+		v2.GET("/address", addresseshandler.GetAddress)
 		v2.POST("/address", addresseshandler.RegisterAddress)
-		// v2.DELETE("/cluster", middleware.TokenAuth(), addresseshandler.ExpireCluster)
+		v2.POST("/addresses\\:batch", addresseshandler.RegisterBatch)
 		v2.DELETE("/service", addresseshandler.ExpireAddress)
 	}
 
+	// Administrative routes need an authenticated caller even without an authentication method, so they
+	// cannot be used. Otherwise the api group already requires one.
+	admin := v2.Group("")
+	if !auth.Enabled() {
+		admin.Use(middleware.RequireAuth())
+	}
+	{
+		admin.GET("/addresses", addresseshandler.ListAddresses)
+		admin.DELETE("/cluster", addresseshandler.ExpireCluster)
+	}
+
 	server.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	server.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	// Catch-all route
+	// Kubernetes probes
+	server.GET("/healthz", healthhandler.Healthz)
+	server.GET("/readyz", healthhandler.Readyz)
+
+	// Landing page, also shown with 404 Not Found for unknown paths
+	server.GET("/", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", landingPage)
+	})
 	server.NoRoute(func(c *gin.Context) {
-		c.Data(200, "text/html; charset=utf-8", []byte(`
+		c.Data(http.StatusNotFound, "text/html; charset=utf-8", landingPage)
+	})
+}
+
+var landingPage = []byte(`
 		<!DOCTYPE html>
 		<html lang="en">
 		<head>
@@ -46,6 +84,4 @@ func SetupRoutes(server *gin.Engine) {
 			<p>Take a look at <a href="/swagger/index.html">Swagger</a> for api docs.</p>
 		</body>
 		</html>
-	`))
-	})
-}
+	`)
@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vitistack/ipam-api/internal/auth"
+	"github.com/vitistack/ipam-api/internal/middleware"
+)
+
+// TestAPIRoutesRequireAuthentication checks that once API tokens are configured, every API route rejects
+// anonymous callers before the handler runs, while the probes stay open.
+func TestAPIRoutesRequireAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := auth.Tokens
+	t.Cleanup(func() { auth.Tokens = previous })
+	auth.Tokens = &auth.TokenStore{}
+	if err := auth.Tokens.Load("", "a-token"); err != nil {
+		t.Fatalf("failed to load token: %v", err)
+	}
+
+	engine := gin.New()
+	engine.Use(middleware.Authenticate())
+	SetupRoutes(engine)
+
+	for _, route := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/"},
+		{http.MethodDelete, "/"},
+		{http.MethodGet, "/v2/address"},
+		{http.MethodPost, "/v2/address"},
+		{http.MethodGet, "/v2/addresses"},
+		{http.MethodPost, "/v2/addresses:batch"},
+		{http.MethodDelete, "/v2/cluster"},
+		{http.MethodDelete, "/v2/service"},
+	} {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(route.method, route.path, strings.NewReader("{}")))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected status 401 without a token, got %d", route.method, route.path, recorder.Code)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected /healthz to answer 200 without a token, got %d", recorder.Code)
+	}
+}
+
+// TestAdministrativeRoutesRequireAuthenticationWithoutAuthMethod checks that the administrative routes
+// cannot be used when no authentication method is configured.
+func TestAdministrativeRoutesRequireAuthenticationWithoutAuthMethod(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previous := auth.Tokens
+	t.Cleanup(func() { auth.Tokens = previous })
+	auth.Tokens = &auth.TokenStore{}
+	if auth.Enabled() {
+		t.Skip("an authentication method is configured")
+	}
+
+	engine := gin.New()
+	engine.Use(middleware.Authenticate())
+	SetupRoutes(engine)
+
+	for _, route := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/v2/addresses?cluster_id=cluster1"},
+		{http.MethodDelete, "/v2/cluster"},
+	} {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(route.method, route.path, strings.NewReader("{}")))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected status 401 without an authentication method, got %d", route.method, route.path, recorder.Code)
+		}
+	}
+}
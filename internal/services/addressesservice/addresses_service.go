@@ -1,33 +1,82 @@
 package addressesservice
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
 	"strconv"
 	"strings"
 
 	"github.com/vitistack/ipam-api/internal/logger"
-	"github.com/vitistack/ipam-api/internal/services/mongodbservice"
+	"github.com/vitistack/ipam-api/internal/metrics"
+	"github.com/vitistack/ipam-api/internal/responses"
 	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/internal/services/sagaservice"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
+	"github.com/vitistack/ipam-api/internal/tracing"
+	"github.com/vitistack/ipam-api/internal/utils"
 	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // RegisterAddress handles the registration of an IP address based on the provided IpamApiRequest.
 // It checks if the service is already registered in MongoDB, verifies address availability in Netbox,
 // and determines the appropriate registration or update action to perform:
-//   - If no address is provided and not registered, it registers the next available address.
+//   - If no address is provided and not registered, it registers the next available address (or subnet if a
+//     prefix length is requested).
 //   - If no address is provided but already registered, it updates the registration with the existing address.
 //   - If an address is provided and available in Netbox, it registers the specific address.
 //   - Otherwise, it updates the registration as default.
 //
+// Requests with IP family "dual" are handled by RegisterDualStack.
+//
 // Returns an IpamApiResponse and an error if any operation fails.
-func RegisterAddress(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
-	alreadyRegistered, err := mongodbservice.ServiceAlreadyRegistered(request)
+func RegisterAddress(ctx context.Context, request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
+	return registerAddress(ctx, nil, request, nil)
+}
+
+// registerAddress implements RegisterAddress. Prefix containers are looked up through containers,
+// which may be nil to scan the zone containers for every allocation.
+//
+// Every step is recorded in saga as it completes. A nil saga runs each allocation in a saga of its own,
+// otherwise undoing the steps is left to the caller that owns saga.
+func registerAddress(ctx context.Context, saga *sagaservice.Saga, request apicontracts.IpamAPIRequest, containers *containerCache) (response apicontracts.IpamAPIResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.RegisterAddress", requestAttributes(request)...)
+	defer func() { tracing.End(span, err) }()
+
+	if request.IPFamily == "dual" {
+		return registerDualStack(ctx, saga, request, containers)
+	}
+
+	alreadyRegistered, err := storageservice.ServiceAlreadyRegistered(ctx, request)
 	if err != nil {
 		logger.Log.Errorf("Failed to check if service is already registered: %v", err)
 		return apicontracts.IpamAPIResponse{}, err
 	}
 
+	if alreadyRegistered.Address != "" && request.PrefixLength != 0 {
+		registeredLength, err := utils.PrefixLength(alreadyRegistered.Address)
+		if err != nil {
+			return apicontracts.IpamAPIResponse{}, err
+		}
+		if registeredLength != request.PrefixLength {
+			return apicontracts.IpamAPIResponse{}, fmt.Errorf("service is already registered with %s, which does not match the requested prefix length /%d",
+				alreadyRegistered.Address, request.PrefixLength)
+		}
+	}
+
+	// The quota applies whenever the service is added to an address, including an existing address it
+	// joins while it is registered on another one
+	if alreadyRegistered.Address == "" || (request.Address != "" && request.Address != alreadyRegistered.Address) {
+		release, err := reserveQuota(ctx, request)
+		if err != nil {
+			return apicontracts.IpamAPIResponse{}, err
+		}
+		defer release()
+	}
+
 	availableInNetbox := false
 	if request.Address != "" {
 		zone := request.Zone + "_v" + string(request.IPFamily[len(request.IPFamily)-1])
@@ -40,7 +89,7 @@ func RegisterAddress(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIR
 			"prefix":            request.Address,
 			"present_in_vrf_id": strconv.Itoa(vrfID),
 		}
-		availableInNetbox, err = netboxservice.PrefixAvailable(queryParams)
+		availableInNetbox, err = netboxservice.PrefixAvailable(ctx, queryParams)
 		if err != nil {
 			return apicontracts.IpamAPIResponse{}, err
 		}
@@ -48,7 +97,7 @@ func RegisterAddress(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIR
 
 	if request.Address == "" && alreadyRegistered.Address == "" {
 		// Not registered in MongoDB and no address provided
-		response, err := RegisterNextAvailable(request)
+		response, err := registerNextAvailable(ctx, saga, request, containers)
 		if err != nil {
 			return apicontracts.IpamAPIResponse{}, err
 		}
@@ -56,7 +105,7 @@ func RegisterAddress(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIR
 	} else if request.Address == "" && alreadyRegistered.Address != "" {
 		// Already registered in MongoDB and no address provided
 		request.Address = alreadyRegistered.Address
-		response, err := Update(request)
+		response, err := update(ctx, saga, request)
 		if err != nil {
 			logger.Log.Errorf("Failed to register update address: %v", err)
 			return apicontracts.IpamAPIResponse{}, err
@@ -64,7 +113,7 @@ func RegisterAddress(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIR
 		return response, nil
 	} else if availableInNetbox && request.Address != "" {
 		// Address is available in Netbox and provided in the request
-		response, err := RegisterSpecific(request)
+		response, err := registerSpecific(ctx, saga, request)
 		if err != nil {
 			logger.Log.Errorf("Failed to register specific address: %v", err)
 			return apicontracts.IpamAPIResponse{}, err
@@ -72,7 +121,7 @@ func RegisterAddress(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIR
 		return response, nil
 	} else {
 		// Update as default
-		response, err := Update(request)
+		response, err := update(ctx, saga, request)
 		if err != nil {
 			logger.Log.Errorf("Failed to update address: %v", err)
 			return apicontracts.IpamAPIResponse{}, err
@@ -81,6 +130,105 @@ func RegisterAddress(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIR
 	}
 }
 
+// RegisterDualStack registers an IPv4 and an IPv6 address for the same service under one secret.
+// The IPv4 address is registered first. If the IPv6 registration fails, the IPv4 registration is rolled back:
+// a newly allocated address is released in Netbox and MongoDB, while on an address the service was already
+// registered on the service is restored as it was. Every step is recorded as it completes, so a registration
+// interrupted between the two families is still known to its saga. Rollback steps that fail are retried in the background.
+//
+// Parameters:
+//   - ctx: Context of the Netbox requests.
+//   - request: apicontracts.IpamAPIRequest with IP family "dual" and no address.
+//
+// Returns:
+//   - apicontracts.IpamAPIResponse: Response containing both registered addresses.
+//   - error: Error if either registration fails.
+func RegisterDualStack(ctx context.Context, request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
+	return registerDualStack(ctx, nil, request, nil)
+}
+
+func registerDualStack(ctx context.Context, saga *sagaservice.Saga, request apicontracts.IpamAPIRequest, containers *containerCache) (response apicontracts.IpamAPIResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.RegisterDualStack", requestAttributes(request)...)
+	defer func() { tracing.End(span, err) }()
+
+	ipv4Request := request
+	ipv4Request.IPFamily = "ipv4"
+	ipv6Request := request
+	ipv6Request.IPFamily = "ipv6"
+
+	err = withSaga(ctx, saga, "register dual-stack "+request.Service.ServiceName+" in "+request.Zone, func(saga *sagaservice.Saga) error {
+		ipv4Response, err := registerAddress(ctx, saga, ipv4Request, containers)
+		if err != nil {
+			return fmt.Errorf("failed to register ipv4 address: %w", err)
+		}
+
+		ipv6Response, err := registerAddress(ctx, saga, ipv6Request, containers)
+		if err != nil {
+			return fmt.Errorf("failed to register ipv6 address, ipv4 address %s is rolled back: %w", ipv4Response.Address, err)
+		}
+
+		response = apicontracts.IpamAPIResponse{
+			Message:   "Addresses registered successfully",
+			Addresses: []string{ipv4Response.Address, ipv6Response.Address},
+		}
+		return nil
+	})
+	if err != nil {
+		return apicontracts.IpamAPIResponse{}, err
+	}
+
+	logger.Log.Infof("Dual-stack addresses %s and %s registered successfully", response.Addresses[0], response.Addresses[1])
+	return response, nil
+}
+
+// withSaga runs steps, which record the compensation of every completed step in the saga they are given.
+// If saga is nil, steps run in a new saga named name, which is completed if steps succeed and aborted if they
+// fail. Otherwise steps run in saga, and completing or aborting it is left to its owner.
+func withSaga(ctx context.Context, saga *sagaservice.Saga, name string, steps func(saga *sagaservice.Saga) error) error {
+	if saga != nil {
+		return steps(saga)
+	}
+
+	saga = sagaservice.New(ctx, name)
+	err := steps(saga)
+	if err != nil {
+		if rollbackErr := saga.Abort(err); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed and will be retried: %v)", err, rollbackErr)
+		}
+		return err
+	}
+
+	saga.Complete()
+	return nil
+}
+
+// ReleaseAddress deletes an address from Netbox and MongoDB. The Netbox prefix is deleted first,
+// so a failure never leaves a Netbox prefix without an owner in MongoDB.
+//
+// Parameters:
+//   - ctx: Context of the Netbox request.
+//   - address: mongodbtypes.Address to release.
+//
+// Returns:
+//   - error: Error if deleting the prefix in Netbox or the document in MongoDB fails.
+func ReleaseAddress(ctx context.Context, address mongodbtypes.Address) (err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.ReleaseAddress", attribute.String("ipam.address", address.Address))
+	defer func() { tracing.End(span, err) }()
+
+	err = netboxservice.DeleteNetboxPrefix(ctx, address.NetboxID)
+	if err != nil {
+		return fmt.Errorf("failed to delete prefix %s from Netbox: %w", address.Address, err)
+	}
+
+	err = storageservice.DeleteAddress(ctx, address.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete address %s from MongoDB: %w", address.Address, err)
+	}
+
+	logger.Log.Infof("Released address %s", address.Address)
+	return nil
+}
+
 // RegisterNextAvailable registers the next available address prefix for a given IPAM API request.
 // It performs the following steps:
 //  1. Retrieves the available prefix container from Netbox based on the request.
@@ -90,9 +238,18 @@ func RegisterAddress(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIR
 //  5. Updates the prefix information in Netbox with the new address details.
 //  6. Logs the successful registration and returns a response containing the registered address.
 //
+// Steps 3 to 5 run as a saga: if a step fails, the prefix and document created by the earlier steps are removed.
+//
 // Returns an IpamApiResponse with the registered address on success, or an error if any step fails.
-func RegisterNextAvailable(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
-	container, err := netboxservice.GetAvailablePrefixContainer(request)
+func RegisterNextAvailable(ctx context.Context, request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
+	return registerNextAvailable(ctx, nil, request, nil)
+}
+
+func registerNextAvailable(ctx context.Context, saga *sagaservice.Saga, request apicontracts.IpamAPIRequest, containers *containerCache) (response apicontracts.IpamAPIResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.RegisterNextAvailable", requestAttributes(request)...)
+	defer func() { tracing.End(span, err) }()
+
+	container, err := containers.get(ctx, request)
 
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
@@ -100,25 +257,31 @@ func RegisterNextAvailable(request apicontracts.IpamAPIRequest) (apicontracts.Ip
 
 	payload := apicontracts.GetNextPrefixPayload(request, container)
 
-	nextPrefix, err := netboxservice.GetNextPrefixFromContainer(strconv.Itoa(container.ID), payload)
+	nextPrefix, err := netboxservice.GetNextPrefixFromContainer(ctx, container.ID, payload)
 
-	if err != nil {
-		return apicontracts.IpamAPIResponse{}, err
+	if err != nil && containers.forget(request) {
+		// The cached container may have been exhausted by earlier allocations, scan the zone again
+		container, err = containers.get(ctx, request)
+		if err != nil {
+			return apicontracts.IpamAPIResponse{}, err
+		}
+		payload = apicontracts.GetNextPrefixPayload(request, container)
+		nextPrefix, err = netboxservice.GetNextPrefixFromContainer(ctx, container.ID, payload)
 	}
 
-	addressDocument, err := mongodbservice.RegisterAddress(request, nextPrefix)
-
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
 	}
 
-	updatePayload := apicontracts.GetUpdatePrefixPayload(nextPrefix, addressDocument, request)
-	err = netboxservice.UpdateNetboxPrefix(strconv.Itoa(nextPrefix.ID), updatePayload)
-
+	err = withSaga(ctx, saga, "register "+nextPrefix.Prefix+" for "+request.Service.ServiceName, func(saga *sagaservice.Saga) error {
+		return registerPrefix(ctx, saga, request, nextPrefix)
+	})
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
 	}
 
+	metrics.Allocations.WithLabelValues(request.Zone, request.IPFamily).Inc()
+
 	logger.Log.Infof("Address %s registered successfully", nextPrefix.Prefix)
 	return apicontracts.IpamAPIResponse{
 		Message: "Address registered successfully",
@@ -127,19 +290,52 @@ func RegisterNextAvailable(request apicontracts.IpamAPIRequest) (apicontracts.Ip
 
 }
 
+// registerPrefix stores the address of a prefix created in Netbox for request and updates the prefix with
+// the address details, recording in saga how to undo the prefix and every completed step. A document that
+// was saved before the storage reported an error is recorded to be deleted as well.
+func registerPrefix(ctx context.Context, saga *sagaservice.Saga, request apicontracts.IpamAPIRequest, prefix responses.NetboxPrefix) error {
+	saga.Record(sagaservice.DeleteNetboxPrefix(prefix.ID, prefix.Prefix))
+
+	addressDocument, err := storageservice.RegisterAddress(ctx, request, prefix)
+	if !addressDocument.ID.IsZero() {
+		saga.Record(sagaservice.DeleteAddress(addressDocument.ID, addressDocument.Address))
+	}
+	if err != nil {
+		return err
+	}
+
+	updatePayload := apicontracts.GetUpdatePrefixPayload(prefix, addressDocument, request)
+	err = netboxservice.UpdateNetboxPrefix(ctx, prefix.ID, updatePayload)
+	if err != nil {
+		logger.Log.Infof("Failed to update %s in Netbox: %v", prefix.Prefix, err.Error())
+		return err
+	}
+
+	return nil
+}
+
 // RegisterSpecific registers a specific IP address within a given zone and IP family.
 // It validates that the requested address belongs to a valid prefix for the specified zone,
 // retrieves an available prefix container, registers the prefix in Netbox, and stores the address
 // in MongoDB. The function also updates the prefix in Netbox with the new address information.
+// If a step fails, the prefix and document created by the earlier steps are removed.
 // Returns a successful IpamApiResponse if the operation completes, or an error if any step fails.
 //
 // Parameters:
+//   - ctx: Context of the Netbox requests.
 //   - request: apicontracts.IpamAPIRequest containing the address, zone, and IP family information.
 //
 // Returns:
 //   - apicontracts.IpamAPIResponse: Response containing a success message and the registered address.
 //   - error: Error if the address is invalid for the zone or if any registration step fails.
-func RegisterSpecific(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
+func RegisterSpecific(ctx context.Context, request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
+	return registerSpecific(ctx, nil, request)
+}
+
+func registerSpecific(ctx context.Context, saga *sagaservice.Saga, request apicontracts.IpamAPIRequest) (response apicontracts.IpamAPIResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.RegisterSpecific", requestAttributes(request)...)
+	defer func() { tracing.End(span, err) }()
+
 	zone := request.Zone + "_v" + string(request.IPFamily[len(request.IPFamily)-1])
 	zonePrefixes := netboxservice.Cache.Get(zone)
 
@@ -161,32 +357,27 @@ func RegisterSpecific(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPI
 		return apicontracts.IpamAPIResponse{}, errors.New("the requested address is not valid for the provided zone")
 	}
 
-	container, err := netboxservice.GetAvailablePrefixContainer(request)
+	container, err := netboxservice.GetAvailablePrefixContainer(ctx, request)
 
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
 	}
 
 	payload := apicontracts.GetCreatePrefixPayload(request, container)
-	prefix, err := netboxservice.RegisterPrefix(payload)
+	prefix, err := netboxservice.RegisterPrefix(ctx, payload)
 
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
 	}
 
-	addressDocument, err := mongodbservice.RegisterAddress(request, prefix)
-
+	err = withSaga(ctx, saga, "register "+prefix.Prefix+" for "+request.Service.ServiceName, func(saga *sagaservice.Saga) error {
+		return registerPrefix(ctx, saga, request, prefix)
+	})
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
 	}
 
-	updatePayload := apicontracts.GetUpdatePrefixPayload(prefix, addressDocument, request)
-	err = netboxservice.UpdateNetboxPrefix(strconv.Itoa(prefix.ID), updatePayload)
-
-	if err != nil {
-		logger.Log.Infof("Failed to update %s in Netbox: %v", request.Address, err.Error())
-		return apicontracts.IpamAPIResponse{}, err
-	}
+	metrics.Allocations.WithLabelValues(request.Zone, request.IPFamily).Inc()
 
 	logger.Log.Infof("Address %s registered successfully in Netbox and MongoDB", request.Address)
 	return apicontracts.IpamAPIResponse{
@@ -196,6 +387,84 @@ func RegisterSpecific(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPI
 
 }
 
+// GetAddress looks up the address registered with the provided secret, zone, IP family and address
+// and maps it to an IpamAPIAddressResponse. The stored secret is never part of the response.
+//
+// Parameters:
+//   - request: apicontracts.IpamAPIGetAddressRequest identifying the address.
+//
+// Returns:
+//   - apicontracts.IpamAPIAddressResponse: The address with its services and Netbox ID.
+//   - error: Error if the address is not found or the lookup fails.
+func GetAddress(ctx context.Context, request apicontracts.IpamAPIGetAddressRequest) (response apicontracts.IpamAPIAddressResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.GetAddress",
+		attribute.String("ipam.zone", request.Zone), attribute.String("ipam.ip_family", request.IPFamily), attribute.String("ipam.address", request.Address))
+	defer func() { tracing.End(span, err) }()
+
+	address, err := storageservice.GetAddress(ctx, request)
+	if err != nil {
+		return apicontracts.IpamAPIAddressResponse{}, err
+	}
+
+	return toAddressResponse(address), nil
+}
+
+// ListAddresses returns a page of addresses matching the filters in the provided request,
+// together with the cursor for the next page. Stored secrets are never part of the response.
+//
+// Parameters:
+//   - request: apicontracts.IpamAPIListAddressesRequest containing the filters, page size and cursor.
+//
+// Returns:
+//   - apicontracts.IpamAPIListAddressesResponse: The addresses in the page and the next cursor.
+//   - error: Error if the query fails.
+func ListAddresses(ctx context.Context, request apicontracts.IpamAPIListAddressesRequest) (response apicontracts.IpamAPIListAddressesResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.ListAddresses",
+		attribute.String("ipam.zone", request.Zone), attribute.String("ipam.cluster_id", request.ClusterID))
+	defer func() { tracing.End(span, err) }()
+
+	addresses, nextCursor, err := storageservice.ListAddresses(ctx, request)
+	if err != nil {
+		return apicontracts.IpamAPIListAddressesResponse{}, err
+	}
+
+	response = apicontracts.IpamAPIListAddressesResponse{
+		Addresses:  make([]apicontracts.IpamAPIAddressResponse, 0, len(addresses)),
+		NextCursor: nextCursor,
+	}
+	for _, address := range addresses {
+		response.Addresses = append(response.Addresses, toAddressResponse(address))
+	}
+
+	return response, nil
+}
+
+// requestAttributes returns the span attributes describing request.
+func requestAttributes(request apicontracts.IpamAPIRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("ipam.zone", request.Zone),
+		attribute.String("ipam.ip_family", request.IPFamily),
+		attribute.String("ipam.address", request.Address),
+		attribute.Int("ipam.prefix_length", request.PrefixLength),
+	}
+}
+
+// toAddressResponse maps an address document to its API representation, leaving out the secret.
+func toAddressResponse(address mongodbtypes.Address) apicontracts.IpamAPIAddressResponse {
+	services := make([]apicontracts.Service, 0, len(address.Services))
+	for _, service := range address.Services {
+		services = append(services, apicontracts.Service(service))
+	}
+
+	return apicontracts.IpamAPIAddressResponse{
+		Zone:     address.Zone,
+		IPFamily: address.IPFamily,
+		Address:  address.Address,
+		NetboxID: address.NetboxID,
+		Services: services,
+	}
+}
+
 // Update updates an address document in the MongoDB database based on the provided IpamApiRequest.
 // It returns an IpamApiResponse containing a success message and the updated address if the operation succeeds.
 // If an error occurs during the update, it returns an empty response and the error.
@@ -206,12 +475,25 @@ func RegisterSpecific(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPI
 // Returns:
 //   - apicontracts.IpamAPIResponse: Response with a success message and the updated address.
 //   - error: Error encountered during the update operation, if any.
-func Update(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
-	address, err := mongodbservice.UpdateAddressDocument(request)
+func Update(ctx context.Context, request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
+	return update(ctx, nil, request)
+}
+
+// update implements Update. If saga is not nil, it records how to undo the update: the service is restored
+// as it was on the address before, or removed if the update added it to the address.
+func update(ctx context.Context, saga *sagaservice.Saga, request apicontracts.IpamAPIRequest) (response apicontracts.IpamAPIResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.Update", requestAttributes(request)...)
+	defer func() { tracing.End(span, err) }()
+
+	address, err := storageservice.UpdateAddressDocument(ctx, request)
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
 	}
 
+	if saga != nil {
+		saga.Record(undoUpdate(address, requestService(request)))
+	}
+
 	logger.Log.Infof("Address %s updated successfully", request.Address)
 	return apicontracts.IpamAPIResponse{
 		Message: "Address updated successfully",
@@ -229,8 +511,11 @@ func Update(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse,
 // Returns:
 //   - apicontracts.IpamAPIResponse: Response containing a message and the address.
 //   - error: Error if setting the expiration fails.
-func SetServiceExpiration(request apicontracts.IpamAPIRequest) (apicontracts.IpamAPIResponse, error) {
-	err := mongodbservice.SetServiceExpiration(request)
+func SetServiceExpiration(ctx context.Context, request apicontracts.IpamAPIRequest) (response apicontracts.IpamAPIResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.SetServiceExpiration", requestAttributes(request)...)
+	defer func() { tracing.End(span, err) }()
+
+	err = storageservice.SetServiceExpiration(ctx, request)
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
 	}
@@ -251,8 +536,11 @@ func SetServiceExpiration(request apicontracts.IpamAPIRequest) (apicontracts.Ipa
 // Returns:
 //   - apicontracts.IpamAPIResponse: Response containing a message and the cluster ID.
 //   - error: Error if setting the expiration fails.
-func SetClusterExpiration(request apicontracts.IpamAPIDeleteClusterRequest) (apicontracts.IpamAPIResponse, error) {
-	err := mongodbservice.SetClusterExpiration(request)
+func SetClusterExpiration(ctx context.Context, request apicontracts.IpamAPIDeleteClusterRequest) (response apicontracts.IpamAPIResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.SetClusterExpiration", attribute.String("ipam.cluster_id", request.ClusterID))
+	defer func() { tracing.End(span, err) }()
+
+	err = storageservice.SetClusterExpiration(ctx, request)
 	if err != nil {
 		return apicontracts.IpamAPIResponse{}, err
 	}
@@ -262,3 +550,29 @@ func SetClusterExpiration(request apicontracts.IpamAPIDeleteClusterRequest) (api
 		ClusterID: request.ClusterID,
 	}, nil
 }
+
+// requestService returns the service of request as it is stored on an address.
+func requestService(request apicontracts.IpamAPIRequest) mongodbtypes.Service {
+	return mongodbtypes.Service{
+		ServiceName:         request.Service.ServiceName,
+		NamespaceID:         request.Service.NamespaceID,
+		ClusterID:           request.Service.ClusterID,
+		RetentionPeriodDays: request.Service.RetentionPeriodDays,
+		DenyExternalCleanup: request.Service.DenyExternalCleanup,
+	}
+}
+
+// undoUpdate returns the compensation of an update that put service on address, as the address was before the update.
+func undoUpdate(address mongodbtypes.Address, service mongodbtypes.Service) mongodbtypes.Compensation {
+	for _, previous := range address.Services {
+		if sameService(previous, service) {
+			return sagaservice.RestoreService(address, previous)
+		}
+	}
+	return sagaservice.RemoveService(address, service)
+}
+
+// sameService reports whether a and b are the same service, which is identified by its name, namespace and cluster.
+func sameService(a, b mongodbtypes.Service) bool {
+	return a.ServiceName == b.ServiceName && a.NamespaceID == b.NamespaceID && a.ClusterID == b.ClusterID
+}
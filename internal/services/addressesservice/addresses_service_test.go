@@ -0,0 +1,331 @@
+package addressesservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/responses"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice/netboxfake"
+	"github.com/vitistack/ipam-api/internal/services/sagaservice"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var errStorage = errors.New("storage unavailable")
+
+// observedInserts is a repository that calls onInsert before storing an address, and fails the insert if
+// onInsert returns an error.
+type observedInserts struct {
+	repository.Repository
+	onInsert func(address mongodbtypes.Address) error
+}
+
+func (r *observedInserts) InsertAddress(ctx context.Context, address mongodbtypes.Address) (bson.ObjectID, error) {
+	if r.onInsert != nil {
+		if err := r.onInsert(address); err != nil {
+			return bson.ObjectID{}, err
+		}
+	}
+	return r.Repository.InsertAddress(ctx, address)
+}
+
+// setup installs a memory repository and a Netbox fake with an IPv4 and an IPv6 container in zone inet.
+// The previous repository, client and secret key are restored when the test ends.
+func setup(t *testing.T) (*observedInserts, *netboxfake.Fake) {
+	t.Helper()
+	logger.InitConsoleLogger()
+
+	previousRepository := repository.GetRepository()
+	previousKey := viper.Get("secret_lookup_key")
+	t.Cleanup(func() {
+		repository.SetRepository(previousRepository)
+		viper.Set("secret_lookup_key", previousKey)
+	})
+	viper.Set("secret_lookup_key", strings.Repeat("k", 32))
+
+	repo := &observedInserts{Repository: repository.NewMemoryRepository()}
+	repository.SetRepository(repo)
+	return repo, netboxfake.Install(t)
+}
+
+func newRequest(ipFamily, serviceName string) apicontracts.IpamAPIRequest {
+	return apicontracts.IpamAPIRequest{
+		Secret:   "a_secret_value",
+		Zone:     "inet",
+		IPFamily: ipFamily,
+		Service:  apicontracts.Service{ServiceName: serviceName, NamespaceID: "namespace1", ClusterID: "cluster1"},
+	}
+}
+
+// allocated returns the prefixes allocated from the containers added by setup.
+func allocated(fake *netboxfake.Fake) []string {
+	var prefixes []string
+	for _, prefix := range fake.Prefixes() {
+		if prefix.Prefix != "10.0.0.0/24" && prefix.Prefix != "fd00::/64" {
+			prefixes = append(prefixes, prefix.Prefix)
+		}
+	}
+	return prefixes
+}
+
+func storedAddresses(t *testing.T, repo repository.Repository) []mongodbtypes.Address {
+	t.Helper()
+	addresses, err := repo.FindAddresses(context.Background(), repository.AddressQuery{})
+	if err != nil {
+		t.Fatalf("failed to list addresses: %v", err)
+	}
+	return addresses
+}
+
+// TestRegisterDualStackRecordsIPv4BeforeIPv6 checks that the undo steps of the IPv4 registration are stored
+// before the IPv6 registration starts, and that a failed IPv6 registration rolls back the IPv4 address.
+func TestRegisterDualStackRecordsIPv4BeforeIPv6(t *testing.T) {
+	repo, fake := setup(t)
+
+	var recorded []mongodbtypes.Compensation
+	repo.onInsert = func(address mongodbtypes.Address) error {
+		if address.IPFamily != "ipv6" {
+			return nil
+		}
+		var err error
+		recorded, err = repo.FindCompensations(context.Background(), "")
+		if err != nil {
+			t.Errorf("failed to read compensations: %v", err)
+		}
+		return errStorage
+	}
+
+	_, err := RegisterDualStack(context.Background(), newRequest("dual", "service1"))
+	if err == nil || !strings.Contains(err.Error(), errStorage.Error()) {
+		t.Fatalf("expected the IPv6 registration to fail, got %v", err)
+	}
+
+	actions := make(map[string]bool)
+	for _, compensation := range recorded {
+		actions[compensation.Action] = true
+	}
+	if !actions[sagaservice.ActionDeleteAddress] || !actions[sagaservice.ActionDeleteNetboxPrefix] {
+		t.Errorf("expected the IPv4 registration to be recorded before the IPv6 registration, got %+v", recorded)
+	}
+
+	if prefixes := allocated(fake); len(prefixes) != 0 {
+		t.Errorf("expected every allocated prefix to be rolled back, got %v", prefixes)
+	}
+	if addresses := storedAddresses(t, repo); len(addresses) != 0 {
+		t.Errorf("expected every address to be rolled back, got %+v", addresses)
+	}
+}
+
+// netboxPrefix returns the Netbox prefix with the given CIDR.
+func netboxPrefix(t *testing.T, fake *netboxfake.Fake, cidr string) responses.NetboxPrefix {
+	t.Helper()
+	for _, prefix := range fake.Prefixes() {
+		if prefix.Prefix == cidr {
+			return prefix
+		}
+	}
+	t.Fatalf("expected prefix %s in Netbox", cidr)
+	return responses.NetboxPrefix{}
+}
+
+func TestRegisterNextAvailable(t *testing.T) {
+	repo, fake := setup(t)
+
+	response, err := RegisterAddress(context.Background(), newRequest("ipv4", "service1"))
+	if err != nil {
+		t.Fatalf("failed to register address: %v", err)
+	}
+	if response.Address != "10.0.0.0/32" {
+		t.Errorf("expected the first address of the container, got %s", response.Address)
+	}
+
+	addresses := storedAddresses(t, repo)
+	if len(addresses) != 1 {
+		t.Fatalf("expected 1 stored address, got %+v", addresses)
+	}
+	prefix := netboxPrefix(t, fake, response.Address)
+	if addresses[0].NetboxID != prefix.ID {
+		t.Errorf("expected the document to reference Netbox prefix %d, got %d", prefix.ID, addresses[0].NetboxID)
+	}
+	if prefix.CustomFields.K8sUUID != addresses[0].ID.Hex() || prefix.CustomFields.K8sZone != "inet" {
+		t.Errorf("expected the Netbox prefix to reference document %s in zone inet, got %+v", addresses[0].ID.Hex(), prefix.CustomFields)
+	}
+
+	// The same service and secret get the same address again
+	again, err := RegisterAddress(context.Background(), newRequest("ipv4", "service1"))
+	if err != nil {
+		t.Fatalf("failed to register address again: %v", err)
+	}
+	if again.Address != response.Address {
+		t.Errorf("expected the registered address %s, got %s", response.Address, again.Address)
+	}
+
+	other, err := RegisterAddress(context.Background(), newRequest("ipv4", "service2"))
+	if err != nil {
+		t.Fatalf("failed to register address for another service: %v", err)
+	}
+	if other.Address != "10.0.0.1/32" {
+		t.Errorf("expected the next address of the container, got %s", other.Address)
+	}
+	if prefixes := allocated(fake); len(prefixes) != 2 {
+		t.Errorf("expected 2 allocated prefixes, got %v", prefixes)
+	}
+}
+
+func TestRegisterNextAvailableRollsBackWhenNetboxUpdateFails(t *testing.T) {
+	repo, fake := setup(t)
+	fake.Fail(netboxfake.OpUpdatePrefix, errors.New("netbox unavailable"))
+
+	if _, err := RegisterAddress(context.Background(), newRequest("ipv4", "service1")); err == nil {
+		t.Fatal("expected the registration to fail")
+	}
+
+	if prefixes := allocated(fake); len(prefixes) != 0 {
+		t.Errorf("expected the allocated prefix to be deleted, got %v", prefixes)
+	}
+	if addresses := storedAddresses(t, repo); len(addresses) != 0 {
+		t.Errorf("expected the stored address to be deleted, got %+v", addresses)
+	}
+}
+
+func TestRegisterSpecific(t *testing.T) {
+	repo, fake := setup(t)
+
+	request := newRequest("ipv4", "service1")
+	request.Address = "10.0.0.42/32"
+	response, err := RegisterAddress(context.Background(), request)
+	if err != nil {
+		t.Fatalf("failed to register address: %v", err)
+	}
+	if response.Address != request.Address {
+		t.Errorf("expected %s, got %s", request.Address, response.Address)
+	}
+
+	prefix := netboxPrefix(t, fake, request.Address)
+	addresses := storedAddresses(t, repo)
+	if len(addresses) != 1 || addresses[0].NetboxID != prefix.ID {
+		t.Errorf("expected one document referencing Netbox prefix %d, got %+v", prefix.ID, addresses)
+	}
+
+	outside := newRequest("ipv4", "service2")
+	outside.Address = "192.168.0.1/32"
+	if _, err := RegisterSpecific(context.Background(), outside); err == nil {
+		t.Error("expected an address outside the zone to be rejected")
+	}
+}
+
+func TestRegisterSpecificRollsBackWhenStorageFails(t *testing.T) {
+	repo, fake := setup(t)
+	repo.onInsert = func(mongodbtypes.Address) error { return errStorage }
+
+	request := newRequest("ipv4", "service1")
+	request.Address = "10.0.0.42/32"
+	if _, err := RegisterAddress(context.Background(), request); !errors.Is(err, errStorage) {
+		t.Fatalf("expected the storage error, got %v", err)
+	}
+
+	if prefixes := allocated(fake); len(prefixes) != 0 {
+		t.Errorf("expected the created prefix to be deleted, got %v", prefixes)
+	}
+}
+
+func TestRegisterDualStack(t *testing.T) {
+	repo, _ := setup(t)
+
+	response, err := RegisterAddress(context.Background(), newRequest("dual", "service1"))
+	if err != nil {
+		t.Fatalf("failed to register dual-stack addresses: %v", err)
+	}
+	if len(response.Addresses) != 2 || response.Addresses[0] != "10.0.0.0/32" || response.Addresses[1] != "fd00::/128" {
+		t.Errorf("expected an IPv4 and an IPv6 address, got %v", response.Addresses)
+	}
+	if addresses := storedAddresses(t, repo); len(addresses) != 2 {
+		t.Errorf("expected 2 stored addresses, got %+v", addresses)
+	}
+}
+
+// TestRegisterDualStackKeepsExistingIPv4Registration checks that a failed dual-stack registration of a
+// service that already had an IPv4 address restores that registration instead of releasing the address.
+func TestRegisterDualStackKeepsExistingIPv4Registration(t *testing.T) {
+	repo, fake := setup(t)
+
+	ipv4, err := RegisterAddress(context.Background(), newRequest("ipv4", "service1"))
+	if err != nil {
+		t.Fatalf("failed to register IPv4 address: %v", err)
+	}
+
+	fake.Fail(netboxfake.OpCreateAvailablePrefix, errors.New("netbox unavailable"))
+	if _, err := RegisterAddress(context.Background(), newRequest("dual", "service1")); err == nil {
+		t.Fatal("expected the IPv6 registration to fail")
+	}
+
+	addresses := storedAddresses(t, repo)
+	if len(addresses) != 1 || addresses[0].Address != ipv4.Address {
+		t.Fatalf("expected only the IPv4 address %s to be stored, got %+v", ipv4.Address, addresses)
+	}
+	if len(addresses[0].Services) != 1 || addresses[0].Services[0].ServiceName != "service1" {
+		t.Errorf("expected service1 to stay registered on %s, got %+v", ipv4.Address, addresses[0].Services)
+	}
+	if prefixes := allocated(fake); len(prefixes) != 1 || prefixes[0] != ipv4.Address {
+		t.Errorf("expected the IPv4 prefix to stay in Netbox, got %v", prefixes)
+	}
+}
+
+func TestRegisterBatch(t *testing.T) {
+	repo, fake := setup(t)
+
+	requests := []apicontracts.IpamAPIRequest{newRequest("ipv4", "service1"), newRequest("ipv4", "service2"), newRequest("dual", "service3")}
+	response, err := RegisterBatch(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("failed to register batch: %v", err)
+	}
+
+	for _, result := range response.Results {
+		if result.Status != apicontracts.BatchStatusRegistered {
+			t.Errorf("expected item %d to be registered, got %+v", result.Index, result)
+		}
+	}
+	if addresses := storedAddresses(t, repo); len(addresses) != 4 {
+		t.Errorf("expected 4 stored addresses, got %d", len(addresses))
+	}
+	if prefixes := allocated(fake); len(prefixes) != 4 {
+		t.Errorf("expected 4 allocated prefixes, got %v", prefixes)
+	}
+}
+
+// TestRegisterBatchRollsBackEarlierItems fails the last item of a batch and checks that the earlier items
+// are rolled back, including the IPv4 half of a dual-stack item.
+func TestRegisterBatchRollsBackEarlierItems(t *testing.T) {
+	repo, fake := setup(t)
+	repo.onInsert = func(address mongodbtypes.Address) error {
+		if address.Services[0].ServiceName == "service3" {
+			return errStorage
+		}
+		return nil
+	}
+
+	requests := []apicontracts.IpamAPIRequest{newRequest("ipv4", "service1"), newRequest("dual", "service2"), newRequest("ipv4", "service3"), newRequest("ipv4", "service4")}
+	response, err := RegisterBatch(context.Background(), requests)
+	if !errors.Is(err, errStorage) {
+		t.Fatalf("expected the storage error, got %v", err)
+	}
+
+	expected := []string{apicontracts.BatchStatusRolledBack, apicontracts.BatchStatusRolledBack, apicontracts.BatchStatusFailed, apicontracts.BatchStatusSkipped}
+	for i, result := range response.Results {
+		if result.Status != expected[i] {
+			t.Errorf("expected item %d to be %s, got %+v", i, expected[i], result)
+		}
+	}
+	if addresses := storedAddresses(t, repo); len(addresses) != 0 {
+		t.Errorf("expected every stored address to be rolled back, got %+v", addresses)
+	}
+	if prefixes := allocated(fake); len(prefixes) != 0 {
+		t.Errorf("expected every allocated prefix to be rolled back, got %v", prefixes)
+	}
+}
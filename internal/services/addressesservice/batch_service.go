@@ -0,0 +1,170 @@
+package addressesservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/responses"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/internal/services/sagaservice"
+	"github.com/vitistack/ipam-api/internal/tracing"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// containerCache remembers the prefix container chosen for each zone, IP family and prefix length,
+// so that a batch scans the containers of a zone once instead of once per allocation.
+// A nil *containerCache scans the containers on every lookup.
+type containerCache struct {
+	containers map[string]responses.NetboxPrefix
+}
+
+func newContainerCache() *containerCache {
+	return &containerCache{containers: make(map[string]responses.NetboxPrefix)}
+}
+
+func containerCacheKey(request apicontracts.IpamAPIRequest) string {
+	return fmt.Sprintf("%s/%s/%d", request.Zone, request.IPFamily, request.PrefixLength)
+}
+
+// get returns an available prefix container for the request.
+func (c *containerCache) get(ctx context.Context, request apicontracts.IpamAPIRequest) (responses.NetboxPrefix, error) {
+	if c == nil {
+		return netboxservice.GetAvailablePrefixContainer(ctx, request)
+	}
+
+	key := containerCacheKey(request)
+	if container, ok := c.containers[key]; ok {
+		return container, nil
+	}
+
+	container, err := netboxservice.GetAvailablePrefixContainer(ctx, request)
+	if err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+
+	c.containers[key] = container
+	return container, nil
+}
+
+// forget removes the cached container for the request. It reports whether a container was cached.
+func (c *containerCache) forget(request apicontracts.IpamAPIRequest) bool {
+	if c == nil {
+		return false
+	}
+
+	key := containerCacheKey(request)
+	_, ok := c.containers[key]
+	delete(c.containers, key)
+	return ok
+}
+
+// batchRegistration is a registered batch item together with the saga that rolls it back.
+type batchRegistration struct {
+	index int
+	saga  *sagaservice.Saga
+}
+
+// RegisterBatch registers all requests as one unit. Requests are registered in order, reusing the
+// prefix container of a zone between allocations. If a request fails, every request registered before
+// it is rolled back in reverse order and the remaining requests are skipped.
+//
+// Parameters:
+//   - ctx: Context of the Netbox requests.
+//   - requests: The validated requests to register.
+//
+// Returns:
+//   - apicontracts.IpamAPIBatchResponse: The result of every request in the batch.
+//   - error: Error if a request fails. The response still reports the result of every request.
+func RegisterBatch(ctx context.Context, requests []apicontracts.IpamAPIRequest) (response apicontracts.IpamAPIBatchResponse, err error) {
+	ctx, span := tracing.Start(ctx, "addressesservice.RegisterBatch", attribute.Int("ipam.batch_size", len(requests)))
+	defer func() { tracing.End(span, err) }()
+
+	containers := newContainerCache()
+	results := make([]apicontracts.IpamAPIBatchItemResult, len(requests))
+	registrations := make([]batchRegistration, 0, len(requests))
+
+	for index, request := range requests {
+		registration, response, err := registerBatchItem(ctx, index, request, containers)
+		if err != nil {
+			logger.Log.Errorf("Batch item %d failed, rolling back %d registered items: %v", index, len(registrations), err)
+			results[index] = apicontracts.IpamAPIBatchItemResult{
+				Index:  index,
+				Status: apicontracts.BatchStatusFailed,
+				Error:  err.Error(),
+			}
+			for skipped := index + 1; skipped < len(requests); skipped++ {
+				results[skipped] = apicontracts.IpamAPIBatchItemResult{Index: skipped, Status: apicontracts.BatchStatusSkipped}
+			}
+
+			rollbackErr := rollbackBatch(registrations, results, err)
+
+			return apicontracts.IpamAPIBatchResponse{
+				Message: "Batch registration failed, registered addresses were rolled back",
+				Results: results,
+			}, errors.Join(fmt.Errorf("batch item %d failed: %w", index, err), rollbackErr)
+		}
+
+		registrations = append(registrations, registration)
+		results[index] = apicontracts.IpamAPIBatchItemResult{
+			Index:     index,
+			Status:    apicontracts.BatchStatusRegistered,
+			Address:   response.Address,
+			Addresses: response.Addresses,
+		}
+	}
+
+	for _, registration := range registrations {
+		registration.saga.Complete()
+	}
+
+	logger.Log.Infof("Batch of %d addresses registered successfully", len(requests))
+	return apicontracts.IpamAPIBatchResponse{
+		Message: "Addresses registered successfully",
+		Results: results,
+	}, nil
+}
+
+// registerBatchItem registers the request in a saga of its own, which records every step as it completes.
+// If the registration fails, its completed steps are undone. Otherwise the saga stays open until the batch completes.
+func registerBatchItem(ctx context.Context, index int, request apicontracts.IpamAPIRequest, containers *containerCache) (batchRegistration, apicontracts.IpamAPIResponse, error) {
+	registration := batchRegistration{
+		index: index,
+		saga:  sagaservice.New(ctx, fmt.Sprintf("register batch item %d for %s", index, request.Service.ServiceName)),
+	}
+
+	response, err := registerAddress(ctx, registration.saga, request, containers)
+	if err != nil {
+		if rollbackErr := registration.saga.Abort(err); rollbackErr != nil {
+			err = fmt.Errorf("%w (rollback failed and will be retried: %v)", err, rollbackErr)
+		}
+		return batchRegistration{}, apicontracts.IpamAPIResponse{}, err
+	}
+
+	return registration, response, nil
+}
+
+// rollbackBatch rolls back the registrations in reverse order and updates their results.
+// Rollback steps that fail are retried in the background.
+func rollbackBatch(registrations []batchRegistration, results []apicontracts.IpamAPIBatchItemResult, cause error) error {
+	var rollbackErrors []error
+
+	for i := len(registrations) - 1; i >= 0; i-- {
+		registration := registrations[i]
+
+		result := &results[registration.index]
+		if err := registration.saga.Abort(cause); err != nil {
+			logger.Log.Errorf("Failed to roll back batch item %d: %v", registration.index, err)
+			result.Status = apicontracts.BatchStatusFailed
+			result.Error = "rollback failed and will be retried: " + err.Error()
+			rollbackErrors = append(rollbackErrors, fmt.Errorf("rollback of batch item %d failed: %w", registration.index, err))
+			continue
+		}
+
+		result.Status = apicontracts.BatchStatusRolledBack
+	}
+
+	return errors.Join(rollbackErrors...)
+}
@@ -0,0 +1,134 @@
+package addressesservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/metrics"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
+	"github.com/vitistack/ipam-api/internal/utils"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+)
+
+var (
+	// ErrQuotaExceeded is returned when a registration would exceed the address quota of a cluster or
+	// namespace.
+	ErrQuotaExceeded = errors.New("address quota exceeded")
+	// ErrPrefixTooLarge is returned when a cluster requests a larger IPv6 prefix than its quota allows.
+	ErrPrefixTooLarge = errors.New("requested prefix is larger than allowed")
+)
+
+const (
+	// quotaLeaseTTL is how long the quota of a cluster or namespace stays locked by a registration that
+	// stopped renewing it without releasing it, for example because its instance stopped.
+	quotaLeaseTTL = 2 * time.Minute
+	// quotaLeaseWait is how long a registration waits for the registrations holding its quota.
+	quotaLeaseWait = 30 * time.Second
+)
+
+// reserveQuota locks the quotas of the cluster and namespace of the service of request in the zone and
+// checks that registering an address for the service stays within them. The quotas stay locked, across
+// instances, until the returned function is called, so that the address is stored before concurrent
+// registrations count the addresses of the cluster and namespace. Only configured quotas are locked.
+//
+// Parameters:
+//   - ctx: Context of the storage queries.
+//   - request: The registration of a service on an address it is not registered on yet. If request has an
+//     address, that address is not counted, since the service may join an address already counted.
+//
+// Returns:
+//   - func(): Unlocks the quotas. It must be called once the address is stored or the registration failed.
+//   - error: ErrPrefixTooLarge or ErrQuotaExceeded if the registration is over quota,
+//     storageservice.ErrLeaseBusy if the quotas stay locked by other registrations, or an error if the
+//     addresses cannot be counted.
+func reserveQuota(ctx context.Context, request apicontracts.IpamAPIRequest) (func(), error) {
+	quota := utils.AddressQuotaFor(request.Zone, request.Service.ClusterID)
+
+	// Leases are always taken cluster first, so registrations waiting for each other cannot deadlock
+	var leases []string
+	if quota.AddressesPerCluster > 0 {
+		leases = append(leases, "quota/"+request.Zone+"/cluster/"+request.Service.ClusterID)
+	}
+	if quota.AddressesPerNamespace > 0 {
+		leases = append(leases, "quota/"+request.Zone+"/namespace/"+request.Service.NamespaceID)
+	}
+
+	var releases []func()
+	release := func() {
+		for _, release := range slices.Backward(releases) {
+			release()
+		}
+	}
+	for _, name := range leases {
+		releaseLease, err := storageservice.AcquireLease(ctx, name, quotaLeaseTTL, quotaLeaseWait)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, releaseLease)
+	}
+
+	if err := checkQuota(ctx, request, quota); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// checkQuota checks that registering an address for the service of request stays within quota. The
+// quotas must be locked by the caller.
+func checkQuota(ctx context.Context, request apicontracts.IpamAPIRequest, quota utils.AddressQuota) error {
+
+	if quota.IPv6MinPrefixLength > 0 && request.IPFamily == "ipv6" {
+		prefixLength, err := requestedPrefixLength(request)
+		if err != nil {
+			return err
+		}
+		if prefixLength < quota.IPv6MinPrefixLength {
+			metrics.QuotaRejections.WithLabelValues(request.Zone, "ipv6_min_prefix_length").Inc()
+			return fmt.Errorf("%w: cluster %s may not register IPv6 prefixes larger than /%d, requested /%d",
+				ErrPrefixTooLarge, request.Service.ClusterID, quota.IPv6MinPrefixLength, prefixLength)
+		}
+	}
+
+	if quota.AddressesPerCluster > 0 {
+		count, err := storageservice.CountServiceAddresses(ctx, request.Zone, request.Service.ClusterID, "", request.Address, quota.AddressesPerCluster)
+		if err != nil {
+			return err
+		}
+		if count >= quota.AddressesPerCluster {
+			metrics.QuotaRejections.WithLabelValues(request.Zone, "addresses_per_cluster").Inc()
+			return fmt.Errorf("%w: cluster %s has %d addresses in zone %s, the limit is %d",
+				ErrQuotaExceeded, request.Service.ClusterID, count, request.Zone, quota.AddressesPerCluster)
+		}
+	}
+
+	if quota.AddressesPerNamespace > 0 {
+		count, err := storageservice.CountServiceAddresses(ctx, request.Zone, "", request.Service.NamespaceID, request.Address, quota.AddressesPerNamespace)
+		if err != nil {
+			return err
+		}
+		if count >= quota.AddressesPerNamespace {
+			metrics.QuotaRejections.WithLabelValues(request.Zone, "addresses_per_namespace").Inc()
+			return fmt.Errorf("%w: namespace %s has %d addresses in zone %s, the limit is %d",
+				ErrQuotaExceeded, request.Service.NamespaceID, count, request.Zone, quota.AddressesPerNamespace)
+		}
+	}
+
+	return nil
+}
+
+// requestedPrefixLength returns the prefix length of the requested address, the requested prefix length,
+// or the host prefix length when neither is given.
+func requestedPrefixLength(request apicontracts.IpamAPIRequest) (int, error) {
+	if request.Address != "" {
+		return utils.PrefixLength(request.Address)
+	}
+	if request.PrefixLength != 0 {
+		return request.PrefixLength, nil
+	}
+	return utils.HostPrefixLength(request.IPFamily), nil
+}
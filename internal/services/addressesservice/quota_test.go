@@ -0,0 +1,189 @@
+package addressesservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+)
+
+// setQuota configures a quota for the test and restores the previous value when the test ends.
+func setQuota(t *testing.T, key string, limit int) {
+	t.Helper()
+	previous := viper.Get(key)
+	t.Cleanup(func() { viper.Set(key, previous) })
+	viper.Set(key, limit)
+}
+
+// TestConcurrentRegistrationsStayWithinQuota registers more services of a cluster at the same time than its
+// quota allows. Every registration counts the addresses of the cluster before any of them is stored, so
+// without locking the quota all of them would pass the check.
+func TestConcurrentRegistrationsStayWithinQuota(t *testing.T) {
+	repo, fake := setup(t)
+	setQuota(t, "quotas.default.addresses_per_cluster", 3)
+	repo.onInsert = func(mongodbtypes.Address) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	const registrations = 8
+	errs := make([]error, registrations)
+	var wg sync.WaitGroup
+	for i := range registrations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = RegisterAddress(context.Background(), newRequest("ipv4", fmt.Sprintf("service%d", i)))
+		}()
+	}
+	wg.Wait()
+
+	registered := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			registered++
+		case !errors.Is(err, ErrQuotaExceeded):
+			t.Errorf("expected ErrQuotaExceeded, got %v", err)
+		}
+	}
+	if registered != 3 {
+		t.Errorf("expected 3 registrations within the quota, got %d", registered)
+	}
+	if addresses := storedAddresses(t, repo); len(addresses) != 3 {
+		t.Errorf("expected 3 stored addresses, got %d", len(addresses))
+	}
+	if prefixes := allocated(fake); len(prefixes) != 3 {
+		t.Errorf("expected 3 prefixes in Netbox, got %v", prefixes)
+	}
+}
+
+// TestConcurrentRegistrationsStayWithinNamespaceQuota does the same for the quota of a namespace, whose
+// services belong to different clusters.
+func TestConcurrentRegistrationsStayWithinNamespaceQuota(t *testing.T) {
+	repo, _ := setup(t)
+	setQuota(t, "quotas.default.addresses_per_namespace", 2)
+	repo.onInsert = func(mongodbtypes.Address) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request := newRequest("ipv4", fmt.Sprintf("service%d", i))
+			request.Service.ClusterID = fmt.Sprintf("cluster%d", i)
+			_, _ = RegisterAddress(context.Background(), request)
+		}()
+	}
+	wg.Wait()
+
+	if addresses := storedAddresses(t, repo); len(addresses) != 2 {
+		t.Errorf("expected 2 stored addresses, got %d", len(addresses))
+	}
+}
+
+// TestJoiningAnotherAddressChecksQuota checks that a registered service joining another existing address
+// counts against the quota, while a new service joining an address its cluster already has does not.
+func TestJoiningAnotherAddressChecksQuota(t *testing.T) {
+	repo, _ := setup(t)
+	setQuota(t, "quotas.default.addresses_per_cluster", 1)
+
+	other := newRequest("ipv4", "service1")
+	other.Service.ClusterID = "cluster2"
+	otherResponse, err := RegisterAddress(context.Background(), other)
+	if err != nil {
+		t.Fatalf("failed to register the address of cluster2: %v", err)
+	}
+	ownResponse, err := RegisterAddress(context.Background(), newRequest("ipv4", "service1"))
+	if err != nil {
+		t.Fatalf("failed to register the address of cluster1: %v", err)
+	}
+
+	join := newRequest("ipv4", "service1")
+	join.Address = otherResponse.Address
+	if _, err := RegisterAddress(context.Background(), join); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected joining a second address to exceed the quota, got %v", err)
+	}
+
+	share := newRequest("ipv4", "service2")
+	share.Address = ownResponse.Address
+	if _, err := RegisterAddress(context.Background(), share); err != nil {
+		t.Errorf("expected a service to join an address of its cluster within the quota, got %v", err)
+	}
+
+	if addresses := storedAddresses(t, repo); len(addresses) != 2 {
+		t.Errorf("expected 2 stored addresses, got %d", len(addresses))
+	}
+}
+
+func TestIPv6PrefixLengthQuota(t *testing.T) {
+	setup(t)
+	setQuota(t, "quotas.default.ipv6_min_prefix_length", 120)
+
+	request := newRequest("ipv6", "service1")
+	request.PrefixLength = 112
+	if _, err := RegisterAddress(context.Background(), request); !errors.Is(err, ErrPrefixTooLarge) {
+		t.Errorf("expected a /112 to be larger than allowed, got %v", err)
+	}
+
+	request.PrefixLength = 124
+	if _, err := RegisterAddress(context.Background(), request); err != nil {
+		t.Errorf("expected a /124 to be allowed, got %v", err)
+	}
+}
+
+// TestClusterQuotaOverridesDefault checks that the quota of a cluster replaces the default for that cluster only.
+func TestClusterQuotaOverridesDefault(t *testing.T) {
+	setup(t)
+	setQuota(t, "quotas.default.addresses_per_cluster", 1)
+	setQuota(t, "quotas.clusters.cluster1.addresses_per_cluster", 2)
+
+	for i := range 2 {
+		if _, err := RegisterAddress(context.Background(), newRequest("ipv4", fmt.Sprintf("service%d", i))); err != nil {
+			t.Fatalf("expected cluster1 to register %d addresses, got %v", i+1, err)
+		}
+	}
+	if _, err := RegisterAddress(context.Background(), newRequest("ipv4", "service2")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected the quota of cluster1 to be exceeded, got %v", err)
+	}
+
+	for i := range 2 {
+		request := newRequest("ipv4", fmt.Sprintf("service%d", i))
+		request.Service.ClusterID = "cluster2"
+		_, err := RegisterAddress(context.Background(), request)
+		if i == 0 && err != nil {
+			t.Errorf("expected cluster2 to register an address, got %v", err)
+		}
+		if i == 1 && !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("expected the default quota to apply to cluster2, got %v", err)
+		}
+	}
+}
+
+// TestRegistrationWithoutQuotaTakesNoLease checks that registrations of a cluster without address quotas do
+// not wait for each other.
+func TestRegistrationWithoutQuotaTakesNoLease(t *testing.T) {
+	setup(t)
+	setQuota(t, "quotas.clusters.cluster2.addresses_per_cluster", 1)
+
+	release, err := storageservice.AcquireLease(context.Background(), "quota/inet/cluster/cluster1", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("failed to acquire lease: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := RegisterAddress(ctx, newRequest("ipv4", "service1")); err != nil {
+		t.Errorf("expected cluster1 without a quota to register while its quota lease is held, got %v", err)
+	}
+}
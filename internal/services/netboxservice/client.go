@@ -0,0 +1,283 @@
+package netboxservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/vitistack/ipam-api/internal/responses"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+)
+
+// NetboxClient is the part of the Netbox REST API used by the IPAM-API. The functions of this package
+// call Netbox through the client set with InitClient or SetClient.
+type NetboxClient interface {
+	// ListPrefixes returns every prefix matching queryParams, following the pages of the response.
+	ListPrefixes(ctx context.Context, queryParams map[string]string) ([]responses.NetboxPrefix, error)
+	// GetPrefix returns the prefix with the given ID, or an error wrapping ErrPrefixNotFound.
+	GetPrefix(ctx context.Context, prefixID int) (responses.NetboxPrefix, error)
+	// CreatePrefix creates the prefix described by payload.
+	CreatePrefix(ctx context.Context, payload apicontracts.CreatePrefixPayload) (responses.NetboxPrefix, error)
+	// UpdatePrefix replaces the prefix with the given ID with payload.
+	UpdatePrefix(ctx context.Context, prefixID int, payload apicontracts.UpdatePrefixPayload) error
+	// DeletePrefix deletes the prefix with the given ID, or returns an error wrapping ErrPrefixNotFound.
+	DeletePrefix(ctx context.Context, prefixID int) error
+	// ListAvailablePrefixes returns the unallocated prefixes of a container.
+	ListAvailablePrefixes(ctx context.Context, containerID int) ([]responses.NetboxAvailablePrefix, error)
+	// CreateAvailablePrefix allocates the first available prefix of the requested length in a container.
+	CreateAvailablePrefix(ctx context.Context, containerID int, payload apicontracts.NextPrefixPayload) (responses.NetboxPrefix, error)
+	// ListTags returns every tag matching queryParams.
+	ListTags(ctx context.Context, queryParams map[string]string) ([]responses.NetboxTag, error)
+	// ListChoiceSets returns every custom field choice set matching queryParams.
+	ListChoiceSets(ctx context.Context, queryParams map[string]string) ([]responses.NetboxChoiceSet, error)
+	// Ping returns nil if Netbox answers API requests.
+	Ping(ctx context.Context) error
+}
+
+// DefaultPageSize is the number of results requested per page when netbox.page_size is not configured.
+const DefaultPageSize = 100
+
+// DefaultTimeout is the timeout of a Netbox request when netbox.timeout is not configured.
+const DefaultTimeout = 30 * time.Second
+
+var client NetboxClient
+
+// InitClient configures the package to call the Netbox instance at netboxURL with netboxToken.
+// A pageSize or timeout of zero selects DefaultPageSize or DefaultTimeout.
+func InitClient(netboxURL, netboxToken string, pageSize int, timeout time.Duration) {
+	client = instrument(NewRestClient(netboxURL, netboxToken, pageSize, timeout))
+}
+
+// SetClient replaces the client used by the package, for example with an in-memory fake in tests.
+// The calls of the client are recorded in the Netbox metrics.
+func SetClient(netboxClient NetboxClient) {
+	client = instrument(netboxClient)
+}
+
+// GetClient returns the client used by the package.
+func GetClient() NetboxClient {
+	return client
+}
+
+// RestClient implements NetboxClient with one shared HTTP client for the Netbox REST API.
+type RestClient struct {
+	resty    *resty.Client
+	pageSize int
+}
+
+// NewRestClient returns a RestClient for the Netbox instance at netboxURL, authenticating with netboxToken.
+// A pageSize or timeout of zero selects DefaultPageSize or DefaultTimeout.
+func NewRestClient(netboxURL, netboxToken string, pageSize int, timeout time.Duration) *RestClient {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	// Every HTTP request, including each page of a list, gets a span and carries the W3C trace context
+	restyClient := resty.New().
+		SetTransport(otelhttp.NewTransport(http.DefaultTransport)).
+		SetBaseURL(strings.TrimSuffix(netboxURL, "/")).
+		SetHeader("Authorization", "Token "+netboxToken).
+		SetHeader("Accept", "application/json").
+		SetTimeout(timeout)
+
+	return &RestClient{resty: restyClient, pageSize: pageSize}
+}
+
+func (c *RestClient) ListPrefixes(ctx context.Context, queryParams map[string]string) ([]responses.NetboxPrefix, error) {
+	return listAll[responses.NetboxPrefix](ctx, c, "/api/ipam/prefixes/", queryParams)
+}
+
+func (c *RestClient) GetPrefix(ctx context.Context, prefixID int) (responses.NetboxPrefix, error) {
+	var result responses.NetboxPrefix
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		SetResult(&result).
+		Get("/api/ipam/prefixes/" + strconv.Itoa(prefixID) + "/")
+
+	if err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return responses.NetboxPrefix{}, fmt.Errorf("%w: %d", ErrPrefixNotFound, prefixID)
+	}
+
+	if resp.IsError() {
+		return responses.NetboxPrefix{}, errors.New(resp.String())
+	}
+
+	return result, nil
+}
+
+func (c *RestClient) CreatePrefix(ctx context.Context, payload apicontracts.CreatePrefixPayload) (responses.NetboxPrefix, error) {
+	var result responses.NetboxPrefix
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		SetBody(payload).
+		SetResult(&result).
+		Post("/api/ipam/prefixes/")
+
+	if err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+
+	if resp.IsError() {
+		return responses.NetboxPrefix{}, errors.New(resp.String())
+	}
+
+	return result, nil
+}
+
+func (c *RestClient) UpdatePrefix(ctx context.Context, prefixID int, payload apicontracts.UpdatePrefixPayload) error {
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		SetBody(payload).
+		Put("/api/ipam/prefixes/" + strconv.Itoa(prefixID) + "/")
+
+	if err != nil {
+		return err
+	}
+
+	if resp.IsError() {
+		return errors.New(resp.String())
+	}
+
+	return nil
+}
+
+func (c *RestClient) DeletePrefix(ctx context.Context, prefixID int) error {
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		Delete("/api/ipam/prefixes/" + strconv.Itoa(prefixID) + "/")
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %d", ErrPrefixNotFound, prefixID)
+	}
+
+	if resp.IsError() {
+		return errors.New(resp.String())
+	}
+
+	return nil
+}
+
+func (c *RestClient) ListAvailablePrefixes(ctx context.Context, containerID int) ([]responses.NetboxAvailablePrefix, error) {
+	var result []responses.NetboxAvailablePrefix
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		SetResult(&result).
+		Get("/api/ipam/prefixes/" + strconv.Itoa(containerID) + "/available-prefixes/")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.IsError() {
+		return nil, errors.New(resp.String())
+	}
+
+	return result, nil
+}
+
+func (c *RestClient) CreateAvailablePrefix(ctx context.Context, containerID int, payload apicontracts.NextPrefixPayload) (responses.NetboxPrefix, error) {
+	var result responses.NetboxPrefix
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		SetBody(payload).
+		SetResult(&result).
+		Post("/api/ipam/prefixes/" + strconv.Itoa(containerID) + "/available-prefixes/")
+
+	if err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+
+	if resp.IsError() {
+		return responses.NetboxPrefix{}, errors.New(resp.String())
+	}
+
+	return result, nil
+}
+
+func (c *RestClient) ListTags(ctx context.Context, queryParams map[string]string) ([]responses.NetboxTag, error) {
+	return listAll[responses.NetboxTag](ctx, c, "/api/extras/tags/", queryParams)
+}
+
+func (c *RestClient) ListChoiceSets(ctx context.Context, queryParams map[string]string) ([]responses.NetboxChoiceSet, error) {
+	return listAll[responses.NetboxChoiceSet](ctx, c, "/api/extras/custom-field-choice-sets/", queryParams)
+}
+
+func (c *RestClient) Ping(ctx context.Context) error {
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		SetQueryParam("limit", "1").
+		Get("/api/ipam/prefixes/")
+
+	if err != nil {
+		return err
+	}
+
+	if resp.IsError() {
+		return fmt.Errorf("netbox responded with status %d", resp.StatusCode())
+	}
+
+	return nil
+}
+
+// listAll retrieves every object of a Netbox list endpoint matching queryParams. It requests pages of
+// the configured page size and follows the Next link of each page until the last page.
+//
+// Parameters:
+//   - ctx: Context of the requests.
+//   - c: The client to send the requests with.
+//   - path: The path of the list endpoint, for example "/api/ipam/prefixes/".
+//   - queryParams: The filters to apply.
+//
+// Returns:
+//   - []T: The objects from all pages.
+//   - error: An error if a request fails or the API returns an error response.
+func listAll[T any](ctx context.Context, c *RestClient, path string, queryParams map[string]string) ([]T, error) {
+	var results []T
+
+	// The Next link of a page is an absolute URL that already carries the filters, limit and offset
+	request := c.resty.R().
+		SetQueryParams(queryParams).
+		SetQueryParam("limit", strconv.Itoa(c.pageSize))
+	url := path
+
+	for url != "" {
+		var page responses.NetboxResponse[T]
+		resp, err := request.
+			SetContext(ctx).
+			SetResult(&page).
+			Get(url)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.IsError() {
+			return nil, errors.New(resp.String())
+		}
+
+		results = append(results, page.Results...)
+
+		url = page.Next
+		request = c.resty.R()
+	}
+
+	return results, nil
+}
@@ -0,0 +1,162 @@
+package netboxservice
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/metrics"
+	"github.com/vitistack/ipam-api/internal/responses"
+	"github.com/vitistack/ipam-api/internal/tracing"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// instrumentedClient records every call of a NetboxClient in the Netbox metrics and as a span, and bounds
+// the calls in flight with the concurrency limit.
+type instrumentedClient struct {
+	next NetboxClient
+}
+
+// instrument returns netboxClient wrapped so that its calls are recorded in the Netbox metrics and traced.
+func instrument(netboxClient NetboxClient) NetboxClient {
+	if netboxClient == nil {
+		return nil
+	}
+	if _, ok := netboxClient.(instrumentedClient); ok {
+		return netboxClient
+	}
+	return instrumentedClient{next: netboxClient}
+}
+
+// record starts recording a call of operation. The returned function ends the recording with the error of
+// the call. A missing prefix is an answer of Netbox, not a failure of the call.
+func record(ctx context.Context, operation string) (context.Context, func(error)) {
+	started := time.Now()
+	ctx, span := tracing.StartClient(ctx, "netbox "+operation, attribute.String("netbox.operation", operation))
+
+	return ctx, func(err error) {
+		if errors.Is(err, ErrPrefixNotFound) {
+			err = nil
+		}
+		metrics.ObserveNetbox(operation, started, err)
+		tracing.End(span, err)
+	}
+}
+
+// startCall starts recording a call of operation and waits for a slot of the concurrency limit. The
+// returned function ends the recording and releases the slot. If no slot becomes free, the recording is
+// ended with the error, which is returned.
+func startCall(ctx context.Context, operation string) (context.Context, func(error), error) {
+	ctx, end := record(ctx, operation)
+
+	release, err := limiter.Load().acquire(ctx, operation)
+	if err != nil {
+		end(err)
+		return ctx, nil, err
+	}
+
+	return ctx, func(err error) {
+		release()
+		end(err)
+	}, nil
+}
+
+func (c instrumentedClient) ListPrefixes(ctx context.Context, queryParams map[string]string) ([]responses.NetboxPrefix, error) {
+	ctx, end, err := startCall(ctx, "ListPrefixes")
+	if err != nil {
+		return nil, err
+	}
+	prefixes, err := c.next.ListPrefixes(ctx, queryParams)
+	end(err)
+	return prefixes, err
+}
+
+func (c instrumentedClient) GetPrefix(ctx context.Context, prefixID int) (responses.NetboxPrefix, error) {
+	ctx, end, err := startCall(ctx, "GetPrefix")
+	if err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+	prefix, err := c.next.GetPrefix(ctx, prefixID)
+	end(err)
+	return prefix, err
+}
+
+func (c instrumentedClient) CreatePrefix(ctx context.Context, payload apicontracts.CreatePrefixPayload) (responses.NetboxPrefix, error) {
+	ctx, end, err := startCall(ctx, "CreatePrefix")
+	if err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+	prefix, err := c.next.CreatePrefix(ctx, payload)
+	end(err)
+	return prefix, err
+}
+
+func (c instrumentedClient) UpdatePrefix(ctx context.Context, prefixID int, payload apicontracts.UpdatePrefixPayload) error {
+	ctx, end, err := startCall(ctx, "UpdatePrefix")
+	if err != nil {
+		return err
+	}
+	err = c.next.UpdatePrefix(ctx, prefixID, payload)
+	end(err)
+	return err
+}
+
+func (c instrumentedClient) DeletePrefix(ctx context.Context, prefixID int) error {
+	ctx, end, err := startCall(ctx, "DeletePrefix")
+	if err != nil {
+		return err
+	}
+	err = c.next.DeletePrefix(ctx, prefixID)
+	end(err)
+	return err
+}
+
+func (c instrumentedClient) ListAvailablePrefixes(ctx context.Context, containerID int) ([]responses.NetboxAvailablePrefix, error) {
+	ctx, end, err := startCall(ctx, "ListAvailablePrefixes")
+	if err != nil {
+		return nil, err
+	}
+	available, err := c.next.ListAvailablePrefixes(ctx, containerID)
+	end(err)
+	return available, err
+}
+
+func (c instrumentedClient) CreateAvailablePrefix(ctx context.Context, containerID int, payload apicontracts.NextPrefixPayload) (responses.NetboxPrefix, error) {
+	ctx, end, err := startCall(ctx, "CreateAvailablePrefix")
+	if err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+	prefix, err := c.next.CreateAvailablePrefix(ctx, containerID, payload)
+	end(err)
+	return prefix, err
+}
+
+func (c instrumentedClient) ListTags(ctx context.Context, queryParams map[string]string) ([]responses.NetboxTag, error) {
+	ctx, end, err := startCall(ctx, "ListTags")
+	if err != nil {
+		return nil, err
+	}
+	tags, err := c.next.ListTags(ctx, queryParams)
+	end(err)
+	return tags, err
+}
+
+func (c instrumentedClient) ListChoiceSets(ctx context.Context, queryParams map[string]string) ([]responses.NetboxChoiceSet, error) {
+	ctx, end, err := startCall(ctx, "ListChoiceSets")
+	if err != nil {
+		return nil, err
+	}
+	choiceSets, err := c.next.ListChoiceSets(ctx, queryParams)
+	end(err)
+	return choiceSets, err
+}
+
+// Ping does not wait for the concurrency limit, so the readiness probe does not fail while the IPAM-API
+// itself keeps Netbox busy.
+func (c instrumentedClient) Ping(ctx context.Context) error {
+	ctx, end := record(ctx, "Ping")
+	err := c.next.Ping(ctx)
+	end(err)
+	return err
+}
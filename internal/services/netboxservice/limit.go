@@ -0,0 +1,88 @@
+package netboxservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/metrics"
+)
+
+// DefaultMaxConcurrentRequests is the number of Netbox calls that may run at the same time when
+// netbox.max_concurrent_requests is not configured.
+const DefaultMaxConcurrentRequests = 10
+
+// DefaultMaxQueueWait is how long a Netbox call waits for a free slot when netbox.max_queue_wait is not
+// configured.
+const DefaultMaxQueueWait = 10 * time.Second
+
+// ErrNetboxBusy is returned when a Netbox call did not get a slot within the maximum queue wait.
+var ErrNetboxBusy = errors.New("too many concurrent Netbox requests")
+
+// callLimiter bounds the number of Netbox calls in flight, so a burst of API requests queues up in the
+// IPAM-API instead of overloading Netbox.
+type callLimiter struct {
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// limiter is the limiter of the package. It is replaced atomically, calls holding a slot of the previous
+// limiter release it there.
+var limiter atomic.Pointer[callLimiter]
+
+func init() {
+	limiter.Store(newCallLimiter(DefaultMaxConcurrentRequests, DefaultMaxQueueWait))
+}
+
+func newCallLimiter(maxConcurrent int, maxWait time.Duration) *callLimiter {
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxConcurrentRequests
+	}
+	if maxWait <= 0 {
+		maxWait = DefaultMaxQueueWait
+	}
+	return &callLimiter{slots: make(chan struct{}, maxConcurrent), maxWait: maxWait}
+}
+
+// SetConcurrencyLimit limits the Netbox calls of the package, across every request and worker.
+// A maxConcurrent or maxWait of zero selects DefaultMaxConcurrentRequests or DefaultMaxQueueWait.
+// It is safe to call while calls are running; calls that already hold a slot keep it until they finish.
+//
+// Parameters:
+//   - maxConcurrent: The number of calls that may run at the same time.
+//   - maxWait: How long a call waits for a free slot before failing with ErrNetboxBusy.
+func SetConcurrencyLimit(maxConcurrent int, maxWait time.Duration) {
+	limiter.Store(newCallLimiter(maxConcurrent, maxWait))
+}
+
+// acquire waits for a free slot. The returned function releases the slot.
+//
+// Parameters:
+//   - ctx: Context of the call. Waiting stops when it is done.
+//   - operation: The name of the call, for the error message.
+//
+// Returns:
+//   - func(): Releases the slot.
+//   - error: ErrNetboxBusy if no slot was free within maxWait, or the error of ctx.
+func (l *callLimiter) acquire(ctx context.Context, operation string) (func(), error) {
+	started := time.Now()
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		metrics.NetboxQueueWait.Observe(time.Since(started).Seconds())
+		metrics.NetboxRequestsInFlight.Inc()
+		return func() {
+			metrics.NetboxRequestsInFlight.Dec()
+			<-l.slots
+		}, nil
+	case <-timer.C:
+		metrics.NetboxQueueWait.Observe(time.Since(started).Seconds())
+		return nil, fmt.Errorf("%w: %s waited %s for one of %d slots", ErrNetboxBusy, operation, l.maxWait, cap(l.slots))
+	case <-ctx.Done():
+		return nil, fmt.Errorf("netbox %s: %w", operation, ctx.Err())
+	}
+}
@@ -0,0 +1,57 @@
+package netboxservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallLimiterFailsWhenBusy(t *testing.T) {
+	l := newCallLimiter(1, 10*time.Millisecond)
+
+	release, err := l.acquire(context.Background(), "ListPrefixes")
+	if err != nil {
+		t.Fatalf("expected a free slot, got %v", err)
+	}
+
+	if _, err := l.acquire(context.Background(), "ListPrefixes"); !errors.Is(err, ErrNetboxBusy) {
+		t.Fatalf("expected ErrNetboxBusy while the only slot is taken, got %v", err)
+	}
+
+	release()
+	release, err = l.acquire(context.Background(), "ListPrefixes")
+	if err != nil {
+		t.Fatalf("expected the released slot to be free, got %v", err)
+	}
+	release()
+}
+
+// TestSetConcurrencyLimitWhileCalling replaces the limit while calls hold and release slots. Run with -race.
+func TestSetConcurrencyLimitWhileCalling(t *testing.T) {
+	t.Cleanup(func() { SetConcurrencyLimit(DefaultMaxConcurrentRequests, DefaultMaxQueueWait) })
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			release, err := limiter.Load().acquire(context.Background(), "ListPrefixes")
+			if err != nil {
+				t.Errorf("failed to acquire a slot: %v", err)
+				return
+			}
+			release()
+		}()
+		go func() {
+			defer wg.Done()
+			SetConcurrencyLimit(5, time.Second)
+		}()
+	}
+	wg.Wait()
+
+	if got := cap(limiter.Load().slots); got != 5 {
+		t.Errorf("expected 5 slots, got %d", got)
+	}
+}
@@ -1,324 +1,278 @@
 package netboxservice
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/spf13/viper"
 
 	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/metrics"
 	"github.com/vitistack/ipam-api/internal/responses"
 	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
 )
 
 type NetboxCache struct {
-	mu       sync.RWMutex
-	prefixes map[string][]responses.NetboxPrefix
+	mu        sync.RWMutex
+	prefixes  map[string][]responses.NetboxPrefix
+	fetchedAt time.Time
 }
 
-var Cache = &NetboxCache{
-	prefixes: make(map[string][]responses.NetboxPrefix),
+var Cache = NewNetboxCache()
+
+// NewNetboxCache returns an empty cache of prefix containers.
+func NewNetboxCache() *NetboxCache {
+	return &NetboxCache{prefixes: make(map[string][]responses.NetboxPrefix)}
 }
 
-// GetPrefixContainer retrieves a Netbox prefix container matching the specified prefix string.
-// It sends a GET request to the Netbox API using the configured URL and token, filtering by the given prefix and
-// requiring the status to be "container". If exactly one matching container is found, it is returned.
+// ErrPrefixNotFound is returned when Netbox has no prefix with the given ID.
+var ErrPrefixNotFound = errors.New("prefix not found in Netbox")
+
+// GetPrefixContainer retrieves a Netbox prefix container matching the specified query parameters.
+// If exactly one matching container is found, it is returned.
 // Returns an error if the request fails, if the response indicates an error, or if multiple or no containers are found.
 //
 // Parameters:
-//   - prefix: The prefix string to search for.
+//   - ctx: Context of the Netbox requests.
+//   - queryParams: The filters identifying the container.
 //
 // Returns:
 //   - responses.NetboxPrefix: The matching Netbox prefix container.
 //   - error: An error if the request fails or if the result is not exactly one container.
-func GetPrefixContainer(queryParams map[string]string) (responses.NetboxPrefix, error) {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var result responses.NetboxResponse[responses.NetboxPrefix]
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetQueryParams(queryParams).
-		SetResult(&result).
-		Get(netboxURL + "/api/ipam/prefixes/")
-
+func GetPrefixContainer(ctx context.Context, queryParams map[string]string) (responses.NetboxPrefix, error) {
+	prefixes, err := client.ListPrefixes(ctx, queryParams)
 	if err != nil {
 		return responses.NetboxPrefix{}, err
 	}
 
-	if resp.IsError() {
-		return responses.NetboxPrefix{}, err
-	}
-
-	// rJson, _ := json.MarshalIndent(result, "", "  ")
-
-	// fmt.Println("GetPrefixContainer response:", string(rJson))
-	fmt.Println("GetPrefixContainer queryParams:", queryParams)
-	if len(result.Results) != 1 {
+	if len(prefixes) != 1 {
 		return responses.NetboxPrefix{}, errors.New("multiple or no containers matching prefix found")
 	}
 
-	container := result.Results[0]
-
-	return container, nil
+	return prefixes[0], nil
 }
 
-// GetPrefixes retrieves a list of Netbox prefixes based on the provided query parameters.
-// It sends a GET request to the Netbox API using the configured URL and token,
-// and returns a slice of NetboxPrefix objects or an error if the request fails.
+// GetPrefixes retrieves all Netbox prefixes matching the provided query parameters, following every page
+// of the response, and returns a slice of NetboxPrefix objects or an error if a request fails.
 //
 // Parameters:
+//   - ctx: Context of the Netbox requests.
 //   - queryParams: map[string]string of query parameters to append to the Netbox prefixes API endpoint.
 //
 // Returns:
 //   - []responses.NetboxPrefix: A slice of NetboxPrefix objects returned by the Netbox API.
 //   - error: An error if the request fails or the API returns an error response.
-func GetPrefixes(queryParams map[string]string) ([]responses.NetboxPrefix, error) {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var netboxResponse responses.NetboxResponse[responses.NetboxPrefix]
+func GetPrefixes(ctx context.Context, queryParams map[string]string) ([]responses.NetboxPrefix, error) {
+	return client.ListPrefixes(ctx, queryParams)
+}
 
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetResult(&netboxResponse).
-		SetQueryParams(queryParams).
-		Get(netboxURL + "/api/ipam/prefixes/")
+// GetPrefix retrieves the Netbox prefix with the given ID.
+//
+// Parameters:
+//   - ctx: Context of the Netbox request.
+//   - prefixID: The ID of the prefix.
+//
+// Returns:
+//   - responses.NetboxPrefix: The prefix returned by the Netbox API.
+//   - error: An error wrapping ErrPrefixNotFound if the prefix does not exist, or an error if the request fails.
+func GetPrefix(ctx context.Context, prefixID int) (responses.NetboxPrefix, error) {
+	return client.GetPrefix(ctx, prefixID)
+}
 
-	if err != nil {
-		return []responses.NetboxPrefix{}, err
+// ListManagedPrefixes retrieves every Netbox prefix managed by the IPAM-API: prefixes carrying the
+// constraint tag (if one is configured) and prefixes with the k8s_uuid custom field set.
+// Prefixes matching both are returned once.
+//
+// Parameters:
+//   - ctx: Context of the Netbox requests.
+//
+// Returns:
+//   - []responses.NetboxPrefix: The managed prefixes.
+//   - error: An error if a request fails or the API returns an error response.
+func ListManagedPrefixes(ctx context.Context) ([]responses.NetboxPrefix, error) {
+	queries := []map[string]string{{"cf_k8s_uuid__empty": "false"}}
+	if viper.IsSet("netbox.constraint_tag_id") {
+		queries = append(queries, map[string]string{"tag_id": strconv.Itoa(viper.GetInt("netbox.constraint_tag_id"))})
 	}
 
-	if resp.IsError() {
-		return []responses.NetboxPrefix{}, err
+	seen := make(map[int]bool)
+	var prefixes []responses.NetboxPrefix
+	for _, queryParams := range queries {
+		results, err := client.ListPrefixes(ctx, queryParams)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, prefix := range results {
+			if seen[prefix.ID] {
+				continue
+			}
+			seen[prefix.ID] = true
+			prefixes = append(prefixes, prefix)
+		}
 	}
 
-	return netboxResponse.Results, nil
+	return prefixes, nil
 }
 
 // CheckPrefixContainerAvailability queries the NetBox API to check for available prefixes
-// within a specified prefix container. It takes the containerId as a string and returns
-// the first available NetboxPrefix found, or an error if none are available or if the
-// request fails.
+// within a specified prefix container. It returns the first available prefix found,
+// or an error if none are available or if the request fails.
 //
 // Parameters:
-//   - containerId: The ID of the prefix container to check for available prefixes.
+//   - ctx: Context of the Netbox request.
+//   - containerID: The ID of the prefix container to check for available prefixes.
 //
 // Returns:
-//   - responses.NetboxPrefix: The first available prefix found in the container.
+//   - responses.NetboxAvailablePrefix: The first available prefix found in the container.
 //   - error: An error if the request fails or no prefixes are found.
-func CheckPrefixContainerAvailability(containerID string) (responses.NetboxPrefix, error) {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var result responses.NetboxResponse[responses.NetboxPrefix]
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetResult(&result).
-		Get(netboxURL + "/api/ipam/prefixes/" + containerID + "/available-prefixes/")
-
+func CheckPrefixContainerAvailability(ctx context.Context, containerID int) (responses.NetboxAvailablePrefix, error) {
+	available, err := client.ListAvailablePrefixes(ctx, containerID)
 	if err != nil {
-		return responses.NetboxPrefix{}, err
-	}
-
-	if resp.IsError() {
-		return responses.NetboxPrefix{}, err
+		return responses.NetboxAvailablePrefix{}, err
 	}
 
-	if len(result.Results) == 0 {
-		return responses.NetboxPrefix{}, errors.New("no prefixes found in the container")
+	if len(available) == 0 {
+		return responses.NetboxAvailablePrefix{}, errors.New("no prefixes found in the container")
 	}
 
-	return result.Results[0], nil
+	return available[0], nil
 }
 
-// Sends a POST request to the NetBox API to create a new available prefix within the specified container.
-// The request includes authorization and content headers, a payload as the request body, and stores the result in newPrefix.
+// GetNextPrefixFromContainer creates the next available prefix within the specified container.
+//
 // Parameters:
-//   - netboxToken: API token for NetBox authentication.
-//   - payload: The request body containing prefix details.
-//   - netboxURL: Base URL of the NetBox instance.
+//   - ctx: Context of the Netbox request.
 //   - containerID: Identifier of the prefix container in NetBox.
+//   - payload: The request body containing prefix details.
 //
 // Returns:
-//   - resp: The HTTP response from the NetBox API.
-//   - error: Any error encountered during the request (currently ignored in this snippet).
-func GetNextPrefixFromContainer(containerID string, payload apicontracts.NextPrefixPayload) (responses.NetboxPrefix, error) {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var newPrefix responses.NetboxPrefix
-	resp, _ := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetBody(payload).
-		SetResult(&newPrefix).
-		Post(netboxURL + "/api/ipam/prefixes/" + containerID + "/available-prefixes/")
-
-	if resp.IsError() {
-		logger.Log.Errorf("Error fetching next prefix from container %s: %s", containerID, resp.String())
-		return responses.NetboxPrefix{}, errors.New(resp.String())
+//   - responses.NetboxPrefix: The created prefix.
+//   - error: Any error returned by the NetBox API.
+func GetNextPrefixFromContainer(ctx context.Context, containerID int, payload apicontracts.NextPrefixPayload) (responses.NetboxPrefix, error) {
+	newPrefix, err := client.CreateAvailablePrefix(ctx, containerID, payload)
+	if err != nil {
+		logger.Log.Errorf("Error fetching next prefix from container %d: %v", containerID, err)
+		return responses.NetboxPrefix{}, err
 	}
 
 	return newPrefix, nil
 }
 
-// UpdateNetboxPrefix updates a prefix in Netbox with the specified prefixId using the provided payload.
-// It sends a PUT request to the Netbox API and returns an error if the request fails or if the response indicates an error.
+// UpdateNetboxPrefix updates a prefix in Netbox with the specified prefixID using the provided payload.
+// It returns an error if the request fails or if the response indicates an error.
 //
 // Parameters:
-//   - prefixId: The ID of the prefix to update in Netbox.
+//   - ctx: Context of the Netbox request.
+//   - prefixID: The ID of the prefix to update in Netbox.
 //   - payload: The data to update the prefix with, conforming to apicontracts.UpdatePrefixPayload.
 //
 // Returns:
 //   - error: An error if the update fails, or nil if successful.
-func UpdateNetboxPrefix(prefixID string, payload apicontracts.UpdatePrefixPayload) error {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var netboxResp responses.NetboxPrefix
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetBody(payload).
-		SetResult(&netboxResp).
-		Put(netboxURL + "/api/ipam/prefixes/" + prefixID + "/")
-
+func UpdateNetboxPrefix(ctx context.Context, prefixID int, payload apicontracts.UpdatePrefixPayload) error {
+	err := client.UpdatePrefix(ctx, prefixID, payload)
 	if err != nil {
+		logger.Log.Errorf("Error updating prefix %d in Netbox: %v", prefixID, err)
 		return err
 	}
-
-	if resp.IsError() {
-		logger.Log.Errorf("Error updating prefix %s in Netbox: %s", prefixID, resp.String())
-		return errors.New(resp.String())
-	}
 	return nil
 }
 
-// DeleteNetboxPrefix deletes a prefix in Netbox identified by the given prefixId.
-// It sends a DELETE request to the Netbox API using the configured URL and token.
-// Returns an error if the request fails or if Netbox responds with an error.
-func DeleteNetboxPrefix(prefixID string) error {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var netboxResp responses.NetboxPrefix
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetResult(&netboxResp).
-		Delete(netboxURL + "/api/ipam/prefixes/" + prefixID + "/")
-
-	if err != nil {
-		logger.Log.Errorf("Error deleting prefix %s in Netbox: %v", prefixID, err)
-		return err
-	}
-
-	if resp.IsError() {
-		logger.Log.Errorf("Error deleting prefix %s in Netbox: %s", prefixID, resp.String())
-		return errors.New(resp.String())
+// DeleteNetboxPrefix deletes a prefix in Netbox identified by the given prefixID.
+// Returns an error wrapping ErrPrefixNotFound if the prefix does not exist, or an error if the request fails
+// or if Netbox responds with an error.
+func DeleteNetboxPrefix(ctx context.Context, prefixID int) error {
+	err := client.DeletePrefix(ctx, prefixID)
+	if err != nil && !errors.Is(err, ErrPrefixNotFound) {
+		logger.Log.Errorf("Error deleting prefix %d in Netbox: %v", prefixID, err)
 	}
-	return nil
+	return err
 }
 
 // GetAvailablePrefixContainer attempts to find and return an available prefix container for the specified IPAM API request.
 // It determines the zone based on the request's zone and IP family, retrieves cached prefixes for that zone,
 // and queries the NetBox API for available prefixes within each cached prefix.
-// If an available prefix is found, it is returned; otherwise, an error is returned indicating no available prefix was found.
+// A container is only returned if one of its available prefixes is large enough for the requested prefix length
+// (a host prefix if none is requested); otherwise, an error is returned indicating no available prefix was found.
 //
 // Parameters:
+//   - ctx: Context of the Netbox requests.
 //   - request: apicontracts.IpamAPIRequest containing the zone and IP family information.
 //
 // Returns:
 //   - responses.NetboxPrefix: The first available prefix found for the specified zone.
 //   - error: An error if no available prefix is found or if an error occurs during the process.
-func GetAvailablePrefixContainer(request apicontracts.IpamAPIRequest) (responses.NetboxPrefix, error) {
+func GetAvailablePrefixContainer(ctx context.Context, request apicontracts.IpamAPIRequest) (responses.NetboxPrefix, error) {
 	zone := request.Zone + "_v" + string(request.IPFamily[len(request.IPFamily)-1])
 	zonePrefixes := Cache.Get(zone)
 
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-	restyClient := resty.New()
+	prefixLength := request.PrefixLength
+	if prefixLength == 0 {
+		prefixLength = 32
+		if request.IPFamily == "ipv6" {
+			prefixLength = 128
+		}
+	}
 
 	for _, prefix := range zonePrefixes {
-		var result []any
-		resp, err := restyClient.R().
-			SetHeader("Authorization", "Token "+netboxToken).
-			SetHeader("Accept", "application/json").
-			SetResult(&result).
-			Get(netboxURL + "/api/ipam/prefixes/" + strconv.Itoa(prefix.ID) + "/available-prefixes/")
-
+		result, err := client.ListAvailablePrefixes(ctx, prefix.ID)
 		if err != nil {
 			continue
 		}
 
-		if resp.IsError() {
-			continue
-		}
-
-		if len(result) == 0 {
-			continue
+		for _, available := range result {
+			_, availableNet, err := net.ParseCIDR(available.Prefix)
+			if err != nil {
+				continue
+			}
+			if availableLength, _ := availableNet.Mask.Size(); availableLength <= prefixLength {
+				return prefix, nil
+			}
 		}
-
-		return prefix, nil
 	}
-	logger.Log.Infof("No available prefix found for zone %s", zone)
-	return responses.NetboxPrefix{}, fmt.Errorf("no available prefix found for zone %s", zone)
+	logger.Log.Infof("No available /%d prefix found for zone %s", prefixLength, zone)
+	return responses.NetboxPrefix{}, fmt.Errorf("no available /%d prefix found for zone %s", prefixLength, zone)
 }
 
 // GetK8sZones retrieves the list of Kubernetes zones from the Netbox API.
-// It sends a GET request to the Netbox custom field choice sets endpoint, filtering for "k8s_zone_choices".
-// The function returns a slice of zone names as strings, or an error if the request fails or the response is invalid.
-func GetK8sZones() ([]string, error) {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var netboxResponse responses.NetboxResponse[responses.NetboxChoiceSet]
-
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetResult(&netboxResponse).
-		Get(netboxURL + "/api/extras/custom-field-choice-sets/?q=k8s_zone_choices")
+// It lists the Netbox custom field choice sets matching "k8s_zone_choices" and reads the zones from the choice set
+// with that name. The function returns a slice of zone names as strings, or an error if the request fails or the
+// choice set does not exist.
+func GetK8sZones(ctx context.Context) ([]string, error) {
+	choiceSets, err := client.ListChoiceSets(ctx, map[string]string{"q": "k8s_zone_choices"})
 
 	if err != nil {
 		logger.Log.Errorf("Error fetching k8s zones from Netbox: %v", err)
 		return nil, err
 	}
 
-	if resp.IsError() {
-		logger.Log.Errorf("Error fetching k8s zones from Netbox: %s", resp.String())
-		return nil, errors.New(resp.String())
-	}
-
-	var zones []string
+	for _, choiceSet := range choiceSets {
+		if choiceSet.Name != "k8s_zone_choices" {
+			continue
+		}
 
-	for _, choice := range netboxResponse.Results[0].ExtraChoices {
-		zones = append(zones, choice[0])
+		var zones []string
+		for _, choice := range choiceSet.ExtraChoices {
+			zones = append(zones, choice[0])
+		}
+		return zones, nil
 	}
 
-	return zones, nil
+	return nil, errors.New("custom field choice set k8s_zone_choices not found in Netbox")
 }
 
 // FetchPrefixContainers retrieves Kubernetes zones from Netbox, fetches associated IPv4 and IPv6 prefixes for each zone,
 // and updates the NetboxCache with the collected prefix data. It organizes prefixes by zone and IP family (IPv4/IPv6).
+// The free and used addresses of the containers are updated in the pool metrics.
 // Returns an error if fetching zones or prefixes fails.
-func (c *NetboxCache) FetchPrefixContainers() error {
-	zones, err := GetK8sZones()
+func (c *NetboxCache) FetchPrefixContainers(ctx context.Context) error {
+	zones, err := GetK8sZones(ctx)
 	if err != nil {
 		return errors.New("failed to fetch zones from Netbox: " + err.Error())
 	}
@@ -335,7 +289,7 @@ func (c *NetboxCache) FetchPrefixContainers() error {
 			"cf_k8s_zone": zone,
 			"status":      "container"}
 
-		prefixes, err := GetPrefixes(queryParams)
+		prefixes, err := GetPrefixes(ctx, queryParams)
 		if err != nil {
 			logger.Log.Errorf("Error fetching prefixes for zone %s: %v", zone, err)
 			return fmt.Errorf("error fetching prefixes for zone %s: %v", zone, err)
@@ -351,12 +305,68 @@ func (c *NetboxCache) FetchPrefixContainers() error {
 		}
 	}
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	c.prefixes = zonePrefixes
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+
+	updatePoolMetrics(ctx, zones, zonePrefixes)
 	return nil
 }
 
+// poolUsage is the number of free and used addresses of a prefix container.
+type poolUsage struct {
+	zone, ipFamily, container string
+	free, used                float64
+}
+
+// updatePoolMetrics sets the free and used addresses of the cached prefix containers of zones from the
+// available prefixes Netbox reports for them. A container whose available prefixes cannot be read is left
+// out until the next refresh.
+func updatePoolMetrics(ctx context.Context, zones []string, zonePrefixes map[string][]responses.NetboxPrefix) {
+	var usages []poolUsage
+	for _, zone := range zones {
+		for _, ipFamily := range []string{"ipv4", "ipv6"} {
+			for _, container := range zonePrefixes[zone+"_v"+ipFamily[len(ipFamily)-1:]] {
+				size, err := addressCount(container.Prefix)
+				if err != nil {
+					continue
+				}
+
+				available, err := client.ListAvailablePrefixes(ctx, container.ID)
+				if err != nil {
+					logger.Log.Errorf("Error fetching available prefixes of container %s: %v", container.Prefix, err)
+					continue
+				}
+
+				free := 0.0
+				for _, prefix := range available {
+					if count, err := addressCount(prefix.Prefix); err == nil {
+						free += count
+					}
+				}
+				usages = append(usages, poolUsage{zone: zone, ipFamily: ipFamily, container: container.Prefix, free: free, used: size - free})
+			}
+		}
+	}
+
+	// Containers removed from Netbox disappear from the metrics
+	metrics.PoolAddresses.Reset()
+	for _, usage := range usages {
+		metrics.PoolAddresses.WithLabelValues(usage.zone, usage.ipFamily, usage.container, metrics.StateFree).Set(usage.free)
+		metrics.PoolAddresses.WithLabelValues(usage.zone, usage.ipFamily, usage.container, metrics.StateUsed).Set(usage.used)
+	}
+}
+
+// addressCount returns the number of addresses in a prefix in CIDR notation.
+func addressCount(prefix string) (float64, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return 0, err
+	}
+	ones, bits := network.Mask.Size()
+	return math.Ldexp(1, bits-ones), nil
+}
+
 // Get returns prefixes for a given zone
 func (c *NetboxCache) Get(key string) []responses.NetboxPrefix {
 	c.mu.RLock()
@@ -364,130 +374,111 @@ func (c *NetboxCache) Get(key string) []responses.NetboxPrefix {
 	return c.prefixes[key]
 }
 
-// WaitForNetbox continuously attempts to connect to the NetBox API using the configured URL and token.
-// It sends a GET request to the /api/ipam/prefixes/ endpoint, retrying every 10 seconds until a successful response is received.
-// If an error occurs or a non-successful status code is returned, it logs the issue and retries.
-// The function returns nil once NetBox becomes available.
-func WaitForNetbox() error {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-	delay := 10 * time.Second
-	client := resty.New()
+// RefreshInterval is how often StartCacheRefresher fetches the prefix containers.
+const RefreshInterval = 10 * time.Minute
+
+// StartCacheRefresher fetches the prefix containers into c every RefreshInterval until ctx is done.
+// The containers are expected to have been fetched once before.
+func (c *NetboxCache) StartCacheRefresher(ctx context.Context) {
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
 
 	for {
-		resp, err := client.R().
-			SetHeader("Authorization", "Token "+netboxToken).
-			SetHeader("Accept", "application/json").
-			Get(netboxURL + "/api/ipam/prefixes/")
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Prefix container cache refresher stopped")
+			return
+		case <-ticker.C:
+		}
 
-		if err == nil && resp.IsSuccess() {
-			return nil
+		if err := c.FetchPrefixContainers(ctx); err != nil && ctx.Err() == nil {
+			logger.Log.Errorf("Failed to refresh prefix containers: %v", err)
 		}
+	}
+}
 
-		if err != nil {
-			logger.Log.Infof("Error reaching NetBox: %v. Retrying in %v...", err, delay)
-		} else {
-			logger.Log.Infof("Netbox responded with status %d. Retrying in %v...", resp.StatusCode(), delay)
+// FetchedAt returns the time the prefix containers were last fetched successfully, or the zero time if they
+// have not been fetched yet.
+func (c *NetboxCache) FetchedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.fetchedAt
+}
+
+// Ping checks that the Netbox API can be reached.
+func Ping(ctx context.Context) error {
+	return client.Ping(ctx)
+}
+
+// WaitForNetbox continuously attempts to reach the NetBox API, retrying every 10 seconds until a successful
+// response is received. If an error occurs or a non-successful status code is returned, it logs the issue and retries.
+// The function returns nil once NetBox becomes available, or the context error if ctx is done first.
+func WaitForNetbox(ctx context.Context) error {
+	delay := 10 * time.Second
+
+	for {
+		err := client.Ping(ctx)
+		if err == nil {
+			return nil
 		}
 
-		time.Sleep(delay)
+		logger.Log.Infof("Error reaching NetBox: %v. Retrying in %v...", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
 	}
 }
 
 // PrefixAvailable checks if a given IP prefix is available in NetBox.
 //
-// It sends a GET request to the NetBox API, querying for the specified prefix
-// within the "nhc" VRF. If no matching prefix is found in the response, the
-// function returns true, indicating the prefix is available. If the prefix
+// It queries the NetBox API for prefixes matching queryParams. If no matching prefix is found,
+// the function returns true, indicating the prefix is available. If the prefix
 // exists or an error occurs during the request, it returns false and an error.
 //
 // Parameters:
-//   - request: an IpamApiRequest containing the prefix address to check.
+//   - ctx: Context of the Netbox request.
+//   - queryParams: The filters identifying the prefix, typically the prefix and VRF.
 //
 // Returns:
 //   - bool: true if the prefix is available, false otherwise.
 //   - error: any error encountered during the API request or response handling.
-func PrefixAvailable(queryParams map[string]string) (bool, error) {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var result responses.NetboxResponse[responses.NetboxPrefix]
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetResult(&result).
-		SetQueryParams(queryParams).
-		Get(netboxURL + "/api/ipam/prefixes/")
-
+func PrefixAvailable(ctx context.Context, queryParams map[string]string) (bool, error) {
+	prefixes, err := client.ListPrefixes(ctx, queryParams)
 	if err != nil {
 		return false, err
 	}
 
-	if resp.IsError() {
-		return false, errors.New(resp.String())
-	}
-
-	if len(result.Results) == 0 {
-		return true, nil
-	}
-
-	return false, nil
+	return len(prefixes) == 0, nil
 }
 
 // RegisterPrefix sends a request to the NetBox API to create a new IP prefix using the provided payload.
 // It returns the created NetboxPrefix object on success, or an error if the request fails.
 //
 // Parameters:
+//   - ctx: Context of the Netbox request.
 //   - payload: apicontracts.CreatePrefixPayload containing the details of the prefix to be created.
 //
 // Returns:
 //   - responses.NetboxPrefix: The created prefix object returned by NetBox.
 //   - error: An error if the request fails or the API returns an error response.
-func RegisterPrefix(payload apicontracts.CreatePrefixPayload) (responses.NetboxPrefix, error) {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var result responses.NetboxPrefix
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetBody(payload).
-		SetResult(&result).
-		Post(netboxURL + "/api/ipam/prefixes/")
-
-	if err != nil {
-		return responses.NetboxPrefix{}, err
-	}
-
-	if resp.IsError() {
-		return responses.NetboxPrefix{}, errors.New(resp.String())
-	}
-
-	return result, nil
+func RegisterPrefix(ctx context.Context, payload apicontracts.CreatePrefixPayload) (responses.NetboxPrefix, error) {
+	return client.CreatePrefix(ctx, payload)
 }
 
-func GetTagID(tagName string) (int, error) {
-	netboxURL := viper.GetString("netbox.url")
-	netboxToken := viper.GetString("netbox.token")
-
-	restyClient := resty.New()
-	var result responses.NetboxResponse[responses.NetboxTag]
-	resp, err := restyClient.R().
-		SetHeader("Authorization", "Token "+netboxToken).
-		SetHeader("Accept", "application/json").
-		SetQueryParam("name", tagName).
-		SetResult(&result).
-		Get(netboxURL + "/api/extras/tags/")
-
+// GetTagID retrieves the ID of the Netbox tag with the given name.
+// Returns an error if the request fails or the tag does not exist.
+func GetTagID(ctx context.Context, tagName string) (int, error) {
+	tags, err := client.ListTags(ctx, map[string]string{"name": tagName})
 	if err != nil {
 		return 0, err
 	}
 
-	if resp.IsError() || len(result.Results) == 0 {
-		return 0, fmt.Errorf("%s", resp.String())
+	if len(tags) == 0 {
+		return 0, fmt.Errorf("tag %s not found in Netbox", tagName)
 	}
 
-	return result.Results[0].ID, nil
+	return tags[0].ID, nil
 }
@@ -0,0 +1,602 @@
+// Package netboxfake provides an in-memory implementation of netboxservice.NetboxClient for tests.
+//
+// The fake keeps prefixes, tags and custom field choice sets in memory and implements the parts of the
+// Netbox API used by the IPAM-API: prefix filters, available prefixes of a container and allocation of
+// the next available prefix. Prefixes are unique per VRF.
+//
+//	fake := netboxfake.New()
+//	fake.AddZone("inet")
+//	fake.AddContainer("10.0.0.0/24", "inet", 1)
+//	netboxservice.SetClient(fake)
+//
+// Tests that only need zone inet use Install instead.
+package netboxfake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/responses"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+)
+
+// Operations that can be made to fail with Fail.
+const (
+	OpListPrefixes          = "ListPrefixes"
+	OpGetPrefix             = "GetPrefix"
+	OpCreatePrefix          = "CreatePrefix"
+	OpUpdatePrefix          = "UpdatePrefix"
+	OpDeletePrefix          = "DeletePrefix"
+	OpListAvailablePrefixes = "ListAvailablePrefixes"
+	OpCreateAvailablePrefix = "CreateAvailablePrefix"
+	OpListTags              = "ListTags"
+	OpListChoiceSets        = "ListChoiceSets"
+	OpPing                  = "Ping"
+)
+
+// Prefix statuses.
+const (
+	StatusActive    = "active"
+	StatusContainer = "container"
+)
+
+const zoneChoiceSet = "k8s_zone_choices"
+
+// ErrInsufficientSpace is returned when a container has no available prefix of the requested length.
+var ErrInsufficientSpace = errors.New("insufficient space is available to accommodate the requested prefix size")
+
+type prefix struct {
+	prefix responses.NetboxPrefix
+	status string
+	tags   []int
+}
+
+// Fake is an in-memory Netbox. It is safe for concurrent use.
+type Fake struct {
+	mu         sync.Mutex
+	nextID     int
+	prefixes   map[int]*prefix
+	tags       []responses.NetboxTag
+	choiceSets []responses.NetboxChoiceSet
+	failures   map[string]error
+	calls      map[string]int
+}
+
+var _ netboxservice.NetboxClient = (*Fake)(nil)
+
+// New returns an empty fake with the k8s_zone_choices choice set and no zones.
+func New() *Fake {
+	return &Fake{
+		nextID:     1,
+		prefixes:   make(map[int]*prefix),
+		choiceSets: []responses.NetboxChoiceSet{{ID: 1, Name: zoneChoiceSet}},
+		failures:   make(map[string]error),
+		calls:      make(map[string]int),
+	}
+}
+
+// Install returns a fake with zone inet and the containers 10.0.0.0/24 and fd00::/64 in VRF 1, makes it the
+// Netbox client and caches its containers in a new netboxservice.Cache. The previous client and cache are
+// restored when the test ends.
+func Install(t testing.TB) *Fake {
+	t.Helper()
+
+	previousClient := netboxservice.GetClient()
+	previousCache := netboxservice.Cache
+	t.Cleanup(func() {
+		netboxservice.SetClient(previousClient)
+		netboxservice.Cache = previousCache
+	})
+
+	fake := New()
+	fake.AddZone("inet")
+	fake.AddContainer("10.0.0.0/24", "inet", 1)
+	fake.AddContainer("fd00::/64", "inet", 1)
+	netboxservice.SetClient(fake)
+
+	netboxservice.Cache = netboxservice.NewNetboxCache()
+	if err := netboxservice.Cache.FetchPrefixContainers(context.Background()); err != nil {
+		t.Fatalf("failed to cache prefix containers: %v", err)
+	}
+	return fake
+}
+
+// AddZone adds a zone to the k8s_zone_choices choice set.
+func (f *Fake) AddZone(zone string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.choiceSets {
+		if f.choiceSets[i].Name == zoneChoiceSet {
+			f.choiceSets[i].ExtraChoices = append(f.choiceSets[i].ExtraChoices, []string{zone, zone})
+			f.choiceSets[i].ChoicesCount = len(f.choiceSets[i].ExtraChoices)
+		}
+	}
+}
+
+// AddChoiceSet adds a custom field choice set.
+func (f *Fake) AddChoiceSet(name string, choices ...string) responses.NetboxChoiceSet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	choiceSet := responses.NetboxChoiceSet{ID: len(f.choiceSets) + 1, Name: name, ChoicesCount: len(choices)}
+	for _, choice := range choices {
+		choiceSet.ExtraChoices = append(choiceSet.ExtraChoices, []string{choice, choice})
+	}
+	f.choiceSets = append(f.choiceSets, choiceSet)
+	return choiceSet
+}
+
+// AddTag adds a tag.
+func (f *Fake) AddTag(name string) responses.NetboxTag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tag := responses.NetboxTag{ID: len(f.tags) + 1, Name: name, Slug: name}
+	f.tags = append(f.tags, tag)
+	return tag
+}
+
+// AddContainer adds a prefix with status container in the given zone and VRF. It panics if cidr is invalid.
+func (f *Fake) AddContainer(cidr, zone string, vrfID int) responses.NetboxPrefix {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	created := f.add(mustParsePrefix(cidr), vrfID, StatusContainer)
+	created.prefix.CustomFields.K8sZone = zone
+	return created.prefix
+}
+
+// AddPrefix adds an active prefix in the given VRF, for example to use up space in a container.
+// It panics if cidr is invalid.
+func (f *Fake) AddPrefix(cidr string, vrfID int) responses.NetboxPrefix {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.add(mustParsePrefix(cidr), vrfID, StatusActive).prefix
+}
+
+// SetCreated sets the creation time of a prefix, for example to make it older than the time the reconciler
+// gives allocations to save their document. It panics if the prefix does not exist.
+func (f *Fake) SetCreated(prefixID int, created time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, ok := f.prefixes[prefixID]
+	if !ok {
+		panic(fmt.Sprintf("netboxfake: no prefix %d", prefixID))
+	}
+	existing.prefix.Created = created
+}
+
+// Prefixes returns a copy of every prefix, ordered by ID.
+func (f *Fake) Prefixes() []responses.NetboxPrefix {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]responses.NetboxPrefix, 0, len(f.prefixes))
+	for _, id := range f.sortedIDs() {
+		result = append(result, f.prefixes[id].prefix)
+	}
+	return result
+}
+
+// Fail makes every call of the operation return err. A nil err makes the operation succeed again.
+func (f *Fake) Fail(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.failures, operation)
+		return
+	}
+	f.failures[operation] = err
+}
+
+// Calls returns how many times the operation has been called.
+func (f *Fake) Calls(operation string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[operation]
+}
+
+// call records a call of the operation and returns the injected failure, if any.
+// The caller must hold f.mu.
+func (f *Fake) call(operation string) error {
+	f.calls[operation]++
+	return f.failures[operation]
+}
+
+func (f *Fake) ListPrefixes(_ context.Context, queryParams map[string]string) ([]responses.NetboxPrefix, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpListPrefixes); err != nil {
+		return nil, err
+	}
+
+	var result []responses.NetboxPrefix
+	for _, id := range f.sortedIDs() {
+		matches, err := f.prefixes[id].matches(queryParams)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			result = append(result, f.prefixes[id].prefix)
+		}
+	}
+	return result, nil
+}
+
+func (f *Fake) GetPrefix(_ context.Context, prefixID int) (responses.NetboxPrefix, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpGetPrefix); err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+
+	existing, ok := f.prefixes[prefixID]
+	if !ok {
+		return responses.NetboxPrefix{}, fmt.Errorf("%w: %d", netboxservice.ErrPrefixNotFound, prefixID)
+	}
+	return existing.prefix, nil
+}
+
+func (f *Fake) CreatePrefix(_ context.Context, payload apicontracts.CreatePrefixPayload) (responses.NetboxPrefix, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpCreatePrefix); err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+
+	parsed, err := netip.ParsePrefix(payload.Prefix)
+	if err != nil {
+		return responses.NetboxPrefix{}, fmt.Errorf("invalid prefix %q: %w", payload.Prefix, err)
+	}
+	if f.exists(parsed.Masked(), payload.VrfID) {
+		return responses.NetboxPrefix{}, fmt.Errorf("duplicate prefix found in VRF %d: %s", payload.VrfID, parsed.Masked())
+	}
+
+	created := f.add(parsed.Masked(), payload.VrfID, StatusActive)
+	created.apply(payload.TenantID, payload.RoleID, payload.CustomFields, payload.Tags)
+	return created.prefix, nil
+}
+
+func (f *Fake) UpdatePrefix(_ context.Context, prefixID int, payload apicontracts.UpdatePrefixPayload) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpUpdatePrefix); err != nil {
+		return err
+	}
+
+	existing, ok := f.prefixes[prefixID]
+	if !ok {
+		return fmt.Errorf("%w: %d", netboxservice.ErrPrefixNotFound, prefixID)
+	}
+
+	if payload.Prefix != "" {
+		parsed, err := netip.ParsePrefix(payload.Prefix)
+		if err != nil {
+			return fmt.Errorf("invalid prefix %q: %w", payload.Prefix, err)
+		}
+		existing.prefix.Prefix = parsed.Masked().String()
+	}
+	existing.apply(existing.prefix.Tenant.ID, existing.prefix.Role.ID, payload.CustomFields, payload.Tags)
+	return nil
+}
+
+func (f *Fake) DeletePrefix(_ context.Context, prefixID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpDeletePrefix); err != nil {
+		return err
+	}
+
+	if _, ok := f.prefixes[prefixID]; !ok {
+		return fmt.Errorf("%w: %d", netboxservice.ErrPrefixNotFound, prefixID)
+	}
+	delete(f.prefixes, prefixID)
+	return nil
+}
+
+func (f *Fake) ListAvailablePrefixes(_ context.Context, containerID int) ([]responses.NetboxAvailablePrefix, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpListAvailablePrefixes); err != nil {
+		return nil, err
+	}
+
+	free, err := f.available(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]responses.NetboxAvailablePrefix, 0, len(free))
+	for _, block := range free {
+		result = append(result, responses.NetboxAvailablePrefix{Family: family(block), Prefix: block.String()})
+	}
+	return result, nil
+}
+
+func (f *Fake) CreateAvailablePrefix(_ context.Context, containerID int, payload apicontracts.NextPrefixPayload) (responses.NetboxPrefix, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpCreateAvailablePrefix); err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+
+	free, err := f.available(containerID)
+	if err != nil {
+		return responses.NetboxPrefix{}, err
+	}
+
+	for _, block := range free {
+		if block.Bits() > payload.PrefixLength || payload.PrefixLength > block.Addr().BitLen() {
+			continue
+		}
+
+		created := f.add(netip.PrefixFrom(block.Addr(), payload.PrefixLength), payload.VrfID, StatusActive)
+		created.apply(payload.TenantID, payload.RoleID, payload.CustomFields, payload.Tags)
+		return created.prefix, nil
+	}
+
+	return responses.NetboxPrefix{}, ErrInsufficientSpace
+}
+
+func (f *Fake) ListTags(_ context.Context, queryParams map[string]string) ([]responses.NetboxTag, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpListTags); err != nil {
+		return nil, err
+	}
+
+	var result []responses.NetboxTag
+	for _, tag := range f.tags {
+		matches := true
+		for key, value := range queryParams {
+			switch key {
+			case "name":
+				matches = matches && tag.Name == value
+			case "slug":
+				matches = matches && tag.Slug == value
+			case "limit", "offset":
+			default:
+				return nil, fmt.Errorf("netboxfake: unsupported tag filter %q", key)
+			}
+		}
+		if matches {
+			result = append(result, tag)
+		}
+	}
+	return result, nil
+}
+
+func (f *Fake) ListChoiceSets(_ context.Context, queryParams map[string]string) ([]responses.NetboxChoiceSet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpListChoiceSets); err != nil {
+		return nil, err
+	}
+
+	var result []responses.NetboxChoiceSet
+	for _, choiceSet := range f.choiceSets {
+		matches := true
+		for key, value := range queryParams {
+			switch key {
+			case "q", "name":
+				matches = matches && choiceSet.Name == value
+			case "limit", "offset":
+			default:
+				return nil, fmt.Errorf("netboxfake: unsupported choice set filter %q", key)
+			}
+		}
+		if matches {
+			result = append(result, choiceSet)
+		}
+	}
+	return result, nil
+}
+
+func (f *Fake) Ping(_ context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.call(OpPing)
+}
+
+// add stores a new prefix. The caller must hold f.mu.
+func (f *Fake) add(cidr netip.Prefix, vrfID int, status string) *prefix {
+	created := &prefix{status: status}
+	created.prefix.ID = f.nextID
+	created.prefix.Prefix = cidr.String()
+	created.prefix.Created = time.Now()
+	created.prefix.Family.Value = family(cidr)
+	created.prefix.Vrf.ID = vrfID
+
+	f.prefixes[created.prefix.ID] = created
+	f.nextID++
+	return created
+}
+
+// exists reports whether the prefix exists in the VRF. The caller must hold f.mu.
+func (f *Fake) exists(cidr netip.Prefix, vrfID int) bool {
+	for _, existing := range f.prefixes {
+		if existing.prefix.Vrf.ID == vrfID && existing.prefix.Prefix == cidr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// available returns the largest free blocks of a container, in address order. A block is free if no
+// other prefix in the VRF of the container overlaps it. The caller must hold f.mu.
+func (f *Fake) available(containerID int) ([]netip.Prefix, error) {
+	container, ok := f.prefixes[containerID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", netboxservice.ErrPrefixNotFound, containerID)
+	}
+	containerPrefix := mustParsePrefix(container.prefix.Prefix)
+
+	var used []netip.Prefix
+	for id, child := range f.prefixes {
+		if id == containerID || child.prefix.Vrf.ID != container.prefix.Vrf.ID {
+			continue
+		}
+		childPrefix := mustParsePrefix(child.prefix.Prefix)
+		if childPrefix.Bits() > containerPrefix.Bits() && containerPrefix.Contains(childPrefix.Addr()) {
+			used = append(used, childPrefix)
+		}
+	}
+	slices.SortFunc(used, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+
+	bitLen := containerPrefix.Addr().BitLen()
+	next, end := prefixRange(containerPrefix)
+	var free []netip.Prefix
+	for _, child := range used {
+		childStart, childEnd := prefixRange(child)
+		if childStart.Cmp(next) > 0 {
+			free = append(free, blocks(next, new(big.Int).Sub(childStart, big.NewInt(1)), bitLen)...)
+		}
+		if childEnd.Cmp(next) >= 0 {
+			next = new(big.Int).Add(childEnd, big.NewInt(1))
+		}
+	}
+	if next.Cmp(end) <= 0 {
+		free = append(free, blocks(next, end, bitLen)...)
+	}
+	return free, nil
+}
+
+// sortedIDs returns the prefix IDs in ascending order. The caller must hold f.mu.
+func (f *Fake) sortedIDs() []int {
+	ids := make([]int, 0, len(f.prefixes))
+	for id := range f.prefixes {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// matches reports whether the prefix matches every filter. Unsupported filters are an error, so a test
+// never passes because a filter was silently ignored.
+func (p *prefix) matches(queryParams map[string]string) (bool, error) {
+	for key, value := range queryParams {
+		switch key {
+		case "prefix":
+			parsed, err := netip.ParsePrefix(value)
+			if err != nil || parsed.Masked().String() != p.prefix.Prefix {
+				return false, nil
+			}
+		case "status":
+			if p.status != value {
+				return false, nil
+			}
+		case "vrf_id", "present_in_vrf_id":
+			if strconv.Itoa(p.prefix.Vrf.ID) != value {
+				return false, nil
+			}
+		case "tag_id":
+			tagID, err := strconv.Atoi(value)
+			if err != nil || !slices.Contains(p.tags, tagID) {
+				return false, nil
+			}
+		case "cf_k8s_zone":
+			if p.prefix.CustomFields.K8sZone != value {
+				return false, nil
+			}
+		case "cf_k8s_uuid":
+			if p.prefix.CustomFields.K8sUUID != value {
+				return false, nil
+			}
+		case "cf_k8s_uuid__empty":
+			if (p.prefix.CustomFields.K8sUUID == "") != (value == "true") {
+				return false, nil
+			}
+		case "limit", "offset":
+		default:
+			return false, fmt.Errorf("netboxfake: unsupported prefix filter %q", key)
+		}
+	}
+	return true, nil
+}
+
+// apply sets the tenant, role, custom fields and tags of the prefix from a create or update payload.
+func (p *prefix) apply(tenantID, roleID int, customFields apicontracts.CustomFields, tags []int) {
+	p.prefix.Tenant.ID = tenantID
+	p.prefix.Role.ID = roleID
+	p.prefix.CustomFields.Infra = customFields.Infra
+	p.prefix.CustomFields.K8sUUID = customFields.K8suuid
+	p.prefix.CustomFields.K8sZone = customFields.K8sZone
+	p.tags = slices.Clone(tags)
+}
+
+func mustParsePrefix(cidr string) netip.Prefix {
+	parsed, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		panic(fmt.Sprintf("netboxfake: invalid prefix %q: %v", cidr, err))
+	}
+	return parsed.Masked()
+}
+
+func family(cidr netip.Prefix) int {
+	if cidr.Addr().Is4() {
+		return 4
+	}
+	return 6
+}
+
+// prefixRange returns the first and last address of a prefix as integers.
+func prefixRange(cidr netip.Prefix) (*big.Int, *big.Int) {
+	start := new(big.Int).SetBytes(cidr.Addr().AsSlice())
+	hostBits := uint(cidr.Addr().BitLen() - cidr.Bits())
+	size := new(big.Int).Lsh(big.NewInt(1), hostBits)
+	end := new(big.Int).Sub(new(big.Int).Add(start, size), big.NewInt(1))
+	return start, end
+}
+
+// blocks splits the address range from start to end into the fewest aligned prefixes.
+func blocks(start, end *big.Int, bitLen int) []netip.Prefix {
+	var result []netip.Prefix
+	next := new(big.Int).Set(start)
+
+	for next.Cmp(end) <= 0 {
+		// The largest block starting at next is limited by the alignment of next and by the end of the range
+		hostBits := 0
+		for hostBits < bitLen && next.Bit(hostBits) == 0 {
+			blockEnd := new(big.Int).Add(next, new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(hostBits+1)), big.NewInt(1)))
+			if blockEnd.Cmp(end) > 0 {
+				break
+			}
+			hostBits++
+		}
+
+		result = append(result, netip.PrefixFrom(addrFromInt(next, bitLen), bitLen-hostBits))
+		next.Add(next, new(big.Int).Lsh(big.NewInt(1), uint(hostBits)))
+	}
+	return result
+}
+
+func addrFromInt(value *big.Int, bitLen int) netip.Addr {
+	bytes := make([]byte, bitLen/8)
+	value.FillBytes(bytes)
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
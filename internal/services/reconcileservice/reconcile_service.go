@@ -0,0 +1,372 @@
+package reconcileservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/responses"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/internal/services/sagaservice"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
+	"github.com/vitistack/ipam-api/internal/utils"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+)
+
+// Kinds of differences between Netbox and MongoDB.
+const (
+	// FindingNetboxOrphan is a managed Netbox prefix without an address document.
+	FindingNetboxOrphan = "netbox_orphan"
+	// FindingMongoOrphan is an address document whose Netbox prefix does not exist.
+	FindingMongoOrphan = "mongodb_orphan"
+	// FindingUUIDMismatch is a Netbox prefix whose k8s_uuid is not the ID of its address document.
+	FindingUUIDMismatch = "k8s_uuid_mismatch"
+	// FindingZoneMismatch is a Netbox prefix whose k8s_zone is not the zone of its address document.
+	FindingZoneMismatch = "zone_mismatch"
+	// FindingPrefixMismatch is a Netbox prefix that differs from the address of its address document.
+	FindingPrefixMismatch = "prefix_mismatch"
+)
+
+// repairLease is the lease held while a reconciliation repairs, so that the replicas of the API and the
+// CLI never repair at the same time and recreate the same prefix twice.
+const repairLease = "reconcile/repair"
+
+// repairLeaseTTL is how long the repair lease is held after its process stopped renewing it without
+// releasing it.
+const repairLeaseTTL = 15 * time.Minute
+
+// ErrRepairInProgress is returned by Reconcile when another reconciliation is repairing.
+var ErrRepairInProgress = errors.New("another reconciliation is repairing")
+
+// recentPrefixAge is how old a Netbox prefix without an address document must be before it is reported.
+// Younger prefixes may belong to an allocation that has not saved its document yet.
+const recentPrefixAge = 10 * time.Minute
+
+// Finding is a difference between a Netbox prefix and an address document.
+type Finding struct {
+	Kind        string `json:"kind"`
+	NetboxID    int    `json:"netbox_id,omitempty"`
+	AddressID   string `json:"address_id,omitempty"`
+	Address     string `json:"address"`
+	Detail      string `json:"detail"`
+	Repaired    bool   `json:"repaired"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// Report is the result of a reconciliation.
+type Report struct {
+	NetboxPrefixes int       `json:"netbox_prefixes"`
+	Addresses      int       `json:"addresses"`
+	Findings       []Finding `json:"findings"`
+}
+
+// Reconcile compares the Netbox prefixes managed by the IPAM-API with the address documents in MongoDB.
+// Prefixes and documents are matched on the Netbox ID stored in the document. Orphans in both directions
+// and prefixes whose k8s_uuid, k8s_zone or prefix differ from their document are reported.
+// Prefixes and documents referenced by allocation sagas are skipped, since the saga is responsible for them.
+//
+// With repair set, MongoDB is treated as the source of truth:
+//   - Orphaned Netbox prefixes are deleted.
+//   - Orphaned documents with services get their prefix recreated in Netbox, documents without services are deleted.
+//   - Mismatched k8s_uuid and k8s_zone are updated in Netbox. Mismatched prefixes are only reported.
+//
+// Only one reconciliation repairs at a time, across instances: a repairing reconciliation holds a lease in
+// the repository from before it reads Netbox and MongoDB until it is done.
+//
+// Parameters:
+//   - ctx: Context for the Netbox and MongoDB requests.
+//   - repair: Whether to repair the findings.
+//
+// Returns:
+//   - Report: The findings, with the outcome of every repair.
+//   - error: ErrRepairInProgress if repair is set and another reconciliation is repairing, or an error if
+//     Netbox or MongoDB cannot be read.
+func Reconcile(ctx context.Context, repair bool) (Report, error) {
+	if repair {
+		release, err := storageservice.AcquireLease(ctx, repairLease, repairLeaseTTL, 0)
+		if errors.Is(err, storageservice.ErrLeaseBusy) {
+			return Report{}, ErrRepairInProgress
+		}
+		if err != nil {
+			return Report{}, err
+		}
+		defer release()
+	}
+
+	prefixes, err := netboxservice.ListManagedPrefixes(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to list Netbox prefixes: %w", err)
+	}
+
+	addresses, err := storageservice.GetAllAddresses(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+
+	inSaga, err := sagaservice.NetboxIDsInUse(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{NetboxPrefixes: len(prefixes), Addresses: len(addresses)}
+
+	prefixesByID := make(map[int]responses.NetboxPrefix, len(prefixes))
+	for _, prefix := range prefixes {
+		prefixesByID[prefix.ID] = prefix
+	}
+
+	addressesByNetboxID := make(map[int]mongodbtypes.Address, len(addresses))
+	for _, address := range addresses {
+		addressesByNetboxID[address.NetboxID] = address
+		if inSaga[address.NetboxID] {
+			continue
+		}
+
+		prefix, ok := prefixesByID[address.NetboxID]
+		if !ok {
+			// The prefix may exist in Netbox without being recognizable as managed
+			prefix, err = netboxservice.GetPrefix(ctx, address.NetboxID)
+			if errors.Is(err, netboxservice.ErrPrefixNotFound) {
+				report.Findings = append(report.Findings, mongoOrphan(ctx, address, repair))
+				continue
+			}
+			if err != nil {
+				return Report{}, fmt.Errorf("failed to get Netbox prefix %d: %w", address.NetboxID, err)
+			}
+		}
+
+		report.Findings = append(report.Findings, compare(ctx, prefix, address, repair)...)
+	}
+
+	for _, prefix := range prefixes {
+		if _, ok := addressesByNetboxID[prefix.ID]; ok || inSaga[prefix.ID] {
+			continue
+		}
+		if time.Since(prefix.Created) < recentPrefixAge {
+			continue
+		}
+		report.Findings = append(report.Findings, netboxOrphan(ctx, prefix, repair))
+	}
+
+	return report, nil
+}
+
+// netboxOrphan reports a managed Netbox prefix without an address document and deletes it when repairing.
+func netboxOrphan(ctx context.Context, prefix responses.NetboxPrefix, repair bool) Finding {
+	finding := Finding{
+		Kind:      FindingNetboxOrphan,
+		NetboxID:  prefix.ID,
+		AddressID: prefix.CustomFields.K8sUUID,
+		Address:   prefix.Prefix,
+		Detail:    "no address document references this prefix",
+	}
+
+	if repair {
+		err := netboxservice.DeleteNetboxPrefix(ctx, prefix.ID)
+		if errors.Is(err, netboxservice.ErrPrefixNotFound) {
+			err = nil
+		}
+		setRepairResult(&finding, err)
+	}
+
+	return finding
+}
+
+// mongoOrphan reports an address document whose Netbox prefix does not exist. When repairing, the prefix
+// is recreated for a document that still has services, and a document without services is deleted.
+func mongoOrphan(ctx context.Context, address mongodbtypes.Address, repair bool) Finding {
+	finding := Finding{
+		Kind:      FindingMongoOrphan,
+		NetboxID:  address.NetboxID,
+		AddressID: address.ID.Hex(),
+		Address:   address.Address,
+		Detail:    fmt.Sprintf("Netbox prefix %d does not exist", address.NetboxID),
+	}
+
+	if repair {
+		if len(address.Services) == 0 {
+			setRepairResult(&finding, storageservice.DeleteAddress(ctx, address.ID))
+		} else {
+			setRepairResult(&finding, recreatePrefix(ctx, address))
+		}
+	}
+
+	return finding
+}
+
+// compare reports the differences between a Netbox prefix and its address document. When repairing,
+// k8s_uuid and k8s_zone are updated in Netbox.
+func compare(ctx context.Context, prefix responses.NetboxPrefix, address mongodbtypes.Address, repair bool) []Finding {
+	var findings []Finding
+
+	if prefix.CustomFields.K8sUUID != address.ID.Hex() {
+		findings = append(findings, Finding{
+			Kind:      FindingUUIDMismatch,
+			NetboxID:  prefix.ID,
+			AddressID: address.ID.Hex(),
+			Address:   address.Address,
+			Detail:    fmt.Sprintf("Netbox has k8s_uuid %q", prefix.CustomFields.K8sUUID),
+		})
+	}
+
+	if prefix.CustomFields.K8sZone != address.Zone {
+		findings = append(findings, Finding{
+			Kind:      FindingZoneMismatch,
+			NetboxID:  prefix.ID,
+			AddressID: address.ID.Hex(),
+			Address:   address.Address,
+			Detail:    fmt.Sprintf("Netbox has k8s_zone %q, MongoDB has %q", prefix.CustomFields.K8sZone, address.Zone),
+		})
+	}
+
+	if utils.NormalizeCIDR(prefix.Prefix) != utils.NormalizeCIDR(address.Address) {
+		findings = append(findings, Finding{
+			Kind:      FindingPrefixMismatch,
+			NetboxID:  prefix.ID,
+			AddressID: address.ID.Hex(),
+			Address:   address.Address,
+			Detail:    fmt.Sprintf("Netbox has prefix %s, repair manually", prefix.Prefix),
+		})
+	}
+
+	if !repair {
+		return findings
+	}
+
+	var err error
+	updated := false
+	for i := range findings {
+		if findings[i].Kind != FindingUUIDMismatch && findings[i].Kind != FindingZoneMismatch {
+			continue
+		}
+		if !updated {
+			payload := apicontracts.GetUpdatePrefixPayload(prefix, address, apicontracts.IpamAPIRequest{Zone: address.Zone})
+			err = netboxservice.UpdateNetboxPrefix(ctx, prefix.ID, payload)
+			updated = true
+		}
+		setRepairResult(&findings[i], err)
+	}
+
+	return findings
+}
+
+// recreatePrefix creates the prefix of an address document in Netbox again and stores the new Netbox ID
+// in the document. The prefix is not created if another prefix already uses the address.
+func recreatePrefix(ctx context.Context, address mongodbtypes.Address) error {
+	prefixLength, err := utils.PrefixLength(address.Address)
+	if err != nil {
+		return err
+	}
+
+	request := apicontracts.IpamAPIRequest{
+		Zone:         address.Zone,
+		IPFamily:     address.IPFamily,
+		Address:      address.Address,
+		PrefixLength: prefixLength,
+	}
+
+	container, err := netboxservice.GetAvailablePrefixContainer(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	available, err := netboxservice.PrefixAvailable(ctx, map[string]string{
+		"prefix":            address.Address,
+		"present_in_vrf_id": strconv.Itoa(container.Vrf.ID),
+	})
+	if err != nil {
+		return err
+	}
+	if !available {
+		return fmt.Errorf("%s is already used by another prefix in Netbox", address.Address)
+	}
+
+	prefix, err := netboxservice.RegisterPrefix(ctx, apicontracts.GetCreatePrefixPayload(request, container))
+	if err != nil {
+		return err
+	}
+
+	saga := sagaservice.New(ctx, "recreate "+address.Address)
+	saga.Record(sagaservice.DeleteNetboxPrefix(prefix.ID, prefix.Prefix))
+
+	err = netboxservice.UpdateNetboxPrefix(ctx, prefix.ID, apicontracts.GetUpdatePrefixPayload(prefix, address, request))
+	if err == nil {
+		err = storageservice.SetNetboxID(ctx, address.ID, prefix.ID)
+	}
+	if err != nil {
+		if rollbackErr := saga.Abort(err); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed and will be retried: %v)", err, rollbackErr)
+		}
+		return err
+	}
+
+	saga.Complete()
+	return nil
+}
+
+func setRepairResult(finding *Finding, err error) {
+	if err != nil {
+		finding.RepairError = err.Error()
+		return
+	}
+	finding.Repaired = true
+}
+
+// StartReconcileWorker reconciles Netbox and MongoDB at the interval configured in reconcile.interval
+// and logs the findings. Findings are repaired if reconcile.repair is set, by one replica at a time.
+// The worker does not run if the interval is zero. It stops when ctx is done, cancelling a running
+// reconciliation; the next start reconciles again.
+func StartReconcileWorker(ctx context.Context) {
+	interval := viper.GetDuration("reconcile.interval")
+	if interval <= 0 {
+		logger.Log.Info("Reconcile worker disabled")
+		return
+	}
+
+	repair := viper.GetBool("reconcile.repair")
+	logger.Log.Infof("Starting reconcile worker, interval %s, repair %t...", interval, repair)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Reconcile worker stopped")
+			return
+		case <-ticker.C:
+		}
+
+		passCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		report, err := Reconcile(passCtx, repair)
+		cancel()
+		if err != nil && ctx.Err() != nil {
+			continue // Stopped during the reconciliation
+		}
+		if errors.Is(err, ErrRepairInProgress) {
+			logger.Log.Info("Skipping reconciliation, another instance is repairing")
+			continue
+		}
+		if err != nil {
+			logger.Log.Errorf("Reconciliation failed: %v", err)
+			continue
+		}
+
+		for _, finding := range report.Findings {
+			switch {
+			case finding.Repaired:
+				logger.Log.Infof("Reconciliation repaired %s for %s (netbox_id %d): %s", finding.Kind, finding.Address, finding.NetboxID, finding.Detail)
+			case finding.RepairError != "":
+				logger.Log.Errorf("Reconciliation failed to repair %s for %s (netbox_id %d): %s", finding.Kind, finding.Address, finding.NetboxID, finding.RepairError)
+			default:
+				logger.Log.Warnf("Reconciliation found %s for %s (netbox_id %d): %s", finding.Kind, finding.Address, finding.NetboxID, finding.Detail)
+			}
+		}
+		logger.Log.Infof("Reconciled %d Netbox prefixes with %d addresses, %d findings",
+			report.NetboxPrefixes, report.Addresses, len(report.Findings))
+	}
+}
@@ -0,0 +1,224 @@
+package reconcileservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/responses"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice/netboxfake"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// setup installs a memory repository and a Netbox fake with containers in zone inet. The previous
+// repository and client are restored when the test ends.
+func setup(t *testing.T) (repository.Repository, *netboxfake.Fake) {
+	t.Helper()
+	logger.InitConsoleLogger()
+
+	previousRepository := repository.GetRepository()
+	t.Cleanup(func() { repository.SetRepository(previousRepository) })
+
+	repository.SetRepository(repository.NewMemoryRepository())
+	return repository.GetRepository(), netboxfake.Install(t)
+}
+
+// insertOrphan stores an address with a service whose Netbox prefix does not exist.
+func insertOrphan(t *testing.T, repo repository.Repository, address string) {
+	t.Helper()
+	_, err := repo.InsertAddress(context.Background(), mongodbtypes.Address{
+		Zone:     "inet",
+		IPFamily: "ipv4",
+		Address:  address,
+		NetboxID: 999,
+		Services: []mongodbtypes.Service{{ServiceName: "service1", NamespaceID: "namespace1", ClusterID: "cluster1"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert address: %v", err)
+	}
+}
+
+// TestRepairWaitsForTheRepairLease checks that a repairing reconciliation does nothing while another one
+// holds the repair lease, and that concurrent repairs recreate a missing prefix once.
+func TestRepairWaitsForTheRepairLease(t *testing.T) {
+	repo, fake := setup(t)
+	insertOrphan(t, repo, "10.0.0.5/32")
+
+	release, err := storageservice.AcquireLease(context.Background(), repairLease, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("failed to acquire the repair lease: %v", err)
+	}
+	if _, err := Reconcile(context.Background(), true); !errors.Is(err, ErrRepairInProgress) {
+		t.Fatalf("expected ErrRepairInProgress while the lease is held, got %v", err)
+	}
+	if calls := fake.Calls(netboxfake.OpCreatePrefix); calls != 0 {
+		t.Fatalf("expected no prefix to be created while the lease is held, got %d", calls)
+	}
+
+	if _, err := Reconcile(context.Background(), false); err != nil {
+		t.Errorf("expected a report without repairs to ignore the lease, got %v", err)
+	}
+	release()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Reconcile(context.Background(), true); err != nil && !errors.Is(err, ErrRepairInProgress) {
+				t.Errorf("failed to reconcile: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls := fake.Calls(netboxfake.OpCreatePrefix); calls != 1 {
+		t.Errorf("expected the missing prefix to be recreated once, got %d", calls)
+	}
+}
+
+// managedPrefix adds a Netbox prefix that references the address document with the given ID, created long
+// enough ago to be reported as an orphan.
+func managedPrefix(t *testing.T, fake *netboxfake.Fake, cidr, addressID string) responses.NetboxPrefix {
+	t.Helper()
+	prefix := fake.AddPrefix(cidr, 1)
+	err := fake.UpdatePrefix(context.Background(), prefix.ID, apicontracts.UpdatePrefixPayload{
+		CustomFields: apicontracts.CustomFields{K8suuid: addressID, K8sZone: "inet"},
+	})
+	if err != nil {
+		t.Fatalf("failed to update prefix: %v", err)
+	}
+	fake.SetCreated(prefix.ID, time.Now().Add(-time.Hour))
+	return prefix
+}
+
+// findingsSetup stores one difference of every kind that can be repaired: a Netbox prefix without a
+// document, a document with services and one without services whose prefixes do not exist, and a prefix
+// whose k8s_uuid is not the ID of its document.
+func findingsSetup(t *testing.T) (repository.Repository, *netboxfake.Fake) {
+	t.Helper()
+	repo, fake := setup(t)
+
+	managedPrefix(t, fake, "10.0.0.10/32", bson.NewObjectID().Hex())
+	insertOrphan(t, repo, "10.0.0.20/32")
+	if _, err := repo.InsertAddress(context.Background(), mongodbtypes.Address{
+		Zone: "inet", IPFamily: "ipv4", Address: "10.0.0.30/32", NetboxID: 998,
+	}); err != nil {
+		t.Fatalf("failed to insert address: %v", err)
+	}
+
+	mismatched := managedPrefix(t, fake, "10.0.0.40/32", "not-the-document")
+	if _, err := repo.InsertAddress(context.Background(), mongodbtypes.Address{
+		Zone: "inet", IPFamily: "ipv4", Address: "10.0.0.40/32", NetboxID: mismatched.ID,
+		Services: []mongodbtypes.Service{{ServiceName: "service2", NamespaceID: "namespace1", ClusterID: "cluster1"}},
+	}); err != nil {
+		t.Fatalf("failed to insert address: %v", err)
+	}
+
+	return repo, fake
+}
+
+// findingKinds returns the kind of every finding by address.
+func findingKinds(report Report) map[string]string {
+	kinds := make(map[string]string, len(report.Findings))
+	for _, finding := range report.Findings {
+		kinds[finding.Address] = finding.Kind
+	}
+	return kinds
+}
+
+func TestReconcileReportsWithoutRepairing(t *testing.T) {
+	repo, fake := findingsSetup(t)
+	prefixesBefore := fake.Prefixes()
+
+	report, err := Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+
+	expected := map[string]string{
+		"10.0.0.10/32": FindingNetboxOrphan,
+		"10.0.0.20/32": FindingMongoOrphan,
+		"10.0.0.30/32": FindingMongoOrphan,
+		"10.0.0.40/32": FindingUUIDMismatch,
+	}
+	kinds := findingKinds(report)
+	if len(kinds) != len(expected) {
+		t.Errorf("expected findings %v, got %v", expected, kinds)
+	}
+	for address, kind := range expected {
+		if kinds[address] != kind {
+			t.Errorf("expected %s for %s, got %q", kind, address, kinds[address])
+		}
+	}
+	for _, finding := range report.Findings {
+		if finding.Repaired || finding.RepairError != "" {
+			t.Errorf("expected no repair without repair set, got %+v", finding)
+		}
+	}
+
+	if prefixes := fake.Prefixes(); len(prefixes) != len(prefixesBefore) {
+		t.Errorf("expected Netbox to be unchanged, got %v", prefixes)
+	}
+	addresses, err := repo.FindAddresses(context.Background(), repository.AddressQuery{})
+	if err != nil || len(addresses) != 3 {
+		t.Errorf("expected the 3 documents to be unchanged, got %d (%v)", len(addresses), err)
+	}
+}
+
+func TestReconcileRepairs(t *testing.T) {
+	repo, fake := findingsSetup(t)
+
+	report, err := Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+	for _, finding := range report.Findings {
+		if !finding.Repaired {
+			t.Errorf("expected %s for %s to be repaired, got %+v", finding.Kind, finding.Address, finding)
+		}
+	}
+
+	addresses, err := repo.FindAddresses(context.Background(), repository.AddressQuery{})
+	if err != nil {
+		t.Fatalf("failed to list addresses: %v", err)
+	}
+	byAddress := make(map[string]mongodbtypes.Address, len(addresses))
+	for _, address := range addresses {
+		byAddress[address.Address] = address
+	}
+	if _, ok := byAddress["10.0.0.30/32"]; ok || len(addresses) != 2 {
+		t.Errorf("expected the orphaned document without services to be deleted, got %+v", addresses)
+	}
+
+	prefixes := make(map[string]responses.NetboxPrefix)
+	for _, prefix := range fake.Prefixes() {
+		prefixes[prefix.Prefix] = prefix
+	}
+	if _, ok := prefixes["10.0.0.10/32"]; ok {
+		t.Error("expected the orphaned Netbox prefix to be deleted")
+	}
+	recreated, ok := prefixes["10.0.0.20/32"]
+	if !ok || byAddress["10.0.0.20/32"].NetboxID != recreated.ID {
+		t.Errorf("expected the prefix of the document with services to be recreated and referenced, got %+v", recreated)
+	}
+	if got := prefixes["10.0.0.40/32"].CustomFields.K8sUUID; got != byAddress["10.0.0.40/32"].ID.Hex() {
+		t.Errorf("expected k8s_uuid to be updated to the document ID, got %q", got)
+	}
+
+	// A second pass finds nothing left to repair
+	report, err = Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("failed to reconcile again: %v", err)
+	}
+	if len(report.Findings) != 0 {
+		t.Errorf("expected no findings after repairing, got %+v", report.Findings)
+	}
+}
@@ -0,0 +1,305 @@
+package sagaservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/internal/services/storageservice"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Compensation actions.
+const (
+	ActionDeleteNetboxPrefix = "delete_netbox_prefix"
+	ActionDeleteAddress      = "delete_address"
+	ActionRestoreService     = "restore_service"
+	ActionRemoveService      = "remove_service"
+)
+
+// Compensation statuses.
+const (
+	// StatusPending is a compensation of a saga that is still running.
+	StatusPending = "pending"
+	// StatusFailed is a compensation that failed and is retried by the compensation worker.
+	StatusFailed = "failed"
+	// StatusAbandoned is a compensation of a saga that never finished, for example because the API was
+	// restarted mid-allocation. It is not executed automatically, since the allocation may have succeeded.
+	StatusAbandoned = "abandoned"
+)
+
+const (
+	// abandonAfter is how long a saga may run before its pending compensations are marked abandoned.
+	abandonAfter = 10 * time.Minute
+	// maxRetryDelay caps the exponential backoff between retries of a failed compensation.
+	maxRetryDelay = time.Hour
+)
+
+// Saga records the compensation of every completed step of a multi-step operation, so the completed
+// steps can be undone if a later step fails. Compensations are persisted in the repository as they are recorded.
+// Compensations that fail when the saga is aborted are left for the compensation worker to retry.
+type Saga struct {
+	ctx   context.Context
+	id    bson.ObjectID
+	name  string
+	steps []mongodbtypes.Compensation
+}
+
+// New starts a saga for the operation running with ctx. The name is stored with every compensation to identify
+// the operation in logs. Compensations run even if ctx is canceled, so a canceled request is still rolled back.
+func New(ctx context.Context, name string) *Saga {
+	return &Saga{ctx: context.WithoutCancel(ctx), id: bson.NewObjectID(), name: name}
+}
+
+// DeleteNetboxPrefix returns a compensation that deletes the Netbox prefix with the given ID.
+func DeleteNetboxPrefix(netboxID int, prefix string) mongodbtypes.Compensation {
+	return mongodbtypes.Compensation{Action: ActionDeleteNetboxPrefix, NetboxID: netboxID, Address: prefix}
+}
+
+// DeleteAddress returns a compensation that deletes the address document with the given ID.
+func DeleteAddress(id bson.ObjectID, address string) mongodbtypes.Compensation {
+	return mongodbtypes.Compensation{Action: ActionDeleteAddress, AddressID: id, Address: address}
+}
+
+// RestoreService returns a compensation that puts service back on the address document as it was before
+// the step, replacing the version of the service written by the step. The other services of the address are
+// left as they are, so services registered since the step are kept.
+func RestoreService(address mongodbtypes.Address, service mongodbtypes.Service) mongodbtypes.Compensation {
+	return mongodbtypes.Compensation{Action: ActionRestoreService, AddressID: address.ID, Address: address.Address, Service: &service}
+}
+
+// RemoveService returns a compensation that removes service from the address document, for a step that
+// added the service to an address shared with other services. The other services of the address are kept.
+func RemoveService(address mongodbtypes.Address, service mongodbtypes.Service) mongodbtypes.Compensation {
+	return mongodbtypes.Compensation{Action: ActionRemoveService, AddressID: address.ID, Address: address.Address, Service: &service}
+}
+
+// Record registers the compensation of a step that has completed. A compensation that cannot be persisted
+// is still kept in memory, so the saga can undo the step as long as the process is running.
+func (s *Saga) Record(compensation mongodbtypes.Compensation) {
+	compensation.ID = bson.NewObjectID()
+	compensation.SagaID = s.id
+	compensation.Saga = s.name
+	compensation.Sequence = len(s.steps)
+	compensation.Status = StatusPending
+	compensation.CreatedAt = time.Now()
+
+	err := repository.GetRepository().InsertCompensation(s.ctx, compensation)
+	if err != nil {
+		logger.Log.Warnf("Failed to persist %s compensation for %s in saga %s: %v", compensation.Action, compensation.Address, s.name, err)
+	}
+
+	s.steps = append(s.steps, compensation)
+}
+
+// Complete marks the saga as successful and discards its compensations.
+func (s *Saga) Complete() {
+	if len(s.steps) == 0 {
+		return
+	}
+
+	err := repository.GetRepository().DeleteSagaCompensations(s.ctx, s.id)
+	if err != nil {
+		logger.Log.Errorf("Failed to discard compensations of completed saga %s: %v", s.name, err)
+	}
+	s.steps = nil
+}
+
+// Abort undoes the completed steps in reverse order because of cause. The first compensation that fails
+// stops the rollback: it is scheduled for the compensation worker together with the compensations of the
+// earlier steps, which the worker runs in order once it has succeeded. A Netbox prefix is therefore never
+// deleted while the address document pointing at it could not be.
+//
+// Parameters:
+//   - cause: The error that made the saga fail.
+//
+// Returns:
+//   - error: Nil if every completed step was undone, otherwise the error of the failed compensation.
+func (s *Saga) Abort(cause error) error {
+	logger.Log.Warnf("Saga %s failed, undoing %d completed steps: %v", s.name, len(s.steps), cause)
+
+	steps := s.steps
+	s.steps = nil
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		compensation := steps[i]
+
+		if err := execute(s.ctx, compensation); err != nil {
+			logger.Log.Errorf("Compensation %s for %s in saga %s failed, it and %d earlier steps will be retried: %v",
+				compensation.Action, compensation.Address, s.name, i, err)
+			scheduleRetry(s.ctx, compensation, err)
+			for _, earlier := range steps[:i] {
+				scheduleAfter(s.ctx, earlier, compensation)
+			}
+			return fmt.Errorf("%s for %s: %w", compensation.Action, compensation.Address, err)
+		}
+
+		err := repository.GetRepository().DeleteCompensation(s.ctx, compensation.ID)
+		if err != nil {
+			logger.Log.Errorf("Failed to discard compensation %s for %s in saga %s: %v", compensation.Action, compensation.Address, s.name, err)
+		}
+	}
+
+	return nil
+}
+
+// execute runs a compensation. Compensations are idempotent: undoing a step that is already undone succeeds.
+func execute(ctx context.Context, compensation mongodbtypes.Compensation) error {
+	switch compensation.Action {
+	case ActionDeleteNetboxPrefix:
+		err := netboxservice.DeleteNetboxPrefix(ctx, compensation.NetboxID)
+		if errors.Is(err, netboxservice.ErrPrefixNotFound) {
+			return nil
+		}
+		return err
+	case ActionDeleteAddress:
+		return storageservice.DeleteAddress(ctx, compensation.AddressID)
+	case ActionRestoreService, ActionRemoveService:
+		if compensation.Service == nil {
+			return fmt.Errorf("compensation %s for %s has no service", compensation.Action, compensation.Address)
+		}
+		if compensation.Action == ActionRestoreService {
+			return storageservice.PutService(ctx, compensation.AddressID, *compensation.Service)
+		}
+		return storageservice.RemoveService(ctx, compensation.AddressID, *compensation.Service)
+	default:
+		return fmt.Errorf("unknown compensation action %q", compensation.Action)
+	}
+}
+
+// scheduleRetry stores a failed compensation for the compensation worker. The compensation is upserted,
+// since it may not have been persisted when it was recorded.
+func scheduleRetry(ctx context.Context, compensation mongodbtypes.Compensation, cause error) {
+	compensation.Attempts++
+	compensation.Status = StatusFailed
+	compensation.LastError = cause.Error()
+	nextAttemptAt := time.Now().Add(retryDelay(compensation.Attempts))
+	compensation.NextAttemptAt = &nextAttemptAt
+
+	err := repository.GetRepository().SaveCompensation(ctx, compensation)
+	if err != nil {
+		logger.Log.Errorf("Failed to schedule retry of compensation %s for %s: %v", compensation.Action, compensation.Address, err)
+	}
+}
+
+// scheduleAfter stores the compensation of an earlier step for the compensation worker, to run once blocking,
+// the compensation of a later step of the same saga that failed, has succeeded.
+func scheduleAfter(ctx context.Context, compensation mongodbtypes.Compensation, blocking mongodbtypes.Compensation) {
+	compensation.Status = StatusFailed
+	compensation.LastError = fmt.Sprintf("waiting for %s for %s to be undone", blocking.Action, blocking.Address)
+
+	err := repository.GetRepository().SaveCompensation(ctx, compensation)
+	if err != nil {
+		logger.Log.Errorf("Failed to schedule compensation %s for %s: %v", compensation.Action, compensation.Address, err)
+	}
+}
+
+// retryDelay returns the delay before the next attempt, doubling from one minute up to maxRetryDelay.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// StartCompensationWorker retries failed compensations when they are due and marks the compensations of
+// sagas that never finished as abandoned, every minute until ctx is done. A pass that has started is not
+// cancelled by ctx, so a compensation executed in Netbox is also recorded in the storage.
+func StartCompensationWorker(ctx context.Context) {
+	logger.Log.Info("Starting compensation worker...")
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Compensation worker stopped")
+			return
+		case <-ticker.C:
+		}
+
+		passCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		RetryFailedCompensations(passCtx)
+		MarkAbandonedCompensations(passCtx)
+		cancel()
+	}
+}
+
+// RetryFailedCompensations executes the failed compensations that are due. The compensations of a saga are
+// run in reverse order of recording, like Abort does, and a compensation that is not due or fails again
+// holds back the earlier steps of its saga. Compensations that succeed are removed, the others are
+// rescheduled with a longer delay.
+func RetryFailedCompensations(ctx context.Context) {
+	compensations, err := repository.GetRepository().FindCompensations(ctx, StatusFailed)
+	if err != nil {
+		logger.Log.Errorf("Failed to query failed compensations: %v", err)
+		return
+	}
+
+	now := time.Now()
+	blocked := make(map[bson.ObjectID]bool)
+	for _, compensation := range compensations {
+		if blocked[compensation.SagaID] {
+			continue
+		}
+		if compensation.NextAttemptAt != nil && compensation.NextAttemptAt.After(now) {
+			blocked[compensation.SagaID] = true
+			continue
+		}
+
+		if err := execute(ctx, compensation); err != nil {
+			logger.Log.Errorf("Retry %d of compensation %s for %s in saga %s failed: %v",
+				compensation.Attempts, compensation.Action, compensation.Address, compensation.Saga, err)
+			scheduleRetry(ctx, compensation, err)
+			blocked[compensation.SagaID] = true
+			continue
+		}
+
+		err := repository.GetRepository().DeleteCompensation(ctx, compensation.ID)
+		if err != nil {
+			logger.Log.Errorf("Failed to discard compensation %s for %s: %v", compensation.Action, compensation.Address, err)
+			continue
+		}
+		logger.Log.Infof("Compensation %s for %s in saga %s succeeded after %d failed attempts",
+			compensation.Action, compensation.Address, compensation.Saga, compensation.Attempts)
+	}
+}
+
+// MarkAbandonedCompensations marks pending compensations older than abandonAfter as abandoned.
+// Such sagas were interrupted without completing or aborting, so whether their steps should be undone
+// is left to an operator.
+func MarkAbandonedCompensations(ctx context.Context) {
+	marked, err := repository.GetRepository().UpdateCompensationStatus(ctx, StatusPending, StatusAbandoned, time.Now().Add(-abandonAfter))
+	if err != nil {
+		logger.Log.Errorf("Failed to mark abandoned compensations: %v", err)
+		return
+	}
+
+	if marked > 0 {
+		logger.Log.Warnf("Marked %d compensations of interrupted sagas as abandoned", marked)
+	}
+}
+
+// NetboxIDsInUse returns the IDs of the Netbox prefixes referenced by stored compensations. These prefixes
+// belong to allocations that are running, failed or were interrupted, and are handled by their sagas.
+func NetboxIDsInUse(ctx context.Context) (map[int]bool, error) {
+	compensations, err := repository.GetRepository().FindCompensations(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compensations: %w", err)
+	}
+
+	netboxIDs := make(map[int]bool)
+	for _, compensation := range compensations {
+		if compensation.Action == ActionDeleteNetboxPrefix {
+			netboxIDs[compensation.NetboxID] = true
+		}
+	}
+
+	return netboxIDs, nil
+}
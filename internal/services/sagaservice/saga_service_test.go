@@ -0,0 +1,290 @@
+package sagaservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice/netboxfake"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var errUnavailable = errors.New("storage unavailable")
+
+// failingDeletes is a repository whose address deletes fail while failing is set.
+type failingDeletes struct {
+	repository.Repository
+	mu      sync.Mutex
+	failing bool
+}
+
+func (r *failingDeletes) DeleteAddress(ctx context.Context, id bson.ObjectID) error {
+	r.mu.Lock()
+	failing := r.failing
+	r.mu.Unlock()
+	if failing {
+		return errUnavailable
+	}
+	return r.Repository.DeleteAddress(ctx, id)
+}
+
+func (r *failingDeletes) setFailing(failing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failing = failing
+}
+
+// setup installs a memory repository, wrapped so that address deletes can be made to fail, and a Netbox
+// fake. The previous repository and client are restored when the test ends.
+func setup(t *testing.T) (*failingDeletes, *netboxfake.Fake) {
+	t.Helper()
+	logger.InitConsoleLogger()
+
+	previousRepository := repository.GetRepository()
+	t.Cleanup(func() { repository.SetRepository(previousRepository) })
+
+	repo := &failingDeletes{Repository: repository.NewMemoryRepository()}
+	repository.SetRepository(repo)
+	return repo, netboxfake.Install(t)
+}
+
+// insertAddress stores an address on a new prefix in the fake and returns both.
+func insertAddress(t *testing.T, repo repository.Repository, fake *netboxfake.Fake, cidr string, services ...mongodbtypes.Service) (mongodbtypes.Address, int) {
+	t.Helper()
+	prefix := fake.AddPrefix(cidr, 1)
+	address := mongodbtypes.Address{Zone: "inet", IPFamily: "ipv4", Address: cidr, NetboxID: prefix.ID, Services: services}
+	id, err := repo.InsertAddress(context.Background(), address)
+	if err != nil {
+		t.Fatalf("failed to insert address: %v", err)
+	}
+	address.ID = id
+	return address, prefix.ID
+}
+
+func prefixExists(fake *netboxfake.Fake, id int) bool {
+	for _, prefix := range fake.Prefixes() {
+		if prefix.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func addressExists(t *testing.T, repo repository.Repository, id bson.ObjectID) bool {
+	t.Helper()
+	_, err := repo.FindAddress(context.Background(), repository.AddressQuery{ID: id})
+	if errors.Is(err, repository.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		t.Fatalf("failed to read address: %v", err)
+	}
+	return true
+}
+
+func compensations(t *testing.T, repo repository.Repository) []mongodbtypes.Compensation {
+	t.Helper()
+	stored, err := repo.FindCompensations(context.Background(), "")
+	if err != nil {
+		t.Fatalf("failed to read compensations: %v", err)
+	}
+	return stored
+}
+
+// makeDue moves the next attempt of every failed compensation into the past.
+func makeDue(t *testing.T, repo repository.Repository) {
+	t.Helper()
+	past := time.Now().Add(-time.Second)
+	for _, compensation := range compensations(t, repo) {
+		compensation.NextAttemptAt = &past
+		if err := repo.SaveCompensation(context.Background(), compensation); err != nil {
+			t.Fatalf("failed to save compensation: %v", err)
+		}
+	}
+}
+
+func TestAbortUndoesStepsInReverseOrder(t *testing.T) {
+	repo, fake := setup(t)
+	address, prefixID := insertAddress(t, repo, fake, "10.0.0.1/32")
+
+	saga := New(context.Background(), "test")
+	saga.Record(DeleteNetboxPrefix(prefixID, address.Address))
+	saga.Record(DeleteAddress(address.ID, address.Address))
+
+	if err := saga.Abort(errors.New("step failed")); err != nil {
+		t.Fatalf("expected the rollback to succeed, got %v", err)
+	}
+	if addressExists(t, repo, address.ID) || prefixExists(fake, prefixID) {
+		t.Error("expected the address and the prefix to be deleted")
+	}
+	if stored := compensations(t, repo); len(stored) != 0 {
+		t.Errorf("expected no compensations left, got %d", len(stored))
+	}
+}
+
+func TestAbortStopsAtFirstFailedCompensation(t *testing.T) {
+	repo, fake := setup(t)
+	address, prefixID := insertAddress(t, repo, fake, "10.0.0.1/32")
+
+	saga := New(context.Background(), "test")
+	saga.Record(DeleteNetboxPrefix(prefixID, address.Address))
+	saga.Record(DeleteAddress(address.ID, address.Address))
+
+	repo.setFailing(true)
+	if err := saga.Abort(errors.New("step failed")); !errors.Is(err, errUnavailable) {
+		t.Fatalf("expected the failed compensation to be reported, got %v", err)
+	}
+	if !prefixExists(fake, prefixID) {
+		t.Fatal("the prefix was deleted while the address pointing at it could not be")
+	}
+
+	stored := compensations(t, repo)
+	if len(stored) != 2 {
+		t.Fatalf("expected both compensations to be scheduled, got %d", len(stored))
+	}
+	for _, compensation := range stored {
+		if compensation.Status != StatusFailed {
+			t.Errorf("expected %s to be scheduled as failed, got %s", compensation.Action, compensation.Status)
+		}
+	}
+
+	// The failed delete is not due yet, so the worker must not skip ahead to the prefix
+	repo.setFailing(false)
+	RetryFailedCompensations(context.Background())
+	if !addressExists(t, repo, address.ID) || !prefixExists(fake, prefixID) {
+		t.Fatal("expected the worker to wait for the failed compensation to be due")
+	}
+
+	makeDue(t, repo)
+	RetryFailedCompensations(context.Background())
+	if addressExists(t, repo, address.ID) || prefixExists(fake, prefixID) {
+		t.Error("expected the worker to delete the address and then the prefix")
+	}
+	if stored := compensations(t, repo); len(stored) != 0 {
+		t.Errorf("expected no compensations left, got %d", len(stored))
+	}
+}
+
+func TestRetryFailedCompensationsKeepsOrderWhenRetryFails(t *testing.T) {
+	repo, fake := setup(t)
+	address, prefixID := insertAddress(t, repo, fake, "10.0.0.1/32")
+
+	saga := New(context.Background(), "test")
+	saga.Record(DeleteNetboxPrefix(prefixID, address.Address))
+	saga.Record(DeleteAddress(address.ID, address.Address))
+
+	repo.setFailing(true)
+	_ = saga.Abort(errors.New("step failed"))
+
+	makeDue(t, repo)
+	RetryFailedCompensations(context.Background())
+	if !prefixExists(fake, prefixID) {
+		t.Fatal("the prefix was deleted while the address pointing at it could not be")
+	}
+
+	for _, compensation := range compensations(t, repo) {
+		if compensation.Action == ActionDeleteAddress && compensation.Attempts != 2 {
+			t.Errorf("expected the address delete to be attempted twice, got %d", compensation.Attempts)
+		}
+		if compensation.Action == ActionDeleteNetboxPrefix && compensation.Attempts != 0 {
+			t.Errorf("expected the prefix delete not to be attempted, got %d", compensation.Attempts)
+		}
+	}
+}
+
+func TestRestoreServiceKeepsServicesRegisteredSince(t *testing.T) {
+	repo, fake := setup(t)
+	ctx := context.Background()
+	before := mongodbtypes.Service{ServiceName: "web", NamespaceID: "namespace", ClusterID: "cluster-0001", RetentionPeriodDays: 1}
+	address, _ := insertAddress(t, repo, fake, "10.0.0.1/32", before)
+
+	saga := New(ctx, "test")
+	saga.Record(RestoreService(address, before))
+
+	changed := before
+	changed.RetentionPeriodDays = 7
+	registeredSince := mongodbtypes.Service{ServiceName: "api", NamespaceID: "namespace", ClusterID: "cluster-0002"}
+	for _, service := range []mongodbtypes.Service{changed, registeredSince} {
+		if err := repo.PutService(ctx, address.ID, service); err != nil {
+			t.Fatalf("failed to put service: %v", err)
+		}
+	}
+
+	if err := saga.Abort(errors.New("step failed")); err != nil {
+		t.Fatalf("expected the rollback to succeed, got %v", err)
+	}
+
+	stored, err := repo.FindAddress(ctx, repository.AddressQuery{ID: address.ID})
+	if err != nil {
+		t.Fatalf("failed to read address: %v", err)
+	}
+	if len(stored.Services) != 2 {
+		t.Fatalf("expected 2 services, got %+v", stored.Services)
+	}
+	for _, service := range stored.Services {
+		if service.ServiceName == before.ServiceName && service.RetentionPeriodDays != before.RetentionPeriodDays {
+			t.Errorf("expected %s to be restored, got %+v", before.ServiceName, service)
+		}
+	}
+}
+
+func TestRemoveServiceKeepsOtherServices(t *testing.T) {
+	repo, fake := setup(t)
+	ctx := context.Background()
+	other := mongodbtypes.Service{ServiceName: "api", NamespaceID: "namespace", ClusterID: "cluster-0002"}
+	address, prefixID := insertAddress(t, repo, fake, "10.0.0.1/32", other)
+
+	added := mongodbtypes.Service{ServiceName: "web", NamespaceID: "namespace", ClusterID: "cluster-0001"}
+	saga := New(ctx, "test")
+	saga.Record(RemoveService(address, added))
+	if err := repo.PutService(ctx, address.ID, added); err != nil {
+		t.Fatalf("failed to put service: %v", err)
+	}
+
+	if err := saga.Abort(errors.New("step failed")); err != nil {
+		t.Fatalf("expected the rollback to succeed, got %v", err)
+	}
+
+	stored, err := repo.FindAddress(ctx, repository.AddressQuery{ID: address.ID})
+	if err != nil {
+		t.Fatalf("failed to read address: %v", err)
+	}
+	if len(stored.Services) != 1 || stored.Services[0].ServiceName != other.ServiceName {
+		t.Errorf("expected only %s to be left, got %+v", other.ServiceName, stored.Services)
+	}
+	if !prefixExists(fake, prefixID) {
+		t.Error("expected the shared prefix to be kept")
+	}
+}
+
+func TestMarkAbandonedCompensations(t *testing.T) {
+	repo, _ := setup(t)
+	ctx := context.Background()
+
+	old := mongodbtypes.Compensation{ID: bson.NewObjectID(), SagaID: bson.NewObjectID(), Action: ActionDeleteNetboxPrefix,
+		Status: StatusPending, CreatedAt: time.Now().Add(-2 * abandonAfter)}
+	recent := mongodbtypes.Compensation{ID: bson.NewObjectID(), SagaID: bson.NewObjectID(), Action: ActionDeleteNetboxPrefix,
+		Status: StatusPending, CreatedAt: time.Now()}
+	for _, compensation := range []mongodbtypes.Compensation{old, recent} {
+		if err := repo.InsertCompensation(ctx, compensation); err != nil {
+			t.Fatalf("failed to insert compensation: %v", err)
+		}
+	}
+
+	MarkAbandonedCompensations(ctx)
+
+	for _, compensation := range compensations(t, repo) {
+		expected := StatusPending
+		if compensation.ID == old.ID {
+			expected = StatusAbandoned
+		}
+		if compensation.Status != expected {
+			t.Errorf("expected compensation created at %s to be %s, got %s", compensation.CreatedAt, expected, compensation.Status)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package storageservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ErrLeaseBusy is returned by AcquireLease when the lease is still held by another holder when the wait ends.
+var ErrLeaseBusy = errors.New("lease is held by another request")
+
+const (
+	// leasePollInterval is how long AcquireLease first waits before trying again to take a lease held by
+	// another instance. The wait doubles after every try, up to leasePollMaxInterval.
+	leasePollInterval    = 25 * time.Millisecond
+	leasePollMaxInterval = time.Second
+)
+
+// localLease queues the requests of this instance that hold or wait for a lease, so that only one of them
+// at a time tries to take it in the repository.
+type localLease struct {
+	token chan struct{}
+	users int
+}
+
+var (
+	localLeasesMu sync.Mutex
+	localLeases   = map[string]*localLease{}
+)
+
+// AcquireLease takes the lease with the given name in the repository, so that no other request or instance
+// takes it until the returned function releases it. Every call is its own holder, so concurrent requests of
+// the same instance exclude each other as well; they wait in memory, in the order they arrived, and only the
+// first of them polls the repository. The lease is renewed every third of ttl while it is held.
+//
+// Parameters:
+//   - ctx: Context of the repository operations. Renewing and releasing the lease are not cancelled with ctx.
+//   - name: The name of the lease.
+//   - ttl: How long the lease is held after its last renewal, for example because the instance stopped.
+//   - wait: How long to try again while another holder has the lease. Zero tries once.
+//
+// Returns:
+//   - func(): Stops renewing and releases the lease.
+//   - error: ErrLeaseBusy if the lease is held by another holder until wait has passed, or an error if the
+//     repository fails.
+func AcquireLease(ctx context.Context, name string, ttl, wait time.Duration) (func(), error) {
+	deadline := time.Now().Add(wait)
+
+	releaseLocal, err := acquireLocalLease(ctx, name, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := repository.GetRepository()
+	holder := bson.NewObjectID().Hex()
+	interval := leasePollInterval
+
+	for {
+		acquired, err := repo.AcquireLease(ctx, name, holder, ttl)
+		if err != nil {
+			releaseLocal()
+			return nil, fmt.Errorf("failed to acquire lease %s: %w", name, err)
+		}
+		if acquired {
+			stopRenewing := renewLease(ctx, repo, name, holder, ttl)
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					stopRenewing()
+					err := repo.ReleaseLease(context.WithoutCancel(ctx), name, holder)
+					if err != nil {
+						logger.Log.Warnf("Failed to release lease %s, it expires within %s: %v", name, ttl, err)
+					}
+					releaseLocal()
+				})
+			}, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			releaseLocal()
+			return nil, fmt.Errorf("%w: %s", ErrLeaseBusy, name)
+		}
+		select {
+		case <-ctx.Done():
+			releaseLocal()
+			return nil, ctx.Err()
+		case <-time.After(min(interval, remaining)):
+		}
+		interval = min(2*interval, leasePollMaxInterval)
+	}
+}
+
+// acquireLocalLease waits until no other request of this instance holds or takes the lease with the given
+// name, and returns a function that lets the next one go.
+func acquireLocalLease(ctx context.Context, name string, deadline time.Time) (func(), error) {
+	localLeasesMu.Lock()
+	lease, ok := localLeases[name]
+	if !ok {
+		lease = &localLease{token: make(chan struct{}, 1)}
+		localLeases[name] = lease
+	}
+	lease.users++
+	localLeasesMu.Unlock()
+
+	done := func() {
+		localLeasesMu.Lock()
+		defer localLeasesMu.Unlock()
+		lease.users--
+		if lease.users == 0 {
+			delete(localLeases, name)
+		}
+	}
+	release := func() {
+		<-lease.token
+		done()
+	}
+
+	// Take a free lease even if the wait has already passed
+	select {
+	case lease.token <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case lease.token <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		done()
+		return nil, fmt.Errorf("%w: %s", ErrLeaseBusy, name)
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+}
+
+// renewLease renews the lease of holder every third of ttl until the returned function is called, so that
+// it does not expire while the work it protects takes longer than ttl.
+func renewLease(ctx context.Context, repo repository.Repository, name, holder string, ttl time.Duration) func() {
+	ctx = context.WithoutCancel(ctx)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			acquired, err := repo.AcquireLease(ctx, name, holder, ttl)
+			if err != nil {
+				logger.Log.Warnf("Failed to renew lease %s, it expires within %s: %v", name, ttl, err)
+			} else if !acquired {
+				logger.Log.Errorf("Lease %s expired and was taken by another holder before it was renewed", name)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
@@ -0,0 +1,109 @@
+package storageservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestRepositoryLeases(t *testing.T) {
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			repo := open(t)
+			ctx := context.Background()
+			// Unique, since the MongoDB and PostgreSQL test databases may be shared
+			lease := "test/" + bson.NewObjectID().Hex()
+
+			acquire := func(holder string, ttl time.Duration) bool {
+				t.Helper()
+				acquired, err := repo.AcquireLease(ctx, lease, holder, ttl)
+				if err != nil {
+					t.Fatalf("failed to acquire lease: %v", err)
+				}
+				return acquired
+			}
+
+			if !acquire("a", time.Minute) {
+				t.Fatal("expected a free lease to be acquired")
+			}
+			if acquire("b", time.Minute) {
+				t.Error("expected a lease held by another holder not to be acquired")
+			}
+			if !acquire("a", time.Minute) {
+				t.Error("expected the holder to renew its lease")
+			}
+
+			if err := repo.ReleaseLease(ctx, lease, "b"); err != nil {
+				t.Fatalf("failed to release lease: %v", err)
+			}
+			if acquire("b", time.Minute) {
+				t.Error("expected another holder not to release the lease")
+			}
+
+			if err := repo.ReleaseLease(ctx, lease, "a"); err != nil {
+				t.Fatalf("failed to release lease: %v", err)
+			}
+			if !acquire("b", 10*time.Millisecond) {
+				t.Fatal("expected a released lease to be acquired")
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			if !acquire("a", time.Minute) {
+				t.Error("expected an expired lease to be acquired")
+			}
+		})
+	}
+}
+
+func TestAcquireLeaseWaitsForRelease(t *testing.T) {
+	previous := repository.GetRepository()
+	t.Cleanup(func() { repository.SetRepository(previous) })
+	repository.SetRepository(repository.NewMemoryRepository())
+	ctx := context.Background()
+
+	release, err := AcquireLease(ctx, "quota", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("failed to acquire lease: %v", err)
+	}
+
+	if _, err := AcquireLease(ctx, "quota", time.Minute, 50*time.Millisecond); !errors.Is(err, ErrLeaseBusy) {
+		t.Errorf("expected ErrLeaseBusy while the lease is held, got %v", err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, release)
+	releaseAgain, err := AcquireLease(ctx, "quota", time.Minute, 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected the lease to be acquired once released, got %v", err)
+	}
+	releaseAgain()
+}
+
+// TestAcquireLeaseRenewsWhileHeld checks that a lease held longer than its ttl is not taken by another
+// holder, and is free once released.
+func TestAcquireLeaseRenewsWhileHeld(t *testing.T) {
+	previous := repository.GetRepository()
+	t.Cleanup(func() { repository.SetRepository(previous) })
+	repo := repository.NewMemoryRepository()
+	repository.SetRepository(repo)
+	ctx := context.Background()
+
+	release, err := AcquireLease(ctx, "quota", 60*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("failed to acquire lease: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if acquired, err := repo.AcquireLease(ctx, "quota", "other", time.Minute); err != nil || acquired {
+		t.Errorf("expected the held lease to be renewed, got acquired %t (%v)", acquired, err)
+	}
+
+	release()
+	release()
+	if acquired, err := repo.AcquireLease(ctx, "quota", "other", time.Minute); err != nil || !acquired {
+		t.Errorf("expected the released lease to be free, got acquired %t (%v)", acquired, err)
+	}
+}
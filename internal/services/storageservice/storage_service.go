@@ -0,0 +1,635 @@
+package storageservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/metrics"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/responses"
+	"github.com/vitistack/ipam-api/internal/utils"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// DefaultListLimit is the page size used by ListAddresses when no limit is provided.
+const DefaultListLimit = 100
+
+// ErrAddressNotFound is returned when no address document matches the provided secret, zone and address.
+var ErrAddressNotFound = errors.New("no matching address found with the provided secret, zone and address")
+
+// RegisterAddress creates a new address document in the repository using the provided
+// IpamApiRequest and NetboxPrefix. It hashes the secret from the request, constructs the
+// address document, and inserts it into the repository. The function returns the newly created
+// mongodbtypes.Address or an error if the operation fails.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - request: apicontracts.IpamAPIRequest containing the address and service details.
+//   - nextPrefix: responses.NetboxPrefix representing the prefix to assign.
+//
+// Returns:
+//   - mongodbtypes.Address: The newly created address document. If the document was saved but could not be read
+//     back, only its ID and address are set.
+//   - error: An error if the operation fails, otherwise nil.
+func RegisterAddress(ctx context.Context, request apicontracts.IpamAPIRequest, nextPrefix responses.NetboxPrefix) (mongodbtypes.Address, error) {
+
+	storedSecret, err := HashedSecret(request.Secret)
+
+	if err != nil {
+		return mongodbtypes.Address{}, err
+	}
+
+	service := mongodbtypes.Service{
+		ServiceName:         request.Service.ServiceName,
+		NamespaceID:         request.Service.NamespaceID,
+		ClusterID:           request.Service.ClusterID,
+		RetentionPeriodDays: request.Service.RetentionPeriodDays,
+		DenyExternalCleanup: request.Service.DenyExternalCleanup,
+		ExpiresAt:           nil,
+	}
+
+	newAddressDocument := mongodbtypes.Address{
+		SchemaVersion: storedSecret.SchemaVersion,
+		Secret:        storedSecret.Secret,
+		SecretHash:    storedSecret.SecretHash,
+		SecretKeyID:   storedSecret.SecretKeyID,
+		Zone:          request.Zone,
+		Address:       nextPrefix.Prefix,
+		IPFamily:      request.IPFamily,
+		NetboxID:      nextPrefix.ID,
+		Services:      []mongodbtypes.Service{service},
+	}
+
+	repo := repository.GetRepository()
+
+	insertedID, err := repo.InsertAddress(ctx, newAddressDocument)
+	if err != nil {
+		return mongodbtypes.Address{}, fmt.Errorf("failed to save address: %w", err)
+	}
+
+	address, err := repo.FindAddress(ctx, repository.AddressQuery{ID: insertedID})
+
+	if err != nil {
+		// The document was saved, return its ID so the caller can undo the insert
+		return mongodbtypes.Address{ID: insertedID, Address: nextPrefix.Prefix}, err
+	}
+
+	return address, nil
+
+}
+
+// UpdateAddressDocument updates an address document in the repository based on the provided IpamApiRequest.
+// It performs the following operations:
+//   - Searches for an address document matching the secret, zone, address, and IP family.
+//   - If a new secret is provided in the request, it validates that only one service is registered and that the service matches,
+//     then updates the secret and services array accordingly. The update fails if another service was registered meanwhile.
+//   - If no new secret is provided, it atomically replaces the matching service (if present) with the updated service, so
+//     services registered concurrently on the same address are kept.
+//   - Returns an error if the document is not found, if there are mismatches, or if any repository operation fails.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - request: apicontracts.IpamAPIRequest containing the details for the update operation.
+//
+// Returns:
+//   - mongodbtypes.Address: The address document as it was before the update.
+//   - error: An error if the update fails or validation does not pass; otherwise, nil.
+func UpdateAddressDocument(ctx context.Context, request apicontracts.IpamAPIRequest) (mongodbtypes.Address, error) {
+	repo := repository.GetRepository()
+
+	query := repository.AddressQuery{
+		Zone:     request.Zone,
+		Address:  request.Address,
+		IPFamily: request.IPFamily,
+	}
+
+	registeredAddress, err := FindAddressBySecret(ctx, request.Secret, query)
+
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return mongodbtypes.Address{}, errors.New("no matching address found with the provided secret, zone and address")
+		}
+		return mongodbtypes.Address{}, fmt.Errorf("failed to read address document: %w", err)
+	}
+
+	if request.NewSecret != "" && request.NewSecret != request.Secret {
+		if len(registeredAddress.Services) > 1 {
+			return mongodbtypes.Address{}, errors.New("multiple services registered. unable to change secret")
+		}
+		if len(registeredAddress.Services) == 0 ||
+			registeredAddress.Services[0].ServiceName != request.Service.ServiceName ||
+			registeredAddress.Services[0].NamespaceID != request.Service.NamespaceID ||
+			registeredAddress.Services[0].ClusterID != request.Service.ClusterID {
+			return mongodbtypes.Address{}, errors.New("service mismatch. unable to change secret")
+		}
+		newSecret, err := HashedSecret(request.NewSecret)
+		if err != nil {
+			return mongodbtypes.Address{}, err
+		}
+
+		service := mongodbtypes.Service{
+			ServiceName:         request.Service.ServiceName,
+			NamespaceID:         request.Service.NamespaceID,
+			ClusterID:           request.Service.ClusterID,
+			RetentionPeriodDays: request.Service.RetentionPeriodDays,
+			DenyExternalCleanup: request.Service.DenyExternalCleanup}
+
+		// The secret is only changed if no other service was registered since the address was read
+		changed, err := repo.ChangeSecret(ctx, registeredAddress.ID, registeredAddress.Secret, newSecret, service)
+		if err != nil {
+			return mongodbtypes.Address{}, fmt.Errorf("failed to update address: %w", err)
+		}
+		if !changed {
+			return mongodbtypes.Address{}, errors.New("services changed while changing secret. unable to change secret")
+		}
+
+		return registeredAddress, nil
+	}
+
+	// Replace the service that matches the request, keeping services registered concurrently
+	service := mongodbtypes.Service{
+		ServiceName:         request.Service.ServiceName,
+		NamespaceID:         request.Service.NamespaceID,
+		ClusterID:           request.Service.ClusterID,
+		RetentionPeriodDays: request.Service.RetentionPeriodDays,
+		DenyExternalCleanup: request.Service.DenyExternalCleanup,
+		ExpiresAt:           nil,
+	}
+
+	err = repo.PutService(ctx, registeredAddress.ID, service)
+
+	if err != nil {
+		return mongodbtypes.Address{}, fmt.Errorf("failed to update address: %w", err)
+	}
+
+	return registeredAddress, nil
+}
+
+// SetServiceExpiration sets an expiration date for a specific service associated with an address.
+// It performs the following steps:
+//  1. Finds the address document matching the secret, zone, address, and IP family.
+//  2. Atomically replaces the service with an updated expiration date based on the retention period,
+//     failing if the specified service does not exist for the address.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - request: apicontracts.IpamAPIRequest containing the secret, zone, address, IP family, and service details.
+//
+// Returns:
+//   - error: An error if the operation fails at any step, or nil if successful.
+func SetServiceExpiration(ctx context.Context, request apicontracts.IpamAPIRequest) error {
+	repo := repository.GetRepository()
+
+	query := repository.AddressQuery{
+		Zone:     request.Zone,
+		Address:  request.Address,
+		IPFamily: request.IPFamily,
+	}
+
+	registeredAddress, err := FindAddressBySecret(ctx, request.Secret, query)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return errors.New("no matching address found with the provivded secret, zone and address")
+		}
+		return fmt.Errorf("failed to read address document: %w", err)
+	}
+
+	// Set the expiration date on the service that matches the request
+	exp := time.Now().AddDate(0, 0, request.Service.RetentionPeriodDays)
+	service := mongodbtypes.Service{
+		ServiceName:         request.Service.ServiceName,
+		NamespaceID:         request.Service.NamespaceID,
+		ClusterID:           request.Service.ClusterID,
+		RetentionPeriodDays: request.Service.RetentionPeriodDays,
+		DenyExternalCleanup: request.Service.DenyExternalCleanup,
+		ExpiresAt:           &exp}
+
+	updated, err := repo.UpdateService(ctx, registeredAddress.ID, service)
+	if err != nil {
+		return fmt.Errorf("failed to update services array: %w", err)
+	}
+	if !updated {
+		return errors.New("service does not exist for this address")
+	}
+	metrics.Deallocations.WithLabelValues(registeredAddress.Zone, registeredAddress.IPFamily).Inc()
+	logger.Log.Infof("Service expiration set for service %s successfully", request.Service.ServiceName)
+	return nil
+}
+
+// SetClusterExpiration sets an expiration date for a all services associated with a cluster.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - request: apicontracts.IpamAPIDeleteClusterRequest containing the cluster ID.
+//
+// Returns:
+//   - error: An error if the operation fails at any step, or nil if successful.
+func SetClusterExpiration(ctx context.Context, request apicontracts.IpamAPIDeleteClusterRequest) error {
+	repo := repository.GetRepository()
+
+	addresses, err := repo.FindAddresses(ctx, repository.AddressQuery{ClusterID: request.ClusterID})
+	if err != nil {
+		return fmt.Errorf("failed to query addresses: %w", err)
+	}
+
+	if len(addresses) == 0 {
+		return errors.New("no addresses with assosiated cluster_id found in the database")
+	}
+
+	now := time.Now()
+
+	for _, addr := range addresses {
+		for _, svc := range addr.Services {
+			if svc.ClusterID != request.ClusterID {
+				continue
+			}
+			svc.ExpiresAt = &now
+
+			logger.Log.Infof("Setting expiresAt for service with cluster ID: %s for address %s",
+				svc.ClusterID, addr.Address)
+
+			updated, err := repo.UpdateService(ctx, addr.ID, svc)
+			if err != nil {
+				return fmt.Errorf("failed to update services for address %s: %w", addr.Address, err)
+			}
+			if updated {
+				metrics.Deallocations.WithLabelValues(addr.Zone, addr.IPFamily).Inc()
+			}
+		}
+	}
+
+	logger.Log.Infof("Service expiration set for cluster_id '%s' successfully", request.ClusterID)
+	return nil
+}
+
+// GetAddress retrieves the address document matching the provided secret, zone, IP family and address.
+// Only callers that know the secret the address was registered with can read it.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - request: apicontracts.IpamAPIGetAddressRequest containing the secret, zone, IP family and address.
+//
+// Returns:
+//   - mongodbtypes.Address: The matching address document.
+//   - error: ErrAddressNotFound if no document matches, or an error if the query fails.
+func GetAddress(ctx context.Context, request apicontracts.IpamAPIGetAddressRequest) (mongodbtypes.Address, error) {
+	query := repository.AddressQuery{
+		Zone:     request.Zone,
+		Address:  request.Address,
+		IPFamily: request.IPFamily,
+	}
+
+	registeredAddress, err := FindAddressBySecret(ctx, request.Secret, query)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return mongodbtypes.Address{}, ErrAddressNotFound
+		}
+		return mongodbtypes.Address{}, fmt.Errorf("failed to read address document: %w", err)
+	}
+
+	return registeredAddress, nil
+}
+
+// ListAddresses returns a page of address documents matching the provided filters, ordered by document ID.
+// The cluster, namespace and service name filters are matched against the same entry in the services
+// array. Pagination is cursor based: the returned cursor is the ID of the last document in the page and
+// is empty when there are no more documents.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - request: apicontracts.IpamAPIListAddressesRequest containing the filters, page size and cursor.
+//
+// Returns:
+//   - []mongodbtypes.Address: The address documents in the page.
+//   - string: The cursor for the next page, or an empty string on the last page.
+//   - error: An error if the cursor is invalid or the query fails.
+func ListAddresses(ctx context.Context, request apicontracts.IpamAPIListAddressesRequest) ([]mongodbtypes.Address, string, error) {
+	query := repository.AddressQuery{
+		Zone:        request.Zone,
+		IPFamily:    request.IPFamily,
+		ClusterID:   request.ClusterID,
+		NamespaceID: request.NamespaceID,
+		ServiceName: request.ServiceName,
+	}
+
+	if request.Cursor != "" {
+		cursorID, err := bson.ObjectIDFromHex(request.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor: %w", err)
+		}
+		query.After = cursorID
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	// Fetch one extra document to find out if there is a next page
+	query.Limit = limit + 1
+
+	addresses, err := repository.GetRepository().FindAddresses(ctx, query)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query addresses: %w", err)
+	}
+
+	nextCursor := ""
+	if len(addresses) > limit {
+		addresses = addresses[:limit]
+		nextCursor = addresses[limit-1].ID.Hex()
+	}
+
+	return addresses, nextCursor, nil
+}
+
+// CountServiceAddresses counts the addresses in a zone with a service of the given cluster, and of the
+// given namespace if namespaceID is set, other than except. Counting stops at limit, which is enough to
+// enforce a quota of limit addresses without reading every address of a large cluster.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - zone: The zone of the addresses.
+//   - clusterID: The cluster of the services, or empty to match every cluster.
+//   - namespaceID: The namespace of the services, or empty to match every namespace.
+//   - except: An address that is not counted, such as the address a service is about to join, or empty.
+//   - limit: The largest count returned.
+//
+// Returns:
+//   - int: The number of addresses, at most limit.
+//   - error: An error if the query fails.
+func CountServiceAddresses(ctx context.Context, zone, clusterID, namespaceID, except string, limit int) (int, error) {
+	count, err := repository.GetRepository().CountAddresses(ctx, repository.AddressQuery{
+		Zone:          zone,
+		ClusterID:     clusterID,
+		NamespaceID:   namespaceID,
+		ExceptAddress: except,
+		Limit:         limit,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count addresses: %w", err)
+	}
+	return count, nil
+}
+
+// PutService adds service to the address document with the given ID, replacing the same service. The other
+// services of the address are kept.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - id: The ID of the address document.
+//   - service: The service to store on the address.
+//
+// Returns:
+//   - error: An error if the update fails, or nil if successful.
+func PutService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	err := repository.GetRepository().PutService(ctx, id, service)
+	if err != nil {
+		return fmt.Errorf("failed to put service %s: %w", service.ServiceName, err)
+	}
+
+	return nil
+}
+
+// RemoveService removes service from the address document with the given ID. The other services of the
+// address are kept.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - id: The ID of the address document.
+//   - service: The service to remove.
+//
+// Returns:
+//   - error: An error if the update fails, or nil if successful.
+func RemoveService(ctx context.Context, id bson.ObjectID, service mongodbtypes.Service) error {
+	err := repository.GetRepository().RemoveService(ctx, id, service)
+	if err != nil {
+		return fmt.Errorf("failed to remove service %s: %w", service.ServiceName, err)
+	}
+
+	return nil
+}
+
+// DeleteAddress deletes the address document with the given ID.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - id: The ID of the address document.
+//
+// Returns:
+//   - error: An error if the delete fails, or nil if successful.
+func DeleteAddress(ctx context.Context, id bson.ObjectID) error {
+	err := repository.GetRepository().DeleteAddress(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete address: %w", err)
+	}
+
+	return nil
+}
+
+// GetAllAddresses returns every address document.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//
+// Returns:
+//   - []mongodbtypes.Address: All address documents.
+//   - error: An error if the query fails, or nil if successful.
+func GetAllAddresses(ctx context.Context) ([]mongodbtypes.Address, error) {
+	addresses, err := repository.GetRepository().FindAddresses(ctx, repository.AddressQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query addresses: %w", err)
+	}
+
+	return addresses, nil
+}
+
+// SetNetboxID sets the Netbox prefix ID of the address document with the given ID.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - id: The ID of the address document.
+//   - netboxID: The ID of the prefix in Netbox.
+//
+// Returns:
+//   - error: An error if the update fails, or nil if successful.
+func SetNetboxID(ctx context.Context, id bson.ObjectID, netboxID int) error {
+	err := repository.GetRepository().UpdateAddress(ctx, id, repository.AddressUpdate{NetboxID: netboxID})
+	if err != nil {
+		return fmt.Errorf("failed to update netbox_id: %w", err)
+	}
+
+	return nil
+}
+
+// ServiceExists checks if a target Service exists within a slice of Service objects.
+// It returns true if there is a Service in the slice that matches the NamespaceId,
+// ServiceName, and ClusterId of the target Service; otherwise, it returns false.
+func ServiceExists(services []mongodbtypes.Service, target mongodbtypes.Service) bool {
+	for _, s := range services {
+		if s.NamespaceID == target.NamespaceID &&
+			s.ServiceName == target.ServiceName &&
+			s.ClusterID == target.ClusterID {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceAlreadyRegistered checks if a service, identified by the provided IpamApiRequest,
+// is already registered in the repository. It queries the repository for an address document
+// matching the secret, zone,
+// and IP family that contains a service with the same ServiceName, NamespaceId and ClusterId
+// as in the request. If found, it returns the registered address and a nil error. If no such
+// service is found, it returns an empty Address and nil error.
+// Returns an error if hashing or querying fails.
+func ServiceAlreadyRegistered(ctx context.Context, request apicontracts.IpamAPIRequest) (mongodbtypes.Address, error) {
+	query := repository.AddressQuery{
+		Zone:        request.Zone,
+		IPFamily:    request.IPFamily,
+		ServiceName: request.Service.ServiceName,
+		NamespaceID: request.Service.NamespaceID,
+		ClusterID:   request.Service.ClusterID,
+	}
+
+	registeredAddress, err := FindAddressBySecret(ctx, request.Secret, query)
+	if errors.Is(err, repository.ErrNotFound) {
+		return mongodbtypes.Address{}, nil
+	}
+	if err != nil {
+		return mongodbtypes.Address{}, fmt.Errorf("failed to query address documents: %w", err)
+	}
+
+	return registeredAddress, nil
+}
+
+// HashedSecret returns the fields stored for secret: its lookup key with the current secret lookup key,
+// the ID of that key and an argon2id verifier.
+//
+// Parameters:
+//   - secret: The secret provided by the client.
+//
+// Returns:
+//   - repository.AddressUpdate: An update setting the secret fields of an address.
+//   - error: An error if the secret lookup keys are not configured or hashing fails.
+func HashedSecret(secret string) (repository.AddressUpdate, error) {
+	keyID, err := utils.CurrentSecretKeyID()
+	if err != nil {
+		return repository.AddressUpdate{}, err
+	}
+
+	lookupKey, err := utils.SecretLookupKey(secret, keyID)
+	if err != nil {
+		return repository.AddressUpdate{}, fmt.Errorf("failed to derive secret lookup key: %w", err)
+	}
+
+	hash, err := utils.HashSecret(secret)
+	if err != nil {
+		return repository.AddressUpdate{}, fmt.Errorf("failed to hash secret: %w", err)
+	}
+
+	return repository.AddressUpdate{
+		Secret:        lookupKey,
+		SecretHash:    hash,
+		SecretKeyID:   keyID,
+		SchemaVersion: mongodbtypes.AddressSchemaVersion,
+	}, nil
+}
+
+// FindAddressBySecret returns the first address matching query that was registered with secret. The
+// address is found by the lookup key of the secret and the secret is checked against the stored verifier.
+// Every configured secret lookup key is tried, starting with the current key, and addresses found with a
+// retired key are moved to the current key. Addresses still stored with the encrypted secret of earlier
+// versions are found as well, and are upgraded to the lookup key and verifier.
+//
+// Parameters:
+//   - ctx: Context of the repository operations.
+//   - secret: The secret provided by the client.
+//   - query: The query without the secret.
+//
+// Returns:
+//   - mongodbtypes.Address: The matching address.
+//   - error: repository.ErrNotFound if no address matches, or an error if the query fails.
+func FindAddressBySecret(ctx context.Context, secret string, query repository.AddressQuery) (mongodbtypes.Address, error) {
+	repo := repository.GetRepository()
+
+	keyIDs, err := utils.SecretKeyIDs()
+	if err != nil {
+		return mongodbtypes.Address{}, err
+	}
+
+	var currentLookupKey string
+	for _, keyID := range keyIDs {
+		lookupKey, err := utils.SecretLookupKey(secret, keyID)
+		if err != nil {
+			return mongodbtypes.Address{}, fmt.Errorf("failed to derive secret lookup key: %w", err)
+		}
+		if currentLookupKey == "" {
+			currentLookupKey = lookupKey
+		}
+
+		query.Secret = lookupKey
+		address, err := repo.FindAddress(ctx, query)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return mongodbtypes.Address{}, err
+		}
+
+		matches, err := utils.VerifySecret(secret, address.SecretHash)
+		if err != nil {
+			return mongodbtypes.Address{}, fmt.Errorf("failed to verify secret: %w", err)
+		}
+		if !matches {
+			return mongodbtypes.Address{}, repository.ErrNotFound
+		}
+
+		if keyID != keyIDs[0] {
+			update := repository.AddressUpdate{Secret: currentLookupKey, SecretKeyID: keyIDs[0]}
+			if err := repo.UpdateAddress(ctx, address.ID, update); err != nil {
+				return mongodbtypes.Address{}, fmt.Errorf("failed to move secret of address %s to the current key: %w", address.Address, err)
+			}
+			logger.Log.Infof("Moved secret of address %s from key %s to key %s", address.Address, keyID, keyIDs[0])
+
+			address.Secret = currentLookupKey
+			address.SecretKeyID = keyIDs[0]
+		}
+		return address, nil
+	}
+
+	if !utils.LegacySecretsEnabled() {
+		return mongodbtypes.Address{}, repository.ErrNotFound
+	}
+
+	encryptedSecret, err := utils.DeterministicEncrypt(secret)
+	if err != nil {
+		return mongodbtypes.Address{}, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+
+	query.Secret = encryptedSecret
+	address, err := repo.FindAddress(ctx, query)
+	if err != nil {
+		return mongodbtypes.Address{}, err
+	}
+
+	update, err := HashedSecret(secret)
+	if err != nil {
+		return mongodbtypes.Address{}, err
+	}
+	if err := repo.UpdateAddress(ctx, address.ID, update); err != nil {
+		return mongodbtypes.Address{}, fmt.Errorf("failed to upgrade secret of address %s: %w", address.Address, err)
+	}
+	logger.Log.Infof("Upgraded encrypted secret of address %s to a hashed secret", address.Address)
+
+	address.Secret = update.Secret
+	address.SecretHash = update.SecretHash
+	address.SecretKeyID = update.SecretKeyID
+	address.SchemaVersion = update.SchemaVersion
+	return address, nil
+}
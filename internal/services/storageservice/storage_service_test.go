@@ -0,0 +1,267 @@
+package storageservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// testBackends returns the repositories to run the tests against. The memory and bolt backends are always
+// tested; MongoDB and PostgreSQL only when IPAM_TEST_MONGODB_URI or IPAM_TEST_POSTGRES_DSN is set.
+func testBackends(t *testing.T) map[string]func(t *testing.T) repository.Repository {
+	t.Helper()
+
+	backends := map[string]func(t *testing.T) repository.Repository{
+		repository.BackendMemory: func(t *testing.T) repository.Repository {
+			return repository.NewMemoryRepository()
+		},
+		repository.BackendBolt: func(t *testing.T) repository.Repository {
+			repo, err := repository.NewBoltRepository(filepath.Join(t.TempDir(), "ipam.db"))
+			if err != nil {
+				t.Fatalf("failed to open bolt repository: %v", err)
+			}
+			t.Cleanup(func() { _ = repo.Close(context.Background()) })
+			return repo
+		},
+	}
+
+	if uri := os.Getenv("IPAM_TEST_MONGODB_URI"); uri != "" {
+		backends[repository.BackendMongoDB] = func(t *testing.T) repository.Repository {
+			client, err := mongo.Connect(options.Client().ApplyURI(uri))
+			if err != nil {
+				t.Fatalf("failed to connect to MongoDB: %v", err)
+			}
+			database := client.Database("ipam_test_" + bson.NewObjectID().Hex())
+			t.Cleanup(func() {
+				_ = database.Drop(context.Background())
+				_ = client.Disconnect(context.Background())
+			})
+			return repository.NewMongoRepository(database, "addresses", "compensations", "migrations", "leases")
+		}
+	}
+
+	if dsn := os.Getenv("IPAM_TEST_POSTGRES_DSN"); dsn != "" {
+		backends[repository.BackendPostgres] = func(t *testing.T) repository.Repository {
+			repo, err := repository.NewPostgresRepository(context.Background(), dsn)
+			if err != nil {
+				t.Fatalf("failed to connect to PostgreSQL: %v", err)
+			}
+			t.Cleanup(func() { _ = repo.Close(context.Background()) })
+			return repo
+		}
+	}
+
+	return backends
+}
+
+// slowReads delays every address read, so that concurrent registrations read the address before any of
+// them writes it. A registration that writes back the services it read would then lose the others.
+type slowReads struct {
+	repository.Repository
+}
+
+func (r slowReads) FindAddress(ctx context.Context, query repository.AddressQuery) (mongodbtypes.Address, error) {
+	address, err := r.Repository.FindAddress(ctx, query)
+	time.Sleep(20 * time.Millisecond)
+	return address, err
+}
+
+func TestUpdateAddressDocumentConcurrentRegistrations(t *testing.T) {
+	const registrations = 50
+
+	previousKey := viper.Get("secret_lookup_key")
+	previousRepository := repository.GetRepository()
+	t.Cleanup(func() {
+		viper.Set("secret_lookup_key", previousKey)
+		repository.SetRepository(previousRepository)
+	})
+	viper.Set("secret_lookup_key", "0123456789abcdef0123456789abcdef")
+
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := open(t)
+			repository.SetRepository(slowReads{repo})
+
+			// A unique secret keeps runs against a shared database apart
+			sharedSecret := "shared-secret-" + bson.NewObjectID().Hex()
+			storedSecret, err := HashedSecret(sharedSecret)
+			if err != nil {
+				t.Fatalf("failed to hash secret: %v", err)
+			}
+
+			address := mongodbtypes.Address{
+				SchemaVersion: storedSecret.SchemaVersion,
+				Secret:        storedSecret.Secret,
+				SecretHash:    storedSecret.SecretHash,
+				SecretKeyID:   storedSecret.SecretKeyID,
+				Zone:          "inet",
+				IPFamily:      "ipv4",
+				Address:       "10.0.0.1/32",
+				Services:      []mongodbtypes.Service{{ServiceName: "first", NamespaceID: "namespace", ClusterID: "cluster-0001"}},
+			}
+			id, err := repo.InsertAddress(ctx, address)
+			if err != nil {
+				t.Fatalf("failed to insert address: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, registrations)
+			for i := range registrations {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := UpdateAddressDocument(ctx, apicontracts.IpamAPIRequest{
+						Secret:   sharedSecret,
+						Zone:     address.Zone,
+						IPFamily: address.IPFamily,
+						Address:  address.Address,
+						Service: apicontracts.Service{
+							ServiceName: fmt.Sprintf("service-%d", i),
+							NamespaceID: "namespace",
+							ClusterID:   "cluster-0001",
+						},
+					})
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if err != nil {
+					t.Fatalf("registration failed: %v", err)
+				}
+			}
+
+			stored, err := repo.FindAddress(ctx, repository.AddressQuery{ID: id})
+			if err != nil {
+				t.Fatalf("failed to read address: %v", err)
+			}
+
+			if len(stored.Services) != registrations+1 {
+				t.Fatalf("expected %d services, got %d", registrations+1, len(stored.Services))
+			}
+			for i := range registrations {
+				service := mongodbtypes.Service{ServiceName: fmt.Sprintf("service-%d", i), NamespaceID: "namespace", ClusterID: "cluster-0001"}
+				if !ServiceExists(stored.Services, service) {
+					t.Errorf("service %s was lost", service.ServiceName)
+				}
+			}
+		})
+	}
+}
+
+func TestInsertAddressConcurrentDuplicates(t *testing.T) {
+	const inserts = 20
+
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := open(t)
+			if _, err := repo.Migrate(ctx); err != nil {
+				t.Fatalf("failed to migrate: %v", err)
+			}
+
+			// A unique zone keeps runs against a shared database apart
+			zone := "zone-" + bson.NewObjectID().Hex()
+
+			var wg sync.WaitGroup
+			errs := make(chan error, inserts)
+			for range inserts {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := repo.InsertAddress(ctx, mongodbtypes.Address{Zone: zone, IPFamily: "ipv4", Address: "10.0.0.1/32"})
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			stored := 0
+			for err := range errs {
+				switch {
+				case err == nil:
+					stored++
+				case !errors.Is(err, repository.ErrDuplicateAddress):
+					t.Errorf("expected ErrDuplicateAddress, got %v", err)
+				}
+			}
+			if stored != 1 {
+				t.Errorf("expected the address to be stored once, got %d", stored)
+			}
+
+			// The same address in another zone is a different address
+			if _, err := repo.InsertAddress(ctx, mongodbtypes.Address{Zone: zone + "-other", IPFamily: "ipv4", Address: "10.0.0.1/32"}); err != nil {
+				t.Errorf("failed to store the address in another zone: %v", err)
+			}
+
+			// A deleted address can be stored again
+			document, err := repo.FindAddress(ctx, repository.AddressQuery{Zone: zone, Address: "10.0.0.1/32"})
+			if err != nil {
+				t.Fatalf("failed to find the address: %v", err)
+			}
+			if err := repo.DeleteAddress(ctx, document.ID); err != nil {
+				t.Fatalf("failed to delete the address: %v", err)
+			}
+			if _, err := repo.InsertAddress(ctx, mongodbtypes.Address{Zone: zone, IPFamily: "ipv4", Address: "10.0.0.1/32"}); err != nil {
+				t.Errorf("failed to store a deleted address again: %v", err)
+			}
+		})
+	}
+}
+
+func TestRepositoryCountAddresses(t *testing.T) {
+	for name, open := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := open(t)
+			zone := "zone-" + bson.NewObjectID().Hex()
+
+			for i, cluster := range []string{"cluster1", "cluster1", "cluster1", "cluster2"} {
+				_, err := repo.InsertAddress(ctx, mongodbtypes.Address{
+					Zone:     zone,
+					IPFamily: "ipv4",
+					Address:  fmt.Sprintf("10.0.0.%d/32", i),
+					Services: []mongodbtypes.Service{{ServiceName: "service1", NamespaceID: "namespace1", ClusterID: cluster}},
+				})
+				if err != nil {
+					t.Fatalf("failed to insert address: %v", err)
+				}
+			}
+
+			for _, test := range []struct {
+				name     string
+				query    repository.AddressQuery
+				expected int
+			}{
+				{"cluster", repository.AddressQuery{Zone: zone, ClusterID: "cluster1"}, 3},
+				{"namespace", repository.AddressQuery{Zone: zone, NamespaceID: "namespace1"}, 4},
+				{"except", repository.AddressQuery{Zone: zone, ClusterID: "cluster1", ExceptAddress: "10.0.0.0/32"}, 2},
+				{"limit", repository.AddressQuery{Zone: zone, ClusterID: "cluster1", Limit: 2}, 2},
+			} {
+				count, err := repo.CountAddresses(ctx, test.query)
+				if err != nil {
+					t.Fatalf("failed to count addresses: %v", err)
+				}
+				if count != test.expected {
+					t.Errorf("%s: expected %d addresses, got %d", test.name, test.expected, count)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Package tracing configures OpenTelemetry tracing. Spans are exported with OTLP over HTTP when
+// tracing.enabled is set. W3C trace context is accepted on incoming requests and sent to Netbox either way.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer of the IPAM-API.
+const instrumentationName = "github.com/vitistack/ipam-api"
+
+// DefaultServiceName is the service name of the spans when tracing.service_name is not configured.
+const DefaultServiceName = "ipam-api"
+
+func init() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Init configures the global tracer provider from the tracing settings:
+//   - tracing.enabled: export spans. Without it, spans are not recorded.
+//   - tracing.endpoint: the OTLP/HTTP endpoint as host:port. Empty uses OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318.
+//   - tracing.insecure: send spans over plain HTTP.
+//   - tracing.sample_ratio: the share of new traces that is sampled, default 1. Incoming sampled traces are always sampled.
+//   - tracing.service_name: the service name of the spans, default DefaultServiceName.
+//
+// Parameters:
+//   - ctx: Context for creating the exporter.
+//
+// Returns:
+//   - func(context.Context) error: Flushes and stops the exporter. It does nothing if tracing is disabled.
+//   - error: An error if the exporter cannot be created.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	if !viper.GetBool("tracing.enabled") {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var options []otlptracehttp.Option
+	if endpoint := viper.GetString("tracing.endpoint"); endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(endpoint))
+	}
+	if viper.GetBool("tracing.insecure") {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	sampleRatio := 1.0
+	if viper.IsSet("tracing.sample_ratio") {
+		sampleRatio = viper.GetFloat64("tracing.sample_ratio")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", ServiceName()),
+			attribute.String("service.version", version.Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// ServiceName returns the configured tracing.service_name, or DefaultServiceName.
+func ServiceName() string {
+	if serviceName := viper.GetString("tracing.service_name"); serviceName != "" {
+		return serviceName
+	}
+	return DefaultServiceName
+}
+
+// Start starts a span named name as a child of the span in ctx, using the global tracer provider.
+//
+// Parameters:
+//   - ctx: Context holding the parent span, if any.
+//   - name: The name of the span.
+//   - attributes: Attributes of the span.
+//
+// Returns:
+//   - context.Context: ctx with the new span.
+//   - trace.Span: The new span. End it with End.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// StartClient starts a span for a call to another system, such as Netbox or the database.
+func StartClient(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...),
+		trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// End ends span, recording err as the error of the span if it is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
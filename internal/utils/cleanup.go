@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/vitistack/ipam-api/internal/logger"
+	"github.com/vitistack/ipam-api/internal/metrics"
+	"github.com/vitistack/ipam-api/internal/repository"
+	"github.com/vitistack/ipam-api/internal/services/netboxservice"
+	"github.com/vitistack/ipam-api/pkg/models/mongodbtypes"
+)
+
+// StartCleanupWorker removes expired services and deletes the addresses without services every 30 seconds
+// until ctx is done. A pass that has started is not cancelled by ctx, so a prefix deleted in Netbox is also
+// deleted from the storage.
+func StartCleanupWorker(ctx context.Context) {
+	logger.Log.Info("Starting cleanup worker...")
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Cleanup worker stopped")
+			return
+		case <-ticker.C:
+		}
+
+		passCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		CleanupExpiredServices(passCtx, repository.GetRepository())
+		CleanupRegistrationsWithoutServices(passCtx, repository.GetRepository())
+		cancel()
+	}
+}
+
+func CleanupExpiredServices(ctx context.Context, repo repository.Repository) {
+	err := repo.RemoveExpiredServices(ctx, time.Now())
+	if err != nil {
+		logger.Log.Errorf("could not delete service: %v", err)
+		return
+	}
+
+}
+
+func CleanupRegistrationsWithoutServices(ctx context.Context, repo repository.Repository) {
+	registrations, err := GetPrefixesWithNoServices(ctx, repo)
+	if err != nil {
+		logger.Log.Errorf("repository query failed: %v", err)
+		return
+	}
+
+	for _, prefix := range registrations {
+		// Delete the prefix in Netbox
+		err := netboxservice.DeleteNetboxPrefix(ctx, prefix.NetboxID)
+
+		if err != nil {
+			logger.Log.Errorf("could not delete prefix from Netbox: %v", err)
+			metrics.CleanupDeletions.WithLabelValues(metrics.ResultError).Inc()
+		} else {
+			// Delete from the repository
+			err = repo.DeleteAddress(ctx, prefix.ID)
+			if err != nil {
+				logger.Log.Errorf("could not delete prefix from repository: %v", err)
+			} else {
+				logger.Log.Infof("Deleted prefix %s from repository", prefix.Address)
+
+			}
+			logger.Log.Infof("Deleted prefix %s from Netbox", prefix.Address)
+			metrics.CleanupDeletions.WithLabelValues(metrics.Result(err)).Inc()
+
+		}
+
+	}
+}
+
+func GetPrefixesWithNoServices(ctx context.Context, repo repository.Repository) ([]mongodbtypes.Address, error) {
+	return repo.FindAddresses(ctx, repository.AddressQuery{WithoutServices: true})
+}
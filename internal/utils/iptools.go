@@ -22,8 +22,12 @@ func IPFamilyFromPrefix(prefix string) (string, error) {
 	return "ipv6", nil
 }
 
+// NormalizeCIDR appends the host prefix length (/32 for IPv4, /128 for IPv6) to an address without one.
 func NormalizeCIDR(input string) string {
 	if !strings.Contains(input, "/") {
+		if ip := net.ParseIP(input); ip != nil && ip.To4() == nil {
+			return input + "/128"
+		}
 		return input + "/32"
 	}
 	return input
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spf13/viper"
+)
+
+// HostPrefixLength returns the prefix length of a single host address in the given IP family.
+func HostPrefixLength(ipFamily string) int {
+	if ipFamily == "ipv6" {
+		return 128
+	}
+	return 32
+}
+
+// PrefixLengthLimits returns the smallest and largest prefix length that may be allocated in a zone
+// for the given IP family. Limits are read from allocation.prefix_limits.<zone>.<ip_family>, falling
+// back to allocation.prefix_limits.default.<ip_family>. Without configuration only host prefixes
+// (/32 and /128) are allowed.
+//
+// Parameters:
+//   - zone: The zone the prefix is allocated in.
+//   - ipFamily: "ipv4" or "ipv6".
+//
+// Returns:
+//   - int: The smallest allowed prefix length (largest subnet).
+//   - int: The largest allowed prefix length (smallest subnet).
+func PrefixLengthLimits(zone, ipFamily string) (int, int) {
+	hostLength := HostPrefixLength(ipFamily)
+	minLength, maxLength := hostLength, hostLength
+
+	for _, scope := range []string{"default", zone} {
+		key := fmt.Sprintf("allocation.prefix_limits.%s.%s", scope, ipFamily)
+		if viper.IsSet(key + ".min") {
+			minLength = viper.GetInt(key + ".min")
+		}
+		if viper.IsSet(key + ".max") {
+			maxLength = viper.GetInt(key + ".max")
+		}
+	}
+
+	return minLength, maxLength
+}
+
+// ValidatePrefixLength checks that prefixLength is within the limits configured for the zone and IP family.
+func ValidatePrefixLength(zone, ipFamily string, prefixLength int) error {
+	if prefixLength < 1 || prefixLength > HostPrefixLength(ipFamily) {
+		return fmt.Errorf("invalid prefix length /%d for %s", prefixLength, ipFamily)
+	}
+
+	minLength, maxLength := PrefixLengthLimits(zone, ipFamily)
+	if prefixLength < minLength || prefixLength > maxLength {
+		return fmt.Errorf("prefix length /%d is not allowed for %s in zone %s, must be between /%d and /%d",
+			prefixLength, ipFamily, zone, minLength, maxLength)
+	}
+
+	return nil
+}
+
+// PrefixLength returns the prefix length of a CIDR, or the host prefix length if prefix has no length.
+func PrefixLength(prefix string) (int, error) {
+	_, ipNet, err := net.ParseCIDR(NormalizeCIDR(prefix))
+	if err != nil {
+		return 0, fmt.Errorf("invalid ip prefix: %s", prefix)
+	}
+
+	ones, _ := ipNet.Mask.Size()
+	return ones, nil
+}
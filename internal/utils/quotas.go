@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// AddressQuota limits the registrations of a cluster in a zone. Zero fields are not limited.
+type AddressQuota struct {
+	// AddressesPerCluster is the most addresses the services of a cluster may have in the zone.
+	AddressesPerCluster int
+	// AddressesPerNamespace is the most addresses the services of a namespace may have in the zone.
+	AddressesPerNamespace int
+	// IPv6MinPrefixLength is the shortest IPv6 prefix length, that is the largest IPv6 prefix, the
+	// cluster may register.
+	IPv6MinPrefixLength int
+}
+
+// AddressQuotaFor returns the quota of a cluster in a zone. Each limit is read from
+// quotas.clusters.<cluster_id>, falling back to quotas.zones.<zone> and then quotas.default, with the keys
+// addresses_per_cluster, addresses_per_namespace and ipv6_min_prefix_length.
+//
+// Parameters:
+//   - zone: The zone the address is registered in.
+//   - clusterID: The cluster of the service.
+//
+// Returns:
+//   - AddressQuota: The limits that apply.
+func AddressQuotaFor(zone, clusterID string) AddressQuota {
+	// viper stores the map keys in lower case
+	scopes := []string{"quotas.default", "quotas.zones." + strings.ToLower(zone)}
+	if clusterID != "" {
+		scopes = append(scopes, "quotas.clusters."+strings.ToLower(clusterID))
+	}
+
+	var quota AddressQuota
+	for _, scope := range scopes {
+		for key, limit := range map[string]*int{
+			"addresses_per_cluster":   &quota.AddressesPerCluster,
+			"addresses_per_namespace": &quota.AddressesPerNamespace,
+			"ipv6_min_prefix_length":  &quota.IPv6MinPrefixLength,
+		} {
+			if viper.IsSet(scope + "." + key) {
+				*limit = viper.GetInt(scope + "." + key)
+			}
+		}
+	}
+	return quota
+}
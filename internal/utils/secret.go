@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/argon2"
+)
+
+// Parameters of the argon2id verifiers, following the OWASP recommendation of 19 MiB, 2 iterations and
+// 1 degree of parallelism. They are stored in each verifier, so they can be raised without rehashing.
+const (
+	argon2Memory      = 19 * 1024
+	argon2Iterations  = 2
+	argon2Parallelism = 1
+	argon2SaltLength  = 16
+	argon2KeyLength   = 32
+)
+
+// argon2Slots limits the number of concurrent argon2 computations, since each one allocates argon2Memory KiB.
+var argon2Slots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// MinSecretLookupKeyLength is the minimum length of a secret lookup key.
+const MinSecretLookupKeyLength = 32
+
+// DefaultSecretKeyID is the ID of the key in the secret_lookup_key setting. Addresses stored without a key
+// ID have a lookup key derived with it.
+const DefaultSecretKeyID = "default"
+
+// secretLookupKeys returns the configured lookup keys by ID and the ID of the current key. The keys are
+// read from secret_lookup_keys, a map of key ID to key, and from secret_lookup_key, which is the key with
+// DefaultSecretKeyID. secret_lookup_key_id selects the current key and defaults to DefaultSecretKeyID.
+func secretLookupKeys() (map[string]string, string, error) {
+	keys := viper.GetStringMapString("secret_lookup_keys")
+	if key := viper.GetString("secret_lookup_key"); key != "" {
+		if _, ok := keys[DefaultSecretKeyID]; !ok {
+			keys[DefaultSecretKeyID] = key
+		}
+	}
+
+	for id, key := range keys {
+		if len(key) < MinSecretLookupKeyLength {
+			return nil, "", fmt.Errorf("secret lookup key %q must be at least %d characters", id, MinSecretLookupKeyLength)
+		}
+	}
+
+	// viper stores the map keys in lower case
+	current := strings.ToLower(viper.GetString("secret_lookup_key_id"))
+	if current == "" {
+		current = DefaultSecretKeyID
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, "", fmt.Errorf("secret lookup key %q is not configured", current)
+	}
+
+	return keys, current, nil
+}
+
+// ValidateSecretLookupKeys checks that the current secret lookup key is configured and that every
+// configured key is long enough.
+//
+// Returns:
+//   - error: An error describing the first problem found.
+func ValidateSecretLookupKeys() error {
+	_, _, err := secretLookupKeys()
+	return err
+}
+
+// CurrentSecretKeyID returns the ID of the key used for new lookup keys.
+//
+// Returns:
+//   - string: The key ID.
+//   - error: An error if the secret lookup keys are not configured.
+func CurrentSecretKeyID() (string, error) {
+	_, current, err := secretLookupKeys()
+	return current, err
+}
+
+// SecretKeyIDs returns the IDs of every configured secret lookup key, the current key first and the
+// others in sorted order. Addresses are looked up with each of them, so addresses stored with a retired
+// key are found until they have been moved to the current key.
+//
+// Returns:
+//   - []string: The key IDs.
+//   - error: An error if the secret lookup keys are not configured.
+func SecretKeyIDs() ([]string, error) {
+	keys, current, err := secretLookupKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{current}
+	for _, id := range slices.Sorted(maps.Keys(keys)) {
+		if id != current {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// SecretLookupKey returns the key used to find the addresses registered with secret: an HMAC-SHA256 of
+// the secret keyed with the secret lookup key with the given ID. The lookup key cannot be reversed to the
+// secret.
+//
+// Parameters:
+//   - secret: The secret provided by the client.
+//   - keyID: The ID of the secret lookup key. Empty means DefaultSecretKeyID.
+//
+// Returns:
+//   - string: The base64 encoded lookup key.
+//   - error: An error if the key is not configured.
+func SecretLookupKey(secret, keyID string) (string, error) {
+	keys, _, err := secretLookupKeys()
+	if err != nil {
+		return "", err
+	}
+
+	if keyID == "" {
+		keyID = DefaultSecretKeyID
+	}
+	key, ok := keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("secret lookup key %q is not configured", keyID)
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// HashSecret returns an argon2id verifier for secret with a random salt, in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>.
+//
+// Parameters:
+//   - secret: The secret provided by the client.
+//
+// Returns:
+//   - string: The verifier.
+//   - error: An error if no random salt can be generated.
+func HashSecret(secret string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	argon2Slots <- struct{}{}
+	hash := argon2.IDKey([]byte(secret), salt, argon2Iterations, argon2Memory, argon2Parallelism, argon2KeyLength)
+	<-argon2Slots
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Iterations, argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifySecret reports whether secret matches a verifier returned by HashSecret.
+//
+// Parameters:
+//   - secret: The secret provided by the client.
+//   - verifier: The stored verifier.
+//
+// Returns:
+//   - bool: True if the secret matches.
+//   - error: An error if the verifier is malformed.
+func VerifySecret(secret, verifier string) (bool, error) {
+	parts := strings.Split(verifier, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, errors.New("unsupported secret verifier")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var (
+		memory, iterations uint32
+		parallelism        uint8
+	)
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, fmt.Errorf("invalid argon2 parameters %q: %w", parts[3], err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2 hash: %w", err)
+	}
+
+	argon2Slots <- struct{}{}
+	// #nosec G115 -- the hash length is the key length chosen by HashSecret
+	hash := argon2.IDKey([]byte(secret), salt, iterations, memory, parallelism, uint32(len(expected)))
+	<-argon2Slots
+
+	return subtle.ConstantTimeCompare(hash, expected) == 1, nil
+}
+
+// LegacySecretsEnabled reports whether enc_key and enc_iv are configured, so that addresses stored with
+// an encrypted secret by earlier versions can still be found and upgraded.
+func LegacySecretsEnabled() bool {
+	return viper.GetString("enc_key") != "" && viper.GetString("enc_iv") != ""
+}
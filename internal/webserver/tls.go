@@ -0,0 +1,179 @@
+package webserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/logger"
+)
+
+// certificateCheckInterval is how often the certificate and key files are checked for changes.
+const certificateCheckInterval = 10 * time.Second
+
+// certificateReloader serves a TLS certificate from a certificate and key file and reloads it when either
+// file changes on disk, so a renewed certificate is used without a restart.
+type certificateReloader struct {
+	mu          sync.RWMutex
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	modTimes    [2]time.Time
+	lastCheck   time.Time
+}
+
+// newCertificateReloader loads the certificate and key at certFile and keyFile.
+//
+// Parameters:
+//   - certFile: Path to the PEM encoded certificate chain.
+//   - keyFile: Path to the PEM encoded private key.
+//
+// Returns:
+//   - *certificateReloader: The reloader serving the certificate.
+//   - error: An error if the certificate or key cannot be loaded.
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: filepath.Clean(certFile), keyFile: filepath.Clean(keyFile)}
+
+	modTimes, err := r.modTimesOnDisk()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// modTimesOnDisk returns the modification times of the certificate and key files.
+func (r *certificateReloader) modTimesOnDisk() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, fmt.Errorf("failed to read TLS certificate: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// load reads the certificate and key. The caller must hold the write lock, or be the constructor.
+func (r *certificateReloader) load(modTimes [2]time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.certificate = &certificate
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+	return nil
+}
+
+// reloadIfChanged reloads the certificate when the modification time of the certificate or key file has
+// changed. A failed reload keeps the previous certificate, since the files may be replaced one at a time.
+func (r *certificateReloader) reloadIfChanged() {
+	r.mu.RLock()
+	due := time.Since(r.lastCheck) >= certificateCheckInterval
+	r.mu.RUnlock()
+
+	if !due {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < certificateCheckInterval {
+		return
+	}
+	r.lastCheck = time.Now()
+
+	modTimes, err := r.modTimesOnDisk()
+	if err != nil {
+		logger.Log.Errorf("Failed to check TLS certificate: %v", err)
+		return
+	}
+	if modTimes == r.modTimes {
+		return
+	}
+
+	if err := r.load(modTimes); err != nil {
+		logger.Log.Errorf("Failed to reload TLS certificate, keeping previous certificate: %v", err)
+		return
+	}
+
+	logger.Log.Infof("Reloaded TLS certificate from %s", r.certFile)
+}
+
+// GetCertificate returns the current certificate. It is used as tls.Config.GetCertificate.
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.reloadIfChanged()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+// tlsConfigFromViper returns the TLS configuration of the server from the server.tls settings, or nil if
+// no certificate is configured and the server should serve plain HTTP:
+//   - server.tls.cert_file and server.tls.key_file: the certificate and key, reloaded when they change.
+//   - server.tls.client_ca_file: CA certificates that client certificates are verified against.
+//   - server.tls.require_client_cert: reject connections without a valid client certificate. Without it,
+//     client certificates are verified when presented and callers may authenticate with a token instead.
+//
+// Returns:
+//   - *tls.Config: The TLS configuration, or nil for plain HTTP.
+//   - error: An error if the settings are incomplete or a file cannot be loaded.
+func tlsConfigFromViper() (*tls.Config, error) {
+	certFile := viper.GetString("server.tls.cert_file")
+	keyFile := viper.GetString("server.tls.key_file")
+	clientCAFile := viper.GetString("server.tls.client_ca_file")
+
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("server.tls.client_ca_file requires server.tls.cert_file and server.tls.key_file")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("server.tls.cert_file and server.tls.key_file must be set together")
+	}
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(filepath.Clean(clientCAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if viper.GetBool("server.tls.require_client_cert") {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if viper.GetBool("server.tls.require_client_cert") {
+		return nil, errors.New("server.tls.require_client_cert requires server.tls.client_ca_file")
+	}
+
+	return config, nil
+}
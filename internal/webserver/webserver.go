@@ -1,26 +1,112 @@
 package webserver
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
 	"github.com/vitistack/ipam-api/internal/logger"
 	"github.com/vitistack/ipam-api/internal/middleware"
 	"github.com/vitistack/ipam-api/internal/routes"
 )
 
-func InitHTTPServer() {
+// InitHTTPServer serves the API on server.address until ctx is done, over TLS if a certificate is
+// configured in server.tls. It then stops accepting connections and waits up to server.shutdown_timeout
+// for the requests in progress to finish, so an allocation is not cut off between its Netbox and storage
+// writes.
+//
+// Parameters:
+//   - ctx: Context that stops the server when done.
+//
+// Returns:
+//   - error: An error if the TLS settings are invalid, the server cannot listen, or requests were still in
+//     progress after the timeout.
+func InitHTTPServer(ctx context.Context) error {
+	tlsConfig, err := tlsConfigFromViper()
+	if err != nil {
+		return err
+	}
+
 	gin.SetMode(gin.ReleaseMode)
-	server := gin.New() // or gin.Default()
+	engine, err := newEngine()
+	if err != nil {
+		return err
+	}
 
-	server.Use(gin.Recovery())
-	server.Use(middleware.ZapLogger())
-	server.Use(middleware.ZapErrorLogger())
+	server := &http.Server{
+		Handler:           engine,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
 
-	routes.SetupRoutes(server)
+	listener, err := net.Listen("tcp", viper.GetString("server.address"))
+	if err != nil {
+		return err
+	}
 
-	logger.Log.Info("Vitistack IPAM API server starting on port 3000")
-	err := server.Run(":3000")
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsConfig == nil {
+			logger.Log.Infof("Vitistack IPAM API server starting on %s", listener.Addr())
+			serveErr <- server.Serve(listener)
+			return
+		}
+		logger.Log.Infof("Vitistack IPAM API server starting on %s with TLS", listener.Addr())
+		serveErr <- server.ServeTLS(listener, "", "")
+	}()
 
-	if err != nil {
-		panic(err)
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
 	}
+
+	timeout := viper.GetDuration("server.shutdown_timeout")
+	logger.Log.Infof("Vitistack IPAM API server stopping, waiting up to %s for requests in progress...", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Log.Info("Vitistack IPAM API server stopped")
+	return nil
+}
+
+// newEngine returns the gin engine serving the routes of the API behind the middleware. The client IP of
+// a request is only taken from the X-Forwarded-For header when the request comes from one of the proxies in
+// server.trusted_proxies, which trusts no proxy by default, so callers cannot pick the IP they are rate
+// limited by.
+//
+// Returns:
+//   - *gin.Engine: The engine.
+//   - error: An error if server.trusted_proxies holds an invalid IP address or CIDR.
+func newEngine() (*gin.Engine, error) {
+	engine := gin.New() // or gin.Default()
+
+	if err := engine.SetTrustedProxies(viper.GetStringSlice("server.trusted_proxies")); err != nil {
+		return nil, fmt.Errorf("invalid server.trusted_proxies: %w", err)
+	}
+
+	engine.Use(gin.Recovery())
+	engine.Use(middleware.Tracing())
+	engine.Use(middleware.Metrics())
+	// The loggers run before authentication and rate limiting, so rejected requests are logged too
+	engine.Use(middleware.ZapLogger())
+	engine.Use(middleware.ZapErrorLogger())
+	// Limit by IP before authenticating, so requests with invalid credentials are limited as well
+	engine.Use(middleware.RateLimitIP())
+	engine.Use(middleware.Authenticate())
+	engine.Use(middleware.RateLimitIdentity())
+
+	routes.SetupRoutes(engine)
+	return engine, nil
 }
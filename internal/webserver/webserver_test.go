@@ -0,0 +1,72 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/vitistack/ipam-api/internal/auth"
+	"github.com/vitistack/ipam-api/internal/logger"
+)
+
+// TestInvalidTokensAreRateLimitedByIP checks that requests with an invalid token count against the bucket of
+// their IP address, and that a forged X-Forwarded-For header does not give them a new bucket.
+func TestInvalidTokensAreRateLimitedByIP(t *testing.T) {
+	logger.InitConsoleLogger()
+	gin.SetMode(gin.TestMode)
+
+	previousHTTP := logger.HTTP
+	previousTokens := auth.Tokens
+	previousRate := viper.Get("ratelimit.requests_per_second")
+	previousBurst := viper.Get("ratelimit.burst")
+	t.Cleanup(func() {
+		logger.HTTP = previousHTTP
+		auth.Tokens = previousTokens
+		viper.Set("ratelimit.requests_per_second", previousRate)
+		viper.Set("ratelimit.burst", previousBurst)
+	})
+	logger.HTTP = logger.Log
+	auth.Tokens = &auth.TokenStore{}
+	if err := auth.Tokens.Load("", "the-token"); err != nil {
+		t.Fatalf("failed to load token: %v", err)
+	}
+	viper.Set("ratelimit.requests_per_second", 1)
+	viper.Set("ratelimit.burst", 2)
+
+	engine, err := newEngine()
+	if err != nil {
+		t.Fatalf("failed to create engine: %v", err)
+	}
+
+	statuses := make([]int, 0, 3)
+	for i := range 3 {
+		request := httptest.NewRequest(http.MethodGet, "/v2/address", nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		request.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i+1))
+		request.Header.Set("Authorization", "Bearer not-the-token")
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		statuses = append(statuses, recorder.Code)
+	}
+
+	expected := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Errorf("expected statuses %v, got %v", expected, statuses)
+			break
+		}
+	}
+}
+
+func TestNewEngineRejectsInvalidTrustedProxies(t *testing.T) {
+	previousProxies := viper.Get("server.trusted_proxies")
+	t.Cleanup(func() { viper.Set("server.trusted_proxies", previousProxies) })
+	viper.Set("server.trusted_proxies", []string{"not-an-ip"})
+
+	if _, err := newEngine(); err == nil {
+		t.Error("expected an invalid trusted proxy to be rejected")
+	}
+}
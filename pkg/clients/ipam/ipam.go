@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/vitistack/ipam-api/pkg/models/apicontracts"
@@ -103,3 +105,149 @@ func (c *IPAMClient) DeleteCluster(request apicontracts.IpamAPIDeleteClusterRequ
 
 	return apiResponse, nil
 }
+
+// GetAddress fetches an address registered with the given secret, zone, IP family and address.
+//
+// request is the IpamAPIGetAddressRequest identifying the address. The secret is sent in the
+// X-Ipam-Secret header and never as part of the URL.
+//
+// The function returns an IpamAPIAddressResponse if the address is found.
+// If the API responds with a non-2xx status code, an error is returned.
+func (c *IPAMClient) GetAddress(request apicontracts.IpamAPIGetAddressRequest) (apicontracts.IpamAPIAddressResponse, error) {
+	query := url.Values{}
+	query.Set("zone", request.Zone)
+	query.Set("ip_family", request.IPFamily)
+	query.Set("address", request.Address)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/address?%s", c.baseURL, query.Encode()), nil)
+
+	if err != nil {
+		return apicontracts.IpamAPIAddressResponse{}, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	req.Header.Set("X-Ipam-Secret", request.Secret)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return apicontracts.IpamAPIAddressResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apicontracts.IpamAPIAddressResponse{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return apicontracts.IpamAPIAddressResponse{}, errors.New(string(bodyBytes))
+	}
+
+	var apiResponse apicontracts.IpamAPIAddressResponse
+	if err := json.Unmarshal(bodyBytes, &apiResponse); err != nil {
+		return apicontracts.IpamAPIAddressResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return apiResponse, nil
+}
+
+// ListAddresses lists addresses matching the filters in the given request.
+//
+// request is the IpamAPIListAddressesRequest containing the filters. Empty filters are ignored.
+// Set request.Cursor to the NextCursor of a previous response to fetch the next page.
+//
+// The function returns an IpamAPIListAddressesResponse if the operation succeeds.
+// If the API responds with a non-2xx status code, an error is returned.
+func (c *IPAMClient) ListAddresses(request apicontracts.IpamAPIListAddressesRequest) (apicontracts.IpamAPIListAddressesResponse, error) {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"zone":         request.Zone,
+		"ip_family":    request.IPFamily,
+		"cluster_id":   request.ClusterID,
+		"namespace_id": request.NamespaceID,
+		"service_name": request.ServiceName,
+		"cursor":       request.Cursor,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if request.Limit > 0 {
+		query.Set("limit", strconv.Itoa(request.Limit))
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/addresses?%s", c.baseURL, query.Encode()), nil)
+
+	if err != nil {
+		return apicontracts.IpamAPIListAddressesResponse{}, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return apicontracts.IpamAPIListAddressesResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apicontracts.IpamAPIListAddressesResponse{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return apicontracts.IpamAPIListAddressesResponse{}, errors.New(string(bodyBytes))
+	}
+
+	var apiResponse apicontracts.IpamAPIListAddressesResponse
+	if err := json.Unmarshal(bodyBytes, &apiResponse); err != nil {
+		return apicontracts.IpamAPIListAddressesResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return apiResponse, nil
+}
+
+// RegisterBatch registers several addresses in one call.
+//
+// request is the IpamAPIBatchRequest containing either the items to register, or a count and a
+// template to generate the items from.
+//
+// The function returns an IpamAPIBatchResponse with the result of every item if the operation succeeds.
+// If the API responds with a non-2xx status code, no addresses are left registered and an error is returned.
+func (c *IPAMClient) RegisterBatch(request apicontracts.IpamAPIBatchRequest) (apicontracts.IpamAPIBatchResponse, error) {
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return apicontracts.IpamAPIBatchResponse{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/addresses:batch", c.baseURL), bytes.NewReader(requestBytes))
+
+	if err != nil {
+		return apicontracts.IpamAPIBatchResponse{}, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return apicontracts.IpamAPIBatchResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apicontracts.IpamAPIBatchResponse{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return apicontracts.IpamAPIBatchResponse{}, errors.New(string(bodyBytes))
+	}
+
+	var apiResponse apicontracts.IpamAPIBatchResponse
+	if err := json.Unmarshal(bodyBytes, &apiResponse); err != nil {
+		return apicontracts.IpamAPIBatchResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return apiResponse, nil
+}
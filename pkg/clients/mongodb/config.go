@@ -0,0 +1,167 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultPort is the port used for hosts configured without one.
+const DefaultPort = 27017
+
+// MongoConfig describes how to connect to MongoDB. Either URI is set to a complete connection string, or
+// the connection string is built from the other fields.
+type MongoConfig struct {
+	// URI is a complete connection string. When set, the other fields are ignored.
+	URI string `json:"uri"`
+
+	// Hosts are the members of the deployment as host or host:port.
+	Hosts []string `json:"hosts"`
+	// Port is used for hosts without a port. Zero means DefaultPort.
+	Port       int    `json:"port"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	AuthSource string `json:"auth_source"`
+	ReplicaSet string `json:"replica_set"`
+	TLS        TLSConfig
+
+	ConnectTimeout         time.Duration `json:"connect_timeout"`
+	ServerSelectionTimeout time.Duration `json:"server_selection_timeout"`
+	MaxPoolSize            uint64        `json:"max_pool_size"`
+	MinPoolSize            uint64        `json:"min_pool_size"`
+}
+
+// TLSConfig configures TLS for the MongoDB connection.
+type TLSConfig struct {
+	Enabled bool `json:"enabled"`
+	// CAFile is a PEM file with the certificate authorities to verify the server with. Empty uses the system pool.
+	CAFile string `json:"ca_file"`
+	// CertificateKeyFile is a PEM file with the client certificate and its private key, for mutual TLS.
+	CertificateKeyFile string `json:"certificate_key_file"`
+	// Insecure disables verification of the server certificate. Only for testing.
+	Insecure bool `json:"insecure"`
+}
+
+// ConfigFromViper reads the MongoConfig from the mongodb block of the configuration. mongodb.hosts takes
+// precedence over the single mongodb.host.
+//
+// Returns:
+//   - MongoConfig: The connection settings.
+func ConfigFromViper() MongoConfig {
+	hosts := viper.GetStringSlice("mongodb.hosts")
+	if len(hosts) == 0 && viper.GetString("mongodb.host") != "" {
+		hosts = []string{viper.GetString("mongodb.host")}
+	}
+
+	return MongoConfig{
+		URI:        strings.TrimSpace(viper.GetString("mongodb.uri")),
+		Hosts:      hosts,
+		Port:       viper.GetInt("mongodb.port"),
+		Username:   viper.GetString("mongodb.username"),
+		Password:   viper.GetString("mongodb.password"),
+		AuthSource: viper.GetString("mongodb.auth_source"),
+		ReplicaSet: viper.GetString("mongodb.replica_set"),
+		TLS: TLSConfig{
+			Enabled:            viper.GetBool("mongodb.tls.enabled"),
+			CAFile:             viper.GetString("mongodb.tls.ca_file"),
+			CertificateKeyFile: viper.GetString("mongodb.tls.certificate_key_file"),
+			Insecure:           viper.GetBool("mongodb.tls.insecure"),
+		},
+		ConnectTimeout:         viper.GetDuration("mongodb.connect_timeout"),
+		ServerSelectionTimeout: viper.GetDuration("mongodb.server_selection_timeout"),
+		MaxPoolSize:            viper.GetUint64("mongodb.max_pool_size"),
+		MinPoolSize:            viper.GetUint64("mongodb.min_pool_size"),
+	}
+}
+
+// ConnectionString returns the connection string for the configuration. The same string is used by the
+// driver and by mongodump, so both connect the same way.
+//
+// Returns:
+//   - string: The connection string.
+//   - error: An error if no host is configured or a host is invalid.
+func (c MongoConfig) ConnectionString() (string, error) {
+	if c.URI != "" {
+		if _, err := url.Parse(c.URI); err != nil {
+			return "", fmt.Errorf("invalid MongoDB URI: %w", err)
+		}
+		return c.URI, nil
+	}
+
+	if len(c.Hosts) == 0 {
+		return "", errors.New("no MongoDB host configured")
+	}
+
+	port := c.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	hosts := make([]string, 0, len(c.Hosts))
+	for _, host := range c.Hosts {
+		host = strings.TrimSpace(host)
+		if host == "" || strings.ContainsAny(host, " @/?#,\n\r\t") {
+			return "", fmt.Errorf("invalid MongoDB host %q", host)
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+		}
+		hosts = append(hosts, host)
+	}
+
+	authSource := c.AuthSource
+	if authSource == "" {
+		authSource = "admin"
+	}
+
+	query := url.Values{}
+	query.Set("readPreference", "primary")
+	if c.Username != "" {
+		query.Set("authSource", authSource)
+	}
+	if c.ReplicaSet != "" {
+		query.Set("replicaSet", c.ReplicaSet)
+	}
+	query.Set("tls", strconv.FormatBool(c.TLS.Enabled))
+	if c.TLS.Enabled {
+		if c.TLS.CAFile != "" {
+			query.Set("tlsCAFile", c.TLS.CAFile)
+		}
+		if c.TLS.CertificateKeyFile != "" {
+			query.Set("tlsCertificateKeyFile", c.TLS.CertificateKeyFile)
+		}
+		if c.TLS.Insecure {
+			query.Set("tlsInsecure", "true")
+		}
+	}
+	if c.ConnectTimeout > 0 {
+		query.Set("connectTimeoutMS", strconv.FormatInt(c.ConnectTimeout.Milliseconds(), 10))
+	}
+	if c.ServerSelectionTimeout > 0 {
+		query.Set("serverSelectionTimeoutMS", strconv.FormatInt(c.ServerSelectionTimeout.Milliseconds(), 10))
+	}
+	if c.MaxPoolSize > 0 {
+		query.Set("maxPoolSize", strconv.FormatUint(c.MaxPoolSize, 10))
+	}
+	if c.MinPoolSize > 0 {
+		query.Set("minPoolSize", strconv.FormatUint(c.MinPoolSize, 10))
+	}
+
+	connectionString := url.URL{
+		Scheme:   "mongodb",
+		Host:     strings.Join(hosts, ","),
+		Path:     "/",
+		RawQuery: query.Encode(),
+	}
+	if c.Username != "" {
+		connectionString.User = url.UserPassword(c.Username, c.Password)
+	}
+
+	return connectionString.String(), nil
+}
@@ -3,54 +3,49 @@ package mongodb
 import (
 	"context"
 	"fmt"
-	"sync"
 	"time"
 
-	"github.com/vitistack/ipam-api/internal/logger"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
-var (
-	clientInstance *mongo.Client
-	clientOnce     sync.Once
-)
-
-type MongoConfig struct {
-	Host     string `json:"host"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
+var clientInstance *mongo.Client
 
-// InitClient initializes and returns a singleton MongoDB client instance using the provided MongoConfig.
-// It constructs the MongoDB URI from the configuration, establishes a connection, and pings the database
-// to ensure connectivity. If the connection or ping fails, the function logs a fatal error and terminates
-// the application.
+// InitClient connects to MongoDB with the provided MongoConfig and pings the deployment to ensure
+// connectivity. The client is kept for GetClient.
 //
 // Parameters:
+//   - ctx: Context for connecting and pinging.
 //   - config: MongoConfig containing the MongoDB connection details.
 //
 // Returns:
-//   - *mongo.Client: A pointer to the initialized MongoDB client instance.
-func InitClient(config MongoConfig) *mongo.Client {
-	uri := fmt.Sprintf("mongodb://%v:%v@%v:27017/?authSource=admin&readPreference=primary&ssl=false", config.Username, config.Password, config.Host)
-	clientOnce.Do(func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		var err error
-		clientInstance, err = mongo.Connect(options.Client().ApplyURI(uri))
-		if err != nil {
-			logger.Log.Fatalf("MongoDB connection error: %v", err)
-		}
-
-		if err := clientInstance.Ping(ctx, nil); err != nil {
-			logger.Log.Fatalf("MongoDB ping failed: %v", err)
-
-		}
-	})
-
-	return clientInstance
+//   - *mongo.Client: A pointer to the connected MongoDB client instance.
+//   - error: An error if the configuration is invalid or MongoDB cannot be reached.
+func InitClient(ctx context.Context, config MongoConfig) (*mongo.Client, error) {
+	uri, err := config.ConnectionString()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("MongoDB connection error: %w", err)
+	}
+
+	pingTimeout := 10 * time.Second
+	if config.ServerSelectionTimeout > pingTimeout {
+		pingTimeout = config.ServerSelectionTimeout
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := client.Ping(pingCtx, nil); err != nil {
+		_ = client.Disconnect(context.WithoutCancel(ctx))
+		return nil, fmt.Errorf("MongoDB ping failed: %w", err)
+	}
+
+	clientInstance = client
+	return clientInstance, nil
 }
 
 // GetClient returns the initialized MongoDB client
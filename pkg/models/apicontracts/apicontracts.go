@@ -18,12 +18,39 @@ type Service struct {
 }
 
 type IpamAPIRequest struct {
-	Secret    string  `json:"secret" validate:"required,min=8,max=64" example:"a_secret_value"`
-	Zone      string  `json:"zone" validate:"required" example:"inet"`
-	IPFamily  string  `json:"ip_family" bson:"ip_family" validate:"required,oneof=ipv4 ipv6" example:"ipv4"`
-	Address   string  `json:"address"`
-	Service   Service `json:"service"`
-	NewSecret string  `json:"new_secret,omitempty" bson:"new_secret,omitempty"`
+	Secret       string  `json:"secret" validate:"required,min=8,max=64" example:"a_secret_value"`
+	Zone         string  `json:"zone" validate:"required" example:"inet"`
+	IPFamily     string  `json:"ip_family" bson:"ip_family" validate:"required,oneof=ipv4 ipv6 dual" example:"ipv4"`
+	Address      string  `json:"address"`
+	PrefixLength int     `json:"prefix_length,omitempty" validate:"omitempty,min=1,max=128" example:"28"`
+	Service      Service `json:"service"`
+	NewSecret    string  `json:"new_secret,omitempty" bson:"new_secret,omitempty"`
+}
+
+// IpamAPIBatchRequest registers several addresses in one call. Either Items or Count and Template must be set.
+// With Count, Count requests are generated from Template and the index of each request is appended to the
+// service name ("<service_name>-<index>"), so every generated request registers a separate address.
+type IpamAPIBatchRequest struct {
+	Items    []IpamAPIRequest `json:"items,omitempty" validate:"max=100"`
+	Count    int              `json:"count,omitempty" validate:"omitempty,min=1,max=100" example:"20"`
+	Template *IpamAPIRequest  `json:"template,omitempty"`
+}
+
+type IpamAPIGetAddressRequest struct {
+	Secret   string `header:"X-Ipam-Secret" validate:"required,min=8,max=64"`
+	Zone     string `form:"zone" validate:"required" example:"inet"`
+	IPFamily string `form:"ip_family" validate:"required,oneof=ipv4 ipv6" example:"ipv4"`
+	Address  string `form:"address" validate:"required" example:"10.0.0.1/32"`
+}
+
+type IpamAPIListAddressesRequest struct {
+	Zone        string `form:"zone" example:"inet"`
+	IPFamily    string `form:"ip_family" validate:"omitempty,oneof=ipv4 ipv6" example:"ipv4"`
+	ClusterID   string `form:"cluster_id" example:"123e4567-e89b-12d3-a456-426614174000"`
+	NamespaceID string `form:"namespace_id" example:"123e4567-e89b-12d3-a456-426614174000"`
+	ServiceName string `form:"service_name" example:"service1"`
+	Limit       int    `form:"limit" validate:"omitempty,min=1,max=500" example:"100"`
+	Cursor      string `form:"cursor" validate:"omitempty,hexadecimal,len=24"`
 }
 
 type IpamAPIDeleteClusterRequest struct {
@@ -31,9 +58,44 @@ type IpamAPIDeleteClusterRequest struct {
 }
 
 type IpamAPIResponse struct {
-	Message   string `json:"message"`
-	Address   string `json:"address,omitempty"`
-	ClusterID string `json:"cluster_id,omitempty"`
+	Message   string   `json:"message"`
+	Address   string   `json:"address,omitempty"`
+	Addresses []string `json:"addresses,omitempty" example:"10.0.0.1/32,2001:db8::1/128"`
+	ClusterID string   `json:"cluster_id,omitempty"`
+}
+
+type IpamAPIAddressResponse struct {
+	Zone     string    `json:"zone" example:"inet"`
+	IPFamily string    `json:"ip_family" example:"ipv4"`
+	Address  string    `json:"address" example:"10.0.0.1/32"`
+	NetboxID int       `json:"netbox_id" example:"1234"`
+	Services []Service `json:"services"`
+}
+
+type IpamAPIListAddressesResponse struct {
+	Addresses  []IpamAPIAddressResponse `json:"addresses"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+const (
+	BatchStatusRegistered = "registered"
+	BatchStatusFailed     = "failed"
+	BatchStatusInvalid    = "invalid"
+	BatchStatusRolledBack = "rolled_back"
+	BatchStatusSkipped    = "skipped"
+)
+
+type IpamAPIBatchItemResult struct {
+	Index     int      `json:"index"`
+	Status    string   `json:"status" enums:"registered,failed,invalid,rolled_back,skipped" example:"registered"`
+	Address   string   `json:"address,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type IpamAPIBatchResponse struct {
+	Message string                   `json:"message"`
+	Results []IpamAPIBatchItemResult `json:"results"`
 }
 
 type CustomFields struct {
@@ -74,8 +136,16 @@ type HTTPError struct {
 	Code    int    `json:"code"`
 }
 
+// HealthResponse reports the status of the IPAM-API and of each check behind it. A check is "ok" or
+// describes why it failed.
+type HealthResponse struct {
+	Status string            `json:"status" example:"ok"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
 // GetNextPrefixPayload constructs a NextPrefixPayload based on the provided IpamApiRequest and NetboxPrefix container.
-// It determines the prefix length according to the IP family (IPv4 or IPv6), collects constraint tags from configuration,
+// It uses the requested prefix length, or a host prefix length according to the IP family (IPv4 or IPv6) when none
+// is requested, collects constraint tags from configuration,
 // and populates the payload with relevant VRF, tenant, role, tags, and custom fields information.
 // Parameters:
 //   - request: IpamApiRequest containing request details such as IP family and zone.
@@ -84,12 +154,14 @@ type HTTPError struct {
 // Returns:
 //   - NextPrefixPayload: The constructed payload for the next prefix allocation.
 func GetNextPrefixPayload(request IpamAPIRequest, container responses.NetboxPrefix) NextPrefixPayload {
-	var prefixLength int
-	switch request.IPFamily {
-	case "ipv4":
-		prefixLength = 32
-	case "ipv6":
-		prefixLength = 128
+	prefixLength := request.PrefixLength
+	if prefixLength == 0 {
+		switch request.IPFamily {
+		case "ipv4":
+			prefixLength = 32
+		case "ipv6":
+			prefixLength = 128
+		}
 	}
 
 	tags := []int{}
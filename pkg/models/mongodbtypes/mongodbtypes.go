@@ -15,12 +15,45 @@ type Service struct {
 	DenyExternalCleanup bool       `json:"deny_external_cleanup" bson:"deny_external_cleanup"`
 }
 
+// Schema versions of address documents:
+//   - 1: secret holds the secret encrypted with enc_key and enc_iv.
+//   - 2: secret holds an HMAC lookup key of the secret and secret_hash an argon2id verifier. secret_key_id
+//     is the ID of the key of the HMAC, and is empty for the key with ID "default".
+const (
+	AddressSchemaVersionHashedSecret = 2
+
+	// AddressSchemaVersion is the schema version of address documents written by this version of the
+	// IPAM-API. Migrations bring stored documents up to this version.
+	AddressSchemaVersion = AddressSchemaVersionHashedSecret
+)
+
 type Address struct {
-	ID       bson.ObjectID `json:"-" bson:"_id"`
-	Secret   string        `json:"secret" bson:"secret"`
-	Zone     string        `json:"zone" bson:"zone"`
-	IPFamily string        `json:"ip_family" bson:"ip_family"`
-	NetboxID int           `json:"-" bson:"netbox_id"`
-	Address  string        `json:"address" bson:"address"`
-	Services []Service     `json:"services" bson:"services"`
+	ID            bson.ObjectID `json:"-" bson:"_id"`
+	SchemaVersion int           `json:"-" bson:"schema_version"`
+	Secret        string        `json:"secret" bson:"secret"`
+	SecretHash    string        `json:"-" bson:"secret_hash,omitempty"`
+	SecretKeyID   string        `json:"-" bson:"secret_key_id,omitempty"`
+	Zone          string        `json:"zone" bson:"zone"`
+	IPFamily      string        `json:"ip_family" bson:"ip_family"`
+	NetboxID      int           `json:"-" bson:"netbox_id"`
+	Address       string        `json:"address" bson:"address"`
+	Services      []Service     `json:"services" bson:"services"`
+}
+
+// Compensation is a persisted undo action for a completed step of an allocation saga.
+type Compensation struct {
+	ID            bson.ObjectID `json:"id" bson:"_id"`
+	SagaID        bson.ObjectID `json:"saga_id" bson:"saga_id"`
+	Saga          string        `json:"saga" bson:"saga"`
+	Sequence      int           `json:"sequence" bson:"sequence"`
+	Action        string        `json:"action" bson:"action"`
+	NetboxID      int           `json:"netbox_id,omitempty" bson:"netbox_id,omitempty"`
+	AddressID     bson.ObjectID `json:"address_id,omitzero" bson:"address_id,omitempty"`
+	Address       string        `json:"address,omitempty" bson:"address,omitempty"`
+	Service       *Service      `json:"service,omitempty" bson:"service,omitempty"`
+	Status        string        `json:"status" bson:"status"`
+	Attempts      int           `json:"attempts" bson:"attempts"`
+	LastError     string        `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt     time.Time     `json:"created_at" bson:"created_at"`
+	NextAttemptAt *time.Time    `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
 }